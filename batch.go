@@ -0,0 +1,232 @@
+package natsutil
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultBatchConcurrency is how many requests a batch operation has in flight at once if no concurrency has been
+// configured.
+const DefaultBatchConcurrency = 32
+
+// Headers and values used by the server to mark deletes within a bucket's stream.
+const (
+	kvOperationHeader = "KV-Operation"
+	kvOperationDelete = "DEL"
+	kvOperationPurge  = "PURGE"
+)
+
+// BatchError reports the keys of a batch operation which failed along with the reason each failed.
+type BatchError struct {
+	Errors map[string]error
+}
+
+func (e *BatchError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("%d keys failed, including %q: %v", len(keys), keys[0], e.Errors[keys[0]])
+}
+
+// Unwrap returns the error for each failed key, so that errors.Is reports whether any key failed for a given reason.
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// BatchOption configures GetMany and PutMany.
+type BatchOption func(c *batchConfig)
+
+type batchConfig struct {
+	concurrency int
+	js          nats.JetStreamContext
+}
+
+// WithBatchConcurrency sets how many requests are in flight at once, defaults to DefaultBatchConcurrency.
+func WithBatchConcurrency(concurrency int) BatchOption {
+	return func(c *batchConfig) {
+		c.concurrency = concurrency
+	}
+}
+
+// WithDirectGet makes GetMany fetch entries with the direct get API of the bucket's stream, which can be served by
+// any replica rather than only the stream leader. The bucket must have been created with direct gets allowed.
+//
+// A nats.KeyValue already uses direct gets if they were allowed when it was bound to the bucket, this option is for
+// buckets bound before direct gets were enabled or where the handle cannot be rebound.
+func WithDirectGet(js nats.JetStreamContext) BatchOption {
+	return func(c *batchConfig) {
+		c.js = js
+	}
+}
+
+func newBatchConfig(opts []BatchOption) batchConfig {
+	c := batchConfig{concurrency: DefaultBatchConcurrency}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.concurrency < 1 {
+		c.concurrency = 1
+	}
+	return c
+}
+
+// runBatch calls fn for each key with up to concurrency calls in flight, returning a *BatchError for any failures.
+func runBatch(keys []string, concurrency int, fn func(key string) error) error {
+	work := make(chan string)
+
+	var mu sync.Mutex
+	errs := make(map[string]error)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(keys); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				if err := fn(key); err != nil {
+					mu.Lock()
+					errs[key] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, key := range keys {
+		work <- key
+	}
+	close(work)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return &BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// getMany fetches each key with get.
+func getMany[T any](
+	keys []string,
+	config batchConfig,
+	get func(key string) (KeyValueEntry[T], error),
+) (map[string]KeyValueEntry[T], error) {
+	var mu sync.Mutex
+	entries := make(map[string]KeyValueEntry[T], len(keys))
+
+	err := runBatch(keys, config.concurrency, func(key string) error {
+		entry, err := get(key)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		entries[key] = entry
+		mu.Unlock()
+		return nil
+	})
+
+	return entries, err
+}
+
+// putMany writes each value with put.
+func putMany[T any](
+	values map[string]T,
+	config batchConfig,
+	put func(key string, value T) (uint64, error),
+) (map[string]uint64, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	var mu sync.Mutex
+	revisions := make(map[string]uint64, len(values))
+
+	err := runBatch(keys, config.concurrency, func(key string) error {
+		revision, err := put(key, values[key])
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		revisions[key] = revision
+		mu.Unlock()
+		return nil
+	})
+
+	return revisions, err
+}
+
+// GetMany returns the latest entries for the keys, fetching them concurrently. Keys which could not be fetched,
+// including those which do not exist, are omitted from the result and reported in a *BatchError.
+func (k *kv[T]) GetMany(keys []string, opts ...BatchOption) (entries map[string]KeyValueEntry[T], err error) {
+	op := k.startOperation("GetMany", "")
+	defer func() { op.end(err) }()
+
+	config := newBatchConfig(opts)
+
+	get := k.Get
+	if config.js != nil {
+		get = func(key string) (KeyValueEntry[T], error) {
+			return k.directGet(config.js, key)
+		}
+	}
+
+	return getMany(keys, config, get)
+}
+
+// PutMany places the values into the store concurrently, returning the revision each key was written at. Keys which
+// could not be written are omitted from the result and reported in a *BatchError.
+func (k *kv[T]) PutMany(values map[string]T, opts ...BatchOption) (revisions map[string]uint64, err error) {
+	op := k.startOperation("PutMany", "")
+	defer func() { op.end(err) }()
+
+	return putMany(values, newBatchConfig(opts), k.Put)
+}
+
+// directGet fetches the latest entry for the key directly from the bucket's stream.
+func (k *kv[T]) directGet(js nats.JetStreamContext, key string) (entry KeyValueEntry[T], err error) {
+	op := k.startOperation("Get", key)
+	defer func() { op.end(err) }()
+
+	bucket := k.Bucket()
+	encoded := EncodeKey(k.keys, key)
+	msg, err := js.GetLastMsg("KV_"+bucket, "$KV."+bucket+"."+encoded, nats.DirectGet())
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return nil, nats.ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch msg.Header.Get(kvOperationHeader) {
+	case kvOperationDelete, kvOperationPurge:
+		return nil, nats.ErrKeyNotFound
+	}
+
+	op.setRevision(msg.Sequence)
+	return k.newEntry(&streamEntry{bucket: bucket, key: encoded, msg: msg}), nil
+}
+
+// streamEntry is a nats.KeyValueEntry read directly from the bucket's stream.
+type streamEntry struct {
+	bucket string
+	key    string
+	msg    *nats.RawStreamMsg
+}
+
+func (e *streamEntry) Bucket() string             { return e.bucket }
+func (e *streamEntry) Key() string                { return e.key }
+func (e *streamEntry) Value() []byte              { return e.msg.Data }
+func (e *streamEntry) Revision() uint64           { return e.msg.Sequence }
+func (e *streamEntry) Created() time.Time         { return e.msg.Time }
+func (e *streamEntry) Delta() uint64              { return 0 }
+func (e *streamEntry) Operation() nats.KeyValueOp { return nats.KeyValuePut }
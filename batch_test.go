@@ -0,0 +1,74 @@
+package natsutil_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+	"github.com/nats-io/nats.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyValue_PutManyGetMany(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[testPayload](createTestBucket(t, js), &encoder)
+
+	values := make(map[string]testPayload)
+	keys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("items.%d", i)
+		values[key] = testPayload{Value: i}
+		keys = append(keys, key)
+	}
+
+	revisions, err := kv.PutMany(values, natsutil.WithBatchConcurrency(8))
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(revisions))
+
+	entry, err := kv.Get("items.42")
+	assert.Nil(t, err)
+	assert.Equal(t, revisions["items.42"], entry.Revision())
+
+	assert.Nil(t, kv.Delete("items.7"))
+
+	for _, opts := range [][]natsutil.BatchOption{
+		nil,
+		{natsutil.WithBatchConcurrency(1)},
+		{natsutil.WithDirectGet(js)},
+	} {
+		entries, err := kv.GetMany(append(keys, "items.missing"), opts...)
+
+		var batchErr *natsutil.BatchError
+		assert.True(t, errors.As(err, &batchErr))
+		assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+		assert.Equal(t, 2, len(batchErr.Errors))
+		assert.Contains(t, batchErr.Errors, "items.7")
+		assert.Contains(t, batchErr.Errors, "items.missing")
+
+		assert.Equal(t, 99, len(entries))
+		for key, entry := range entries {
+			assert.Equal(t, key, entry.Key())
+			assert.Equal(t, revisions[key], entry.Revision())
+			value, err := entry.UnmarshalValue()
+			assert.Nil(t, err)
+			assert.Equal(t, values[key], value)
+		}
+	}
+
+	entries, err := kv.GetMany(keys[10:20], natsutil.WithDirectGet(js))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(entries))
+
+	// keys which cannot be written are reported individually
+	revisions, err = kv.PutMany(map[string]testPayload{"items.valid": {}, "items invalid": {}})
+	var batchErr *natsutil.BatchError
+	assert.True(t, errors.As(err, &batchErr))
+	assert.Equal(t, 1, len(batchErr.Errors))
+	assert.ErrorIs(t, batchErr.Errors["items invalid"], nats.ErrInvalidKey)
+	assert.Contains(t, revisions, "items.valid")
+}
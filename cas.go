@@ -0,0 +1,63 @@
+package natsutil
+
+import (
+	"log/slog"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+const ErrTooManyRetries = errors.ConstError("too many retries whilst attempting to update a contended key")
+
+// DefaultMaxRetries is how many times a compare-and-swap update is retried if no limit has been configured.
+const DefaultMaxRetries = 50
+
+// casUpdate reads the latest value for key, applies fn to it and writes the result with a revision check, retrying
+// from the read whenever another writer modifies the key first. When the key does not exist fn is passed the zero
+// value and exists is false, the result is then written with Create. The written value and its revision are returned.
+func casUpdate[T any](
+	kv KeyValue[T],
+	key string,
+	maxRetries int,
+	logger *slog.Logger,
+	fn func(value T, exists bool) (T, error),
+) (result T, revision uint64, err error) {
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		var current T
+		var last uint64
+
+		entry, err := kv.Get(key)
+		if err == nil {
+			if current, err = entry.UnmarshalValue(); err != nil {
+				return result, 0, errors.Annotatef(err, "failed to decode value for key %q", key)
+			}
+			last = entry.Revision()
+		} else if !errors.Is(err, nats.ErrKeyNotFound) {
+			return result, 0, err
+		}
+
+		if result, err = fn(current, last != 0); err != nil {
+			return result, 0, err
+		}
+
+		if last == 0 {
+			revision, err = kv.Create(key, result)
+		} else {
+			revision, err = kv.Update(key, result, last)
+		}
+
+		if err == nil {
+			return result, revision, nil
+		} else if !IsWrongRevision(err) {
+			return result, 0, err
+		}
+
+		logger.Debug("key modified concurrently, retrying",
+			slog.String(LogKeyKey, key),
+			slog.Uint64(LogKeyRevision, last),
+			slog.Int(LogKeyAttempt, attempt),
+		)
+	}
+
+	return result, 0, ErrTooManyRetries
+}
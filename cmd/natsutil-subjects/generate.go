@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+
+	"github.com/41north/natsutil.go"
+	"github.com/juju/errors"
+)
+
+// Generate returns the Go source of the functions for each subject in the schema, source is the name of the schema
+// file recorded in the header.
+func Generate(schema *Schema, source string) ([]byte, error) {
+	profile, err := schema.profile()
+	if err != nil {
+		return nil, err
+	}
+	profileExpr := "natsutil.SubjectProfileStrict"
+	if profile == natsutil.SubjectProfilePermissive {
+		profileExpr = "natsutil.SubjectProfilePermissive"
+	}
+
+	var body bytes.Buffer
+	usesStrconv := false
+
+	err = walk(schema.Subjects, func(path []*Node) error {
+		node := path[len(path)-1]
+		name := funcName(path)
+		subject := pattern(path)
+
+		var params, tokens []string
+		fallible := false
+		for _, n := range path {
+			if n.Param == "" {
+				tokens = append(tokens, fmt.Sprintf("%q", n.Token))
+				continue
+			}
+			id := paramName(n.Param)
+			params = append(params, id+" "+n.paramType())
+			tokens = append(tokens, fmt.Sprintf(paramTypes[n.paramType()], id))
+			if n.paramType() == "string" {
+				fallible = true
+			} else {
+				usesStrconv = true
+			}
+		}
+
+		// the subject
+		fmt.Fprintf(&body, "// %s returns the subject '%s'.\n", name, subject)
+		writeDoc(&body, node.Doc)
+		if fallible {
+			fmt.Fprintf(&body, "//\n// An error is returned if a string parameter is not a valid subject token.\n")
+			fmt.Fprintf(&body, "func %s(%s) (*natsutil.SubjectBuilder, error) {\n", name, strings.Join(params, ", "))
+			fmt.Fprintf(&body, "\tsb := natsutil.NewSubjectBuilder(%s)\n", profileExpr)
+			fmt.Fprintf(&body, "\tif err := sb.Push(%s); err != nil {\n\t\treturn nil, err\n\t}\n", strings.Join(tokens, ", "))
+			fmt.Fprintf(&body, "\treturn sb, nil\n}\n\n")
+		} else {
+			fmt.Fprintf(&body, "func %s(%s) *natsutil.SubjectBuilder {\n", name, strings.Join(params, ", "))
+			fmt.Fprintf(&body, "\tsb := natsutil.NewSubjectBuilder(%s)\n", profileExpr)
+			fmt.Fprintf(&body, "\tsb.MustPush(%s)\n", strings.Join(tokens, ", "))
+			fmt.Fprintf(&body, "\treturn sb\n}\n\n")
+		}
+
+		// the filters
+		filter := func(funcName, description string, chevron bool) {
+			fmt.Fprintf(&body, "// %s returns a filter matching %s.\n", funcName, description)
+			fmt.Fprintf(&body, "func %s() *natsutil.SubjectBuilder {\n", funcName)
+			fmt.Fprintf(&body, "\tsb := natsutil.NewSubjectBuilder(%s)\n", profileExpr)
+			for _, n := range path {
+				if n.Param != "" {
+					fmt.Fprintf(&body, "\tsb.Star()\n")
+				} else {
+					fmt.Fprintf(&body, "\tsb.MustPush(%q)\n", n.Token)
+				}
+			}
+			if chevron {
+				fmt.Fprintf(&body, "\tsb.Chevron()\n")
+			}
+			fmt.Fprintf(&body, "\treturn sb\n}\n\n")
+		}
+		filter(name+"Filter", "every "+name+" subject, '"+filterPattern(path)+"'", false)
+		if len(node.Children) > 0 {
+			filter(name+"TreeFilter", "every subject below "+name+", '"+filterPattern(path)+".>'", true)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by natsutil-subjects from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&out, "package %s\n\n", schema.Package)
+	fmt.Fprintf(&out, "import (\n")
+	if usesStrconv {
+		fmt.Fprintf(&out, "\t\"strconv\"\n\n")
+	}
+	fmt.Fprintf(&out, "\t\"github.com/41north/natsutil.go\"\n)\n\n")
+	fmt.Fprintf(&out, "// Subject hierarchy:\n//\n")
+	for _, line := range strings.Split(strings.TrimSuffix(Tree(schema), "\n"), "\n") {
+		fmt.Fprintf(&out, "//\t%s\n", line)
+	}
+	fmt.Fprintf(&out, "\n")
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to format generated code")
+	}
+	return src, nil
+}
+
+func writeDoc(w *bytes.Buffer, doc string) {
+	doc = strings.TrimSpace(doc)
+	if doc == "" {
+		return
+	}
+	fmt.Fprintf(w, "//\n")
+	for _, line := range strings.Split(doc, "\n") {
+		fmt.Fprintf(w, "%s\n", strings.TrimRight("// "+line, " "))
+	}
+}
+
+// filterPattern returns the subject of the node at the end of path with params as wildcards.
+func filterPattern(path []*Node) string {
+	tokens := make([]string, len(path))
+	for i, node := range path {
+		tokens[i] = node.Token
+		if node.Param != "" {
+			tokens[i] = natsutil.SubjectStar
+		}
+	}
+	return strings.Join(tokens, natsutil.SubjectSeparator)
+}
+
+// Tree draws the hierarchy with the name of the functions generated for each subject.
+func Tree(schema *Schema) string {
+	type row struct{ label, name string }
+	var rows []row
+	width := 0
+
+	var draw func(nodes []*Node, path []*Node, indent string)
+	draw = func(nodes []*Node, path []*Node, indent string) {
+		for i, node := range nodes {
+			branch, next := "├── ", "│   "
+			if i == len(nodes)-1 {
+				branch, next = "└── ", "    "
+			}
+			if len(path) == 0 {
+				branch, next = "", ""
+			}
+
+			label := indent + branch + node.label()
+			if node.Param != "" && node.paramType() != "string" {
+				label += " " + node.paramType()
+			}
+			nodePath := append(path[:len(path):len(path)], node)
+			rows = append(rows, row{label: label, name: funcName(nodePath)})
+			width = max(width, len([]rune(label)))
+
+			draw(node.Children, nodePath, indent+next)
+		}
+	}
+	for _, root := range schema.Subjects {
+		draw([]*Node{root}, nil, "")
+	}
+
+	var sb strings.Builder
+	for _, r := range rows {
+		padding := strings.Repeat(" ", width-len([]rune(r.label))+2)
+		sb.WriteString(r.label + padding + r.name + "\n")
+	}
+	return sb.String()
+}
+
+// Markdown documents each subject in the schema.
+func Markdown(schema *Schema, source string) []byte {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "<!-- Code generated by natsutil-subjects from %s. DO NOT EDIT. -->\n\n", source)
+	fmt.Fprintf(&out, "# Subjects\n\n```\n%s```\n", Tree(schema))
+
+	_ = walk(schema.Subjects, func(path []*Node) error {
+		node := path[len(path)-1]
+		fmt.Fprintf(&out, "\n## `%s`\n\n", pattern(path))
+		if doc := strings.TrimSpace(node.Doc); doc != "" {
+			fmt.Fprintf(&out, "%s\n\n", doc)
+		}
+
+		var params []string
+		for _, n := range path {
+			if n.Param != "" {
+				params = append(params, fmt.Sprintf("`%s` (%s)", n.Param, n.paramType()))
+			}
+		}
+		if len(params) > 0 {
+			fmt.Fprintf(&out, "- Parameters: %s\n", strings.Join(params, ", "))
+		}
+		fmt.Fprintf(&out, "- Function: `%s`\n", funcName(path))
+		fmt.Fprintf(&out, "- Filter: `%s`\n", filterPattern(path))
+		if len(node.Children) > 0 {
+			fmt.Fprintf(&out, "- Tree filter: `%s.>`\n", filterPattern(path))
+		}
+		return nil
+	})
+
+	return out.Bytes()
+}
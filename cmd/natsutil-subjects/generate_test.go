@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate_Example(t *testing.T) {
+	dir := filepath.Join("internal", "example")
+	data, err := os.ReadFile(filepath.Join(dir, "subjects.yaml"))
+	assert.Nil(t, err)
+
+	schema, err := ParseSchema("subjects.yaml", data)
+	assert.Nil(t, err)
+
+	// the checked in output must be regenerated whenever the generator changes
+	src, err := Generate(schema, "subjects.yaml")
+	assert.Nil(t, err)
+	expected, err := os.ReadFile(filepath.Join(dir, "subjects_gen.go"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(src))
+
+	expected, err = os.ReadFile(filepath.Join(dir, "SUBJECTS.md"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(Markdown(schema, "subjects.yaml")))
+}
+
+func TestParseSchema_JSON(t *testing.T) {
+	schema, err := ParseSchema("subjects.json", []byte(`{
+		"package": "events",
+		"profile": "permissive",
+		"subjects": [{"token": "jobs", "children": [
+			{"param": "queue", "children": [{"param": "attempt", "type": "uint64"}]}
+		]}]
+	}`))
+	assert.Nil(t, err)
+
+	src, err := Generate(schema, "subjects.json")
+	assert.Nil(t, err)
+	assert.Contains(t, string(src),
+		"func JobsQueueAttempt(queue string, attempt uint64) (*natsutil.SubjectBuilder, error)")
+	assert.Contains(t, string(src), "strconv.FormatUint(attempt, 10)")
+	assert.Contains(t, string(src), "natsutil.SubjectProfilePermissive")
+
+	_, err = ParseSchema("subjects.json", []byte(`{"package": "events", "unknown": true}`))
+	assert.NotNil(t, err)
+}
+
+func TestParseSchema_Invalid(t *testing.T) {
+	for schema, reason := range map[string]string{
+		"package: func\nsubjects: [{token: a}]":                                    "invalid package name",
+		"package: events\nsubjects: []":                                            "no subjects",
+		"package: events\nprofile: loose\nsubjects: [{token: a}]":                  "unknown profile",
+		"package: events\nsubjects: [{}]":                                          "one of token or param is required",
+		"package: events\nsubjects: [{token: a, param: b}]":                        "mutually exclusive",
+		"package: events\nsubjects: [{token: a, type: int}]":                       "only params have a type",
+		"package: events\nsubjects: [{token: a b}]":                                "whitespace",
+		"package: events\nsubjects: [{token: '*'}]":                                "wildcards",
+		"package: events\nsubjects: [{param: 'a-b'}]":                              "invalid param name",
+		"package: events\nsubjects: [{param: a, type: float64}]":                   "unsupported param type",
+		"package: events\nsubjects: [{param: a, children: [{param: a}]}]":          "appears more than once",
+		"package: events\nsubjects: [{token: a, name: lower}]":                     "must be an exported identifier",
+		"package: events\nsubjects: [{token: a}, {token: b, name: A}]":             "already used",
+		"package: events\nsubjects: [{token: a, children: [{token: filter}]}]":     "already used",
+		"package: events\nsubjects: [{token: a}]\nunknown: true":                   "not found",
+		"package: events\nsubjects: [{token: a, children: [{param: a, tipe: x}]}]": "not found",
+	} {
+		_, err := ParseSchema("subjects.yaml", []byte(schema))
+		assert.ErrorContains(t, err, reason, schema)
+	}
+}
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, "OrderID", camel("order_id", true))
+	assert.Equal(t, "orderID", camel("order_id", false))
+	assert.Equal(t, "UsEast1", camel("us-east-1", true))
+	assert.Equal(t, "V1", camel("v1", true))
+	assert.Equal(t, "T2024", camel("2024", true))
+
+	assert.Equal(t, "type_", paramName("type"))
+	assert.Equal(t, "sb_", paramName("sb"))
+	assert.Equal(t, "regionName", paramName("region_name"))
+
+	path := []*Node{{Token: "orders"}, {Param: "id"}, {Token: "us-east-1"}}
+	assert.Equal(t, "OrdersIDUsEast1", funcName(path))
+	assert.Equal(t, "orders.{id}.us-east-1", pattern(path))
+	assert.Equal(t, "orders.*.us-east-1", filterPattern(path))
+
+	path[1].Name = "Order"
+	assert.Equal(t, "OrderUsEast1", funcName(path))
+
+	assert.Equal(t, natsutil.SubjectStar, filterPattern([]*Node{{Param: "any"}}))
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	schemaPath := filepath.Join(dir, "subjects.yaml")
+	assert.Nil(t, os.WriteFile(schemaPath, []byte("package: events\nsubjects: [{token: jobs}]"), 0o644))
+
+	outPath := filepath.Join(dir, "subjects_gen.go")
+	docPath := filepath.Join(dir, "SUBJECTS.md")
+	assert.Nil(t, run(schemaPath, outPath, docPath))
+
+	src, err := os.ReadFile(outPath)
+	assert.Nil(t, err)
+	assert.Contains(t, string(src), "// Code generated by natsutil-subjects from subjects.yaml. DO NOT EDIT.")
+
+	doc, err := os.ReadFile(docPath)
+	assert.Nil(t, err)
+	assert.Contains(t, string(doc), "## `jobs`")
+
+	assert.NotNil(t, run(filepath.Join(dir, "missing.yaml"), outPath, ""))
+}
@@ -0,0 +1,5 @@
+// Package example is generated from subjects.yaml to show the output of natsutil-subjects and to check that it
+// compiles.
+package example
+
+//go:generate go run github.com/41north/natsutil.go/cmd/natsutil-subjects -schema subjects.yaml -out subjects_gen.go -doc SUBJECTS.md
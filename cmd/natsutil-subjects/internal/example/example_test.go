@@ -0,0 +1,35 @@
+package example_test
+
+import (
+	"testing"
+
+	"github.com/41north/natsutil.go"
+	"github.com/41north/natsutil.go/cmd/natsutil-subjects/internal/example"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratedSubjects(t *testing.T) {
+	assert.Equal(t, "orders", example.Orders().String())
+	assert.Equal(t, "orders.>", example.OrdersTreeFilter().String())
+
+	created, err := example.OrderCreated("eu", 42)
+	assert.Nil(t, err)
+	assert.Equal(t, "orders.eu.42.created", created.String())
+	assert.Equal(t, "orders.*.*.created", example.OrderCreatedFilter().String())
+	assert.True(t, created.Match(example.OrderCreatedFilter()))
+	assert.True(t, created.Match(example.OrdersRegionIDTreeFilter()))
+	assert.False(t, created.Match(example.OrderShippedFilter()))
+
+	_, err = example.OrderCreated("eu.west", 42)
+	assert.ErrorIs(t, err, natsutil.ErrSubjectTokenSeparator)
+
+	stock, err := example.Stock("sku-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "inventory.sku-1", stock.String())
+	assert.True(t, stock.Match(example.StockFilter()))
+
+	// the builders can be extended
+	assert.Nil(t, stock.Push("reserved"))
+	assert.Equal(t, "inventory.sku-1.reserved", stock.String())
+}
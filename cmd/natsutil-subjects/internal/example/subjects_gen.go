@@ -0,0 +1,195 @@
+// Code generated by natsutil-subjects from subjects.yaml. DO NOT EDIT.
+
+package example
+
+import (
+	"strconv"
+
+	"github.com/41north/natsutil.go"
+)
+
+// Subject hierarchy:
+//
+//	orders               Orders
+//	└── {region}         OrdersRegion
+//	    └── {id} int64   OrdersRegionID
+//	        ├── created  OrderCreated
+//	        └── shipped  OrderShipped
+//	inventory            Inventory
+//	└── {sku}            Stock
+
+// Orders returns the subject 'orders'.
+//
+// All order events.
+func Orders() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("orders")
+	return sb
+}
+
+// OrdersFilter returns a filter matching every Orders subject, 'orders'.
+func OrdersFilter() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("orders")
+	return sb
+}
+
+// OrdersTreeFilter returns a filter matching every subject below Orders, 'orders.>'.
+func OrdersTreeFilter() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("orders")
+	sb.Chevron()
+	return sb
+}
+
+// OrdersRegion returns the subject 'orders.{region}'.
+//
+// Order events for a region.
+//
+// An error is returned if a string parameter is not a valid subject token.
+func OrdersRegion(region string) (*natsutil.SubjectBuilder, error) {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	if err := sb.Push("orders", region); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// OrdersRegionFilter returns a filter matching every OrdersRegion subject, 'orders.*'.
+func OrdersRegionFilter() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("orders")
+	sb.Star()
+	return sb
+}
+
+// OrdersRegionTreeFilter returns a filter matching every subject below OrdersRegion, 'orders.*.>'.
+func OrdersRegionTreeFilter() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("orders")
+	sb.Star()
+	sb.Chevron()
+	return sb
+}
+
+// OrdersRegionID returns the subject 'orders.{region}.{id}'.
+//
+// Events for a single order.
+//
+// An error is returned if a string parameter is not a valid subject token.
+func OrdersRegionID(region string, id int64) (*natsutil.SubjectBuilder, error) {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	if err := sb.Push("orders", region, strconv.FormatInt(id, 10)); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// OrdersRegionIDFilter returns a filter matching every OrdersRegionID subject, 'orders.*.*'.
+func OrdersRegionIDFilter() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("orders")
+	sb.Star()
+	sb.Star()
+	return sb
+}
+
+// OrdersRegionIDTreeFilter returns a filter matching every subject below OrdersRegionID, 'orders.*.*.>'.
+func OrdersRegionIDTreeFilter() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("orders")
+	sb.Star()
+	sb.Star()
+	sb.Chevron()
+	return sb
+}
+
+// OrderCreated returns the subject 'orders.{region}.{id}.created'.
+//
+// Published when an order is placed.
+//
+// An error is returned if a string parameter is not a valid subject token.
+func OrderCreated(region string, id int64) (*natsutil.SubjectBuilder, error) {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	if err := sb.Push("orders", region, strconv.FormatInt(id, 10), "created"); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// OrderCreatedFilter returns a filter matching every OrderCreated subject, 'orders.*.*.created'.
+func OrderCreatedFilter() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("orders")
+	sb.Star()
+	sb.Star()
+	sb.MustPush("created")
+	return sb
+}
+
+// OrderShipped returns the subject 'orders.{region}.{id}.shipped'.
+//
+// Published when an order leaves the warehouse.
+// Carries the tracking number.
+//
+// An error is returned if a string parameter is not a valid subject token.
+func OrderShipped(region string, id int64) (*natsutil.SubjectBuilder, error) {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	if err := sb.Push("orders", region, strconv.FormatInt(id, 10), "shipped"); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// OrderShippedFilter returns a filter matching every OrderShipped subject, 'orders.*.*.shipped'.
+func OrderShippedFilter() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("orders")
+	sb.Star()
+	sb.Star()
+	sb.MustPush("shipped")
+	return sb
+}
+
+// Inventory returns the subject 'inventory'.
+func Inventory() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("inventory")
+	return sb
+}
+
+// InventoryFilter returns a filter matching every Inventory subject, 'inventory'.
+func InventoryFilter() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("inventory")
+	return sb
+}
+
+// InventoryTreeFilter returns a filter matching every subject below Inventory, 'inventory.>'.
+func InventoryTreeFilter() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("inventory")
+	sb.Chevron()
+	return sb
+}
+
+// Stock returns the subject 'inventory.{sku}'.
+//
+// Stock level changes for a product.
+//
+// An error is returned if a string parameter is not a valid subject token.
+func Stock(sku string) (*natsutil.SubjectBuilder, error) {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	if err := sb.Push("inventory", sku); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// StockFilter returns a filter matching every Stock subject, 'inventory.*'.
+func StockFilter() *natsutil.SubjectBuilder {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfileStrict)
+	sb.MustPush("inventory")
+	sb.Star()
+	return sb
+}
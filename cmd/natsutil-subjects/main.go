@@ -0,0 +1,72 @@
+// Command natsutil-subjects generates Go functions for a hierarchy of subjects described in a YAML or JSON schema.
+//
+// For each subject it generates a function building the subject from its parameters, a function returning a filter
+// matching every instance of the subject, and for subjects with children a filter matching every subject below it.
+// The functions return a *natsutil.SubjectBuilder.
+//
+// It is intended to be run with go generate:
+//
+//	//go:generate go run github.com/41north/natsutil.go/cmd/natsutil-subjects -schema subjects.yaml -out subjects_gen.go
+//
+// A schema looks like the following, where each node has either a literal token or a param, and params may have a
+// type of string (the default), int, int64 or uint64:
+//
+//	package: events
+//	subjects:
+//	  - token: orders
+//	    doc: All order events.
+//	    children:
+//	      - param: region
+//	        children:
+//	          - param: id
+//	            type: int64
+//	            children:
+//	              - token: created
+//	                name: OrderCreated
+//	                doc: Published when an order is placed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	schemaPath := flag.String("schema", "subjects.yaml", "the schema describing the subject hierarchy")
+	outPath := flag.String("out", "subjects_gen.go", "the file the Go code is written to")
+	docPath := flag.String("doc", "", "if set, a markdown file documenting the hierarchy is written to this path")
+	flag.Parse()
+
+	if err := run(*schemaPath, *outPath, *docPath); err != nil {
+		fmt.Fprintf(os.Stderr, "natsutil-subjects: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(schemaPath, outPath, docPath string) error {
+	data, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return err
+	}
+
+	schema, err := ParseSchema(schemaPath, data)
+	if err != nil {
+		return err
+	}
+
+	source := filepath.Base(schemaPath)
+	src, err := Generate(schema, source)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outPath, src, 0o644); err != nil {
+		return err
+	}
+
+	if docPath != "" {
+		return os.WriteFile(docPath, Markdown(schema, source), 0o644)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/41north/natsutil.go"
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Schema describes a subject hierarchy.
+type Schema struct {
+	// Package is the name of the package the code is generated into.
+	Package string `json:"package" yaml:"package"`
+	// Profile is the profile tokens are validated against, either 'strict' or 'permissive'. Defaults to strict.
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty"`
+	// Subjects are the roots of the hierarchy.
+	Subjects []*Node `json:"subjects" yaml:"subjects"`
+}
+
+// Node is a token within the hierarchy, every node is a subject formed from the tokens of its ancestors and its own.
+type Node struct {
+	// Token is a literal token, exactly one of Token and Param must be set.
+	Token string `json:"token,omitempty" yaml:"token,omitempty"`
+	// Param is the name of a parameter whose value is the token.
+	Param string `json:"param,omitempty" yaml:"param,omitempty"`
+	// Type is the Go type of the parameter, one of the keys of paramTypes. Defaults to string.
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Name overrides the name of the generated functions, which is otherwise derived from the tokens.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Doc describes the subject.
+	Doc string `json:"doc,omitempty" yaml:"doc,omitempty"`
+	// Children are the subjects one token below this one.
+	Children []*Node `json:"children,omitempty" yaml:"children,omitempty"`
+}
+
+// paramTypes maps the supported parameter types to the expression formatting a value of that type as a token.
+var paramTypes = map[string]string{
+	"string": "%s",
+	"int":    "strconv.Itoa(%s)",
+	"int64":  "strconv.FormatInt(%s, 10)",
+	"uint64": "strconv.FormatUint(%s, 10)",
+}
+
+var (
+	identifier  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	initialisms = map[string]string{"id": "ID", "api": "API", "http": "HTTP", "url": "URL", "uuid": "UUID"}
+)
+
+// ParseSchema decodes a schema, as JSON if the file name ends with '.json' and as YAML otherwise, and validates it.
+func ParseSchema(filename string, data []byte) (*Schema, error) {
+	var schema Schema
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&schema); err != nil {
+			return nil, errors.Annotatef(err, "failed to decode %s", filename)
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&schema); err != nil {
+			return nil, errors.Annotatef(err, "failed to decode %s", filename)
+		}
+	}
+
+	if err := schema.validate(); err != nil {
+		return nil, errors.Annotatef(err, "invalid schema %s", filename)
+	}
+	return &schema, nil
+}
+
+// profile returns the natsutil profile named by the schema.
+func (s *Schema) profile() (natsutil.SubjectProfile, error) {
+	switch s.Profile {
+	case "", natsutil.SubjectProfileStrict.String():
+		return natsutil.SubjectProfileStrict, nil
+	case natsutil.SubjectProfilePermissive.String():
+		return natsutil.SubjectProfilePermissive, nil
+	default:
+		return 0, errors.Errorf("unknown profile %q", s.Profile)
+	}
+}
+
+func (s *Schema) validate() error {
+	if !identifier.MatchString(s.Package) || token.IsKeyword(s.Package) {
+		return errors.Errorf("invalid package name %q", s.Package)
+	}
+	profile, err := s.profile()
+	if err != nil {
+		return err
+	}
+	if len(s.Subjects) == 0 {
+		return errors.New("no subjects")
+	}
+
+	names := make(map[string]string)
+	return walk(s.Subjects, func(path []*Node) error {
+		node := path[len(path)-1]
+		subject := pattern(path)
+
+		switch {
+		case node.Token != "" && node.Param != "":
+			return errors.Errorf("%s: token and param are mutually exclusive", subject)
+		case node.Token != "":
+			if node.Type != "" {
+				return errors.Errorf("%s: only params have a type", subject)
+			}
+			if err := natsutil.ValidateSubjectToken(node.Token, profile); err != nil {
+				return errors.Annotatef(err, "%s", subject)
+			}
+		case node.Param != "":
+			if !identifier.MatchString(node.Param) {
+				return errors.Errorf("%s: invalid param name %q", subject, node.Param)
+			}
+			if _, ok := paramTypes[node.paramType()]; !ok {
+				return errors.Errorf("%s: unsupported param type %q", subject, node.Type)
+			}
+			for _, ancestor := range path[:len(path)-1] {
+				if ancestor.Param != "" && paramName(ancestor.Param) == paramName(node.Param) {
+					return errors.Errorf("%s: param %q appears more than once", subject, node.Param)
+				}
+			}
+		default:
+			return errors.Errorf("%s: one of token or param is required", subject)
+		}
+
+		if node.Name != "" && (!identifier.MatchString(node.Name) || !unicode.IsUpper(rune(node.Name[0]))) {
+			return errors.Errorf("%s: name %q must be an exported identifier", subject, node.Name)
+		}
+		base := funcName(path)
+		for _, name := range []string{base, base + "Filter", base + "TreeFilter"} {
+			if other, ok := names[name]; ok {
+				return errors.Errorf("%s: name %s is already used by %s", subject, name, other)
+			}
+			names[name] = subject
+		}
+		return nil
+	})
+}
+
+func (n *Node) paramType() string {
+	if n.Type == "" {
+		return "string"
+	}
+	return n.Type
+}
+
+// walk calls fn with the path to each node in depth first order.
+func walk(nodes []*Node, fn func(path []*Node) error) error {
+	var visit func(path []*Node) error
+	visit = func(path []*Node) error {
+		if err := fn(path); err != nil {
+			return err
+		}
+		for _, child := range path[len(path)-1].Children {
+			if err := visit(append(path[:len(path):len(path)], child)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, node := range nodes {
+		if err := visit([]*Node{node}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// label returns the token as it appears in a pattern.
+func (n *Node) label() string {
+	if n.Param != "" {
+		return "{" + n.Param + "}"
+	}
+	return n.Token
+}
+
+// pattern returns the subject of the node at the end of path with params as placeholders.
+func pattern(path []*Node) string {
+	labels := make([]string, len(path))
+	for i, node := range path {
+		labels[i] = node.label()
+	}
+	return strings.Join(labels, natsutil.SubjectSeparator)
+}
+
+// funcName returns the name of the functions generated for the node at the end of path, which is its Name if set or
+// otherwise the name of its parent followed by its own token or param in camel case.
+func funcName(path []*Node) string {
+	node := path[len(path)-1]
+	if node.Name != "" {
+		return node.Name
+	}
+	parent := ""
+	if len(path) > 1 {
+		parent = funcName(path[:len(path)-1])
+	}
+	word := node.Token
+	if node.Param != "" {
+		word = node.Param
+	}
+	return parent + camel(word, true)
+}
+
+// camel converts a token or param name to camel case, splitting it into words at any character which cannot be part
+// of an identifier.
+func camel(s string, exported bool) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+	})
+
+	var sb strings.Builder
+	for i, word := range words {
+		switch initialism, ok := initialisms[strings.ToLower(word)]; {
+		case i == 0 && !exported:
+			sb.WriteString(strings.ToLower(word[:1]) + word[1:])
+		case ok:
+			sb.WriteString(initialism)
+		default:
+			sb.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+
+	name := sb.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		// tokens such as 'v1' are fine but '1' alone is not an identifier
+		name = "T" + name
+	}
+	return name
+}
+
+// paramName returns the Go identifier used for a param.
+func paramName(param string) string {
+	name := camel(param, false)
+	switch {
+	case token.IsKeyword(name), name == "sb", name == "natsutil", name == "strconv":
+		// avoid clashing with the identifiers used by the generated code
+		name += "_"
+	}
+	return name
+}
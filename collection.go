@@ -0,0 +1,133 @@
+package natsutil
+
+import (
+	"log/slog"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+const ErrSnapshotUnavailable = errors.ConstError(
+	"the bucket history no longer contains the revisions required for a consistent snapshot",
+)
+
+// errUnchanged aborts a compare-and-swap update when the value would not change, so that nothing is written.
+const errUnchanged = errors.ConstError("unchanged")
+
+// CollectionOption configures a Set or Map.
+type CollectionOption func(c *collectionConfig)
+
+type collectionConfig struct {
+	maxRetries int
+	logger     *slog.Logger
+}
+
+// WithCollectionMaxRetries sets how many times an update is retried when another writer modifies the same key first,
+// defaults to DefaultMaxRetries.
+func WithCollectionMaxRetries(maxRetries int) CollectionOption {
+	return func(c *collectionConfig) {
+		c.maxRetries = maxRetries
+	}
+}
+
+// WithCollectionLogger sets the logger which receives retries.
+func WithCollectionLogger(logger *slog.Logger) CollectionOption {
+	return func(c *collectionConfig) {
+		c.logger = logger
+	}
+}
+
+func newCollectionConfig(bucket string, key string, opts []CollectionOption) collectionConfig {
+	c := collectionConfig{maxRetries: DefaultMaxRetries}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.logger == nil {
+		c.logger = DiscardLogger()
+	}
+	c.logger = c.logger.With(slog.String(LogKeyBucket, bucket), slog.String(LogKeyKey, key))
+	return c
+}
+
+// elementKey returns the key under which an element of a keyed collection is stored.
+func elementKey(prefix string, token string) (string, error) {
+	if err := ValidateSubjectToken(token, SubjectProfileStrict); err != nil {
+		return "", errors.Annotatef(err, "invalid element token %q", token)
+	}
+	return prefix + SubjectSeparator + token, nil
+}
+
+// bucketRevision returns the revision of the most recent write to the bucket.
+func bucketRevision(kv nats.KeyValue) (revision uint64, history int64, err error) {
+	status, err := kv.Status()
+	if err != nil {
+		return 0, 0, err
+	}
+	bucketStatus, ok := status.(*nats.KeyValueBucketStatus)
+	if !ok {
+		return 0, 0, errors.NotSupportedf("determining the revision of a bucket with status %T", status)
+	}
+	return bucketStatus.StreamInfo().State.LastSeq, status.History(), nil
+}
+
+// rangeSnapshot calls fn with every entry under '<prefix>.*' as of the current revision of the bucket, regardless
+// of any writes made whilst iterating. Keys modified since the snapshot was taken are resolved to the value they had
+// at the time through their history, ErrSnapshotUnavailable is returned if that value has since been discarded.
+// Keys purged since the snapshot are treated as not having existed. Iteration stops when fn returns false.
+func rangeSnapshot[T any](kv KeyValue[T], prefix string, fn func(entry KeyValueEntry[T]) (bool, error)) error {
+	snapshot, history, err := bucketRevision(kv.Delegate())
+	if err != nil {
+		return err
+	}
+
+	watcher, err := kv.Watch(prefix + SubjectSeparator + SubjectStar)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = watcher.Stop() }()
+
+	for entry := range watcher.UpdatesUnmarshalled() {
+		if entry == nil {
+			break
+		}
+
+		if entry.Revision() > snapshot {
+			if entry, err = entryAt(kv, entry.Key(), snapshot, history); err != nil {
+				return err
+			} else if entry == nil {
+				// created after the snapshot
+				continue
+			}
+		}
+
+		if entry.Operation() != nats.KeyValuePut {
+			continue
+		}
+
+		if next, err := fn(entry); err != nil || !next {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// entryAt returns the entry for key as of the given revision, or nil if the key had not been written by then.
+func entryAt[T any](kv KeyValue[T], key string, revision uint64, history int64) (KeyValueEntry[T], error) {
+	entries, err := kv.History(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		if entries[idx].Revision() <= revision {
+			return entries[idx], nil
+		}
+	}
+
+	if int64(len(entries)) >= history {
+		// older revisions have been discarded, so we cannot tell whether the key existed
+		return nil, ErrSnapshotUnavailable
+	}
+	return nil, nil
+}
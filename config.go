@@ -0,0 +1,332 @@
+package natsutil
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	ErrConfigNotStarted     = errors.ConstError("config has not been started")
+	ErrConfigAlreadyStarted = errors.ConstError("config has already been started")
+)
+
+// Tokens used by ConfigLayers when building layer keys.
+const (
+	ConfigLayerGlobal      = "global"
+	ConfigLayerEnvironment = "environment"
+	ConfigLayerService     = "service"
+	ConfigLayerInstance    = "instance"
+)
+
+// ConfigLayers returns the keys of a layered configuration in order of increasing precedence:
+//
+//	<prefix>.global
+//	<prefix>.environment.<environment>
+//	<prefix>.service.<service>
+//	<prefix>.instance.<service>.<instance>
+//
+// Layers for which an empty name is provided are omitted.
+func ConfigLayers(prefix *SubjectBuilder, environment, service, instance string) ([]string, error) {
+	layer := func(elements ...string) (string, error) {
+		sb := SubjectBuilder{elements: append([]string(nil), prefix.elements...)}
+		if err := sb.Push(elements...); err != nil {
+			return "", err
+		}
+		return sb.String(), nil
+	}
+
+	global, err := layer(ConfigLayerGlobal)
+	if err != nil {
+		return nil, err
+	}
+	layers := []string{global}
+
+	if environment != "" {
+		key, err := layer(ConfigLayerEnvironment, environment)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid environment %q", environment)
+		}
+		layers = append(layers, key)
+	}
+
+	if service != "" {
+		key, err := layer(ConfigLayerService, service)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid service %q", service)
+		}
+		layers = append(layers, key)
+
+		if instance != "" {
+			key, err := layer(ConfigLayerInstance, service, instance)
+			if err != nil {
+				return nil, errors.Annotatef(err, "invalid instance %q", instance)
+			}
+			layers = append(layers, key)
+		}
+	}
+
+	return layers, nil
+}
+
+// ConfigOption configures a Config.
+type ConfigOption[T any] func(c *Config[T])
+
+// WithConfigDefaults sets the value onto which the layers are merged.
+func WithConfigDefaults[T any](defaults T) ConfigOption[T] {
+	return func(c *Config[T]) {
+		c.defaults = defaults
+	}
+}
+
+// WithConfigValidator sets a function which must accept a merged configuration before it is made live.
+func WithConfigValidator[T any](validate func(config T) error) ConfigOption[T] {
+	return func(c *Config[T]) {
+		c.validate = validate
+	}
+}
+
+// WithConfigLogger sets the logger which receives reloads and rejected updates.
+func WithConfigLogger[T any](logger *slog.Logger) ConfigOption[T] {
+	return func(c *Config[T]) {
+		c.logger = logger
+	}
+}
+
+// Config is a live, hot-reloading configuration merged from several layer keys.
+//
+// Each layer holds a partial configuration. Starting from the defaults, layers are decoded one after another into
+// the same value, lowest precedence first, so fields present in a later layer override those from earlier ones.
+// This relies on the encoder decoding into an existing value without resetting fields missing from the input,
+// as the JSON encoder does.
+//
+// The layers are watched and whenever one changes the configuration is merged again and validated. A valid
+// configuration atomically replaces the live one and is passed to subscribers, whereas an invalid one is rejected
+// and the previous configuration remains live.
+type Config[T any] struct {
+	kv       KeyValue[T]
+	layers   []string
+	defaults T
+	validate func(config T) error
+	logger   *slog.Logger
+
+	current atomic.Pointer[T]
+	started atomic.Bool
+
+	mu          sync.Mutex
+	subscribers map[uint64]func(config T)
+	nextID      uint64
+	lastErr     error
+}
+
+// NewConfig creates a Config which merges the provided layer keys, lowest precedence first.
+func NewConfig[T any](kv KeyValue[T], layers []string, opts ...ConfigOption[T]) *Config[T] {
+	c := &Config[T]{kv: kv, layers: layers, subscribers: make(map[uint64]func(config T))}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.logger == nil {
+		c.logger = DiscardLogger()
+	}
+	c.logger = c.logger.With(slog.String(LogKeyBucket, kv.Bucket()))
+	return c
+}
+
+// layerUpdate is an update to a single layer received from its watcher.
+type layerUpdate struct {
+	// layer is the name of the layer, the key of the entry is encoded if the bucket uses a key encoding.
+	layer string
+	entry nats.KeyValueEntry
+	// initialised is set instead of an entry once the watcher has delivered all initial values.
+	initialised bool
+}
+
+// Start loads the configuration and keeps it up to date until the context is done. An error is returned if the
+// initial configuration cannot be loaded or is invalid.
+func (c *Config[T]) Start(ctx context.Context) error {
+	if !c.started.CompareAndSwap(false, true) {
+		return ErrConfigAlreadyStarted
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	updates := make(chan layerUpdate)
+	var watchers []KeyWatcher[T]
+
+	stopWatchers := func() {
+		cancel()
+		for _, w := range watchers {
+			_ = w.Stop()
+		}
+	}
+
+	for _, key := range c.layers {
+		w, err := c.kv.Watch(key)
+		if err != nil {
+			stopWatchers()
+			c.started.Store(false)
+			return err
+		}
+		watchers = append(watchers, w)
+
+		go func(layer string, w KeyWatcher[T]) {
+			for entry := range w.Updates() {
+				select {
+				case updates <- layerUpdate{layer: layer, entry: entry, initialised: entry == nil}:
+				case <-watchCtx.Done():
+					return
+				}
+			}
+		}(key, w)
+	}
+
+	// wait for the initial value of every layer
+	raw := make(map[string][]byte)
+	for pending := len(c.layers); pending > 0; {
+		select {
+		case <-ctx.Done():
+			stopWatchers()
+			c.started.Store(false)
+			return ctx.Err()
+		case update := <-updates:
+			if update.initialised {
+				pending--
+				continue
+			}
+			applyLayer(raw, update.layer, update.entry)
+		}
+	}
+
+	config, err := c.merge(raw)
+	if err != nil {
+		stopWatchers()
+		c.started.Store(false)
+		return err
+	}
+	c.current.Store(&config)
+	c.logger.Info("config loaded")
+
+	go func() {
+		defer stopWatchers()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case update := <-updates:
+				if update.initialised {
+					continue
+				}
+				applyLayer(raw, update.layer, update.entry)
+				c.reload(raw, update.entry)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// applyLayer records the latest bytes of a layer, deleted layers no longer contribute to the configuration.
+func applyLayer(raw map[string][]byte, layer string, entry nats.KeyValueEntry) {
+	if entry.Operation() == nats.KeyValuePut {
+		raw[layer] = entry.Value()
+	} else {
+		delete(raw, layer)
+	}
+}
+
+// reload merges and validates the layers, making the result live if it is valid.
+func (c *Config[T]) reload(raw map[string][]byte, cause nats.KeyValueEntry) {
+	logger := c.logger.With(entryAttrs(cause)...)
+
+	config, err := c.merge(raw)
+
+	c.mu.Lock()
+	c.lastErr = err
+	var subscribers []func(config T)
+	if err == nil {
+		c.current.Store(&config)
+		for _, fn := range c.subscribers {
+			subscribers = append(subscribers, fn)
+		}
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		logger.Error("rejected config update, keeping previous config", slog.Any("error", err))
+		return
+	}
+
+	logger.Info("config reloaded")
+	for _, fn := range subscribers {
+		fn(config)
+	}
+}
+
+// merge decodes each layer on top of the defaults and validates the result.
+func (c *Config[T]) merge(raw map[string][]byte) (config T, err error) {
+	encoder := c.kv.Encoder()
+
+	// round trip the defaults so that merging never modifies them through shared references
+	defaults, err := encoder.Encode("", c.defaults)
+	if err != nil {
+		return config, errors.Annotate(err, "failed to encode config defaults")
+	}
+	if err = encoder.Decode("", defaults, &config); err != nil {
+		return config, errors.Annotate(err, "failed to decode config defaults")
+	}
+
+	for _, key := range c.layers {
+		bytes, ok := raw[key]
+		if !ok {
+			continue
+		}
+		if err = encoder.Decode("", bytes, &config); err != nil {
+			return config, errors.Annotatef(err, "failed to decode config layer %q", key)
+		}
+	}
+
+	if c.validate != nil {
+		if err = c.validate(config); err != nil {
+			return config, errors.Annotate(err, "invalid config")
+		}
+	}
+
+	return config, nil
+}
+
+// Get returns the live configuration.
+func (c *Config[T]) Get() (T, error) {
+	current := c.current.Load()
+	if current == nil {
+		var zero T
+		return zero, ErrConfigNotStarted
+	}
+	return *current, nil
+}
+
+// LastError returns the reason the most recent update was rejected, or nil if it was accepted.
+func (c *Config[T]) LastError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+// Subscribe registers a callback which receives the configuration each time a valid update is made live.
+// The returned function removes the subscription.
+func (c *Config[T]) Subscribe(fn func(config T)) (unsubscribe func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := c.nextID
+	c.nextID++
+	c.subscribers[id] = fn
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subscribers, id)
+	}
+}
@@ -0,0 +1,229 @@
+package natsutil_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testConfig struct {
+	LogLevel string            `json:"logLevel,omitempty"`
+	Workers  int               `json:"workers,omitempty"`
+	Region   string            `json:"region,omitempty"`
+	Limits   map[string]int    `json:"limits,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+func TestConfigLayers(t *testing.T) {
+	prefix := natsutil.SubjectBuilder{}
+	prefix.MustPush("config")
+
+	layers, err := natsutil.ConfigLayers(&prefix, "prod", "orders", "i1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"config.global",
+		"config.environment.prod",
+		"config.service.orders",
+		"config.instance.orders.i1",
+	}, layers)
+
+	layers, err = natsutil.ConfigLayers(&prefix, "", "orders", "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"config.global", "config.service.orders"}, layers)
+
+	// the prefix is left untouched
+	assert.Equal(t, "config", prefix.String())
+
+	_, err = natsutil.ConfigLayers(&prefix, "%", "", "")
+	assert.NotNil(t, err)
+}
+
+func TestConfig_LayeredReload(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[testConfig](createTestBucket(t, js), &encoder)
+
+	prefix := natsutil.SubjectBuilder{}
+	prefix.MustPush("config")
+	layers, err := natsutil.ConfigLayers(&prefix, "prod", "orders", "i1")
+	assert.Nil(t, err)
+
+	put := func(key string, json string) {
+		_, err := kv.Delegate().Put(key, []byte(json))
+		assert.Nil(t, err)
+	}
+
+	put("config.global", `{"logLevel":"info","workers":1,"limits":{"a":1,"b":2}}`)
+	put("config.environment.prod", `{"logLevel":"warn","region":"eu"}`)
+	put("config.service.orders", `{"workers":4,"limits":{"b":3}}`)
+	// unrelated layers are ignored
+	put("config.service.payments", `{"workers":100}`)
+
+	defaults := testConfig{Labels: map[string]string{"team": "core"}}
+
+	config := natsutil.NewConfig[testConfig](kv, layers,
+		natsutil.WithConfigDefaults(defaults),
+		natsutil.WithConfigValidator(func(config testConfig) error {
+			if config.Workers < 1 {
+				return errors.New("workers must be positive")
+			}
+			return nil
+		}),
+	)
+
+	_, err = config.Get()
+	assert.ErrorIs(t, err, natsutil.ErrConfigNotStarted)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.Nil(t, config.Start(ctx))
+	assert.ErrorIs(t, config.Start(ctx), natsutil.ErrConfigAlreadyStarted)
+
+	current, err := config.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, testConfig{
+		LogLevel: "warn",
+		Workers:  4,
+		Region:   "eu",
+		Limits:   map[string]int{"a": 1, "b": 3},
+		Labels:   map[string]string{"team": "core"},
+	}, current)
+
+	reloaded := make(chan testConfig, 10)
+	unsubscribe := config.Subscribe(func(config testConfig) { reloaded <- config })
+
+	next := func() testConfig {
+		select {
+		case c := <-reloaded:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("config not reloaded")
+			return testConfig{}
+		}
+	}
+
+	// the instance layer takes precedence
+	put("config.instance.orders.i1", `{"logLevel":"debug","labels":{"instance":"i1"}}`)
+	update := next()
+	assert.Equal(t, "debug", update.LogLevel)
+	assert.Equal(t, map[string]string{"team": "core", "instance": "i1"}, update.Labels)
+
+	current, err = config.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, update, current)
+
+	// the defaults were not modified by merging
+	assert.Equal(t, map[string]string{"team": "core"}, defaults.Labels)
+
+	// an invalid update is rejected and the previous config remains live
+	put("config.service.orders", `{"workers":-1}`)
+	assert.Eventually(t, func() bool { return config.LastError() != nil }, 5*time.Second, 10*time.Millisecond)
+
+	current, err = config.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, current.Workers)
+
+	// as is one which cannot be decoded
+	put("config.service.orders", `not json`)
+	assert.Eventually(t, func() bool {
+		err := config.LastError()
+		return err != nil && strings.Contains(err.Error(), "failed to decode config layer")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// deleting a layer removes its contribution
+	assert.Nil(t, kv.Delete("config.service.orders"))
+	update = next()
+	assert.Nil(t, config.LastError())
+	assert.Equal(t, 1, update.Workers)
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, update.Limits)
+
+	unsubscribe()
+	put("config.global", `{"logLevel":"info","workers":2}`)
+	assert.Eventually(t, func() bool {
+		current, _ := config.Get()
+		return current.Workers == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, reloaded)
+}
+
+func TestConfig_KeyEncoding(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	bucket := createTestBucket(t, js)
+	kv := natsutil.NewKeyValue[testConfig](bucket, &encoder, natsutil.WithKeyEncoding(natsutil.TokenEncodingBase64))
+
+	prefix := natsutil.SubjectBuilder{}
+	prefix.MustPush("config")
+	layers, err := natsutil.ConfigLayers(&prefix, "", "orders", "")
+	assert.Nil(t, err)
+
+	_, err = kv.Put("config.global", testConfig{LogLevel: "info", Workers: 1})
+	assert.Nil(t, err)
+	_, err = kv.Put("config.service.orders", testConfig{Workers: 4})
+	assert.Nil(t, err)
+
+	// the layers are stored under encoded keys
+	raw, err := bucket.Keys()
+	assert.Nil(t, err)
+	assert.NotContains(t, raw, "config.global")
+
+	config := natsutil.NewConfig[testConfig](kv, layers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(t, config.Start(ctx))
+
+	current, err := config.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, testConfig{LogLevel: "info", Workers: 4}, current)
+
+	// updates and deletes of encoded layers are applied
+	_, err = kv.Put("config.service.orders", testConfig{Workers: 8})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		current, err := config.Get()
+		return err == nil && current.Workers == 8
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, kv.Delete("config.service.orders"))
+	assert.Eventually(t, func() bool {
+		current, err := config.Get()
+		return err == nil && current.Workers == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConfig_InvalidInitialConfig(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[testConfig](createTestBucket(t, js), &encoder)
+
+	_, err := kv.Delegate().Put("config.global", []byte(`{"workers":0}`))
+	assert.Nil(t, err)
+
+	config := natsutil.NewConfig[testConfig](kv, []string{"config.global"},
+		natsutil.WithConfigValidator(func(config testConfig) error {
+			if config.Workers < 1 {
+				return errors.New("workers must be positive")
+			}
+			return nil
+		}),
+	)
+
+	assert.NotNil(t, config.Start(context.Background()))
+
+	_, err = config.Get()
+	assert.ErrorIs(t, err, natsutil.ErrConfigNotStarted)
+}
@@ -0,0 +1,202 @@
+package natsutil
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"strconv"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+// CounterOption configures a Counter.
+type CounterOption func(c *Counter)
+
+// WithCounterShards spreads writes across the given number of keys to increase write throughput, at the cost of
+// reads having to fetch and sum every shard. Shards are stored under '<key>.<index>'.
+func WithCounterShards(shards int) CounterOption {
+	return func(c *Counter) {
+		c.shards = shards
+	}
+}
+
+// WithCounterMaxRetries sets how many times an update is retried when another writer modifies the same key first,
+// defaults to DefaultMaxRetries.
+func WithCounterMaxRetries(maxRetries int) CounterOption {
+	return func(c *Counter) {
+		c.maxRetries = maxRetries
+	}
+}
+
+// WithCounterLogger sets the logger which receives retries.
+func WithCounterLogger(logger *slog.Logger) CounterOption {
+	return func(c *Counter) {
+		c.logger = logger
+	}
+}
+
+// Counter is an integer which can be atomically modified by many writers.
+//
+// Each modification reads the current value and writes the result with Update against the revision which was read,
+// retrying if another writer got there first. A counter with many concurrent writers can be sharded, in which case
+// each write modifies one shard chosen at random and the value of the counter is the sum of all shards.
+type Counter struct {
+	kv         KeyValue[int64]
+	key        string
+	shards     int
+	maxRetries int
+	logger     *slog.Logger
+}
+
+// NewCounter creates a Counter stored under key.
+func NewCounter(kv KeyValue[int64], key string, opts ...CounterOption) *Counter {
+	c := &Counter{kv: kv, key: key, shards: 1, maxRetries: DefaultMaxRetries}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.shards < 1 {
+		c.shards = 1
+	}
+	if c.logger == nil {
+		c.logger = DiscardLogger()
+	}
+	c.logger = c.logger.With(slog.String(LogKeyBucket, kv.Bucket()))
+	return c
+}
+
+// Keys returns the keys the counter is stored under, one per shard.
+func (c *Counter) Keys() []string {
+	if c.shards == 1 {
+		return []string{c.key}
+	}
+	keys := make([]string, c.shards)
+	for idx := range keys {
+		keys[idx] = c.shardKey(idx)
+	}
+	return keys
+}
+
+func (c *Counter) shardKey(idx int) string {
+	return c.key + SubjectSeparator + strconv.Itoa(idx)
+}
+
+// Add atomically adds delta to the counter.
+func (c *Counter) Add(delta int64) error {
+	key := c.key
+	if c.shards > 1 {
+		key = c.shardKey(rand.Intn(c.shards))
+	}
+	_, _, err := casUpdate[int64](c.kv, key, c.maxRetries, c.logger, func(value int64, _ bool) (int64, error) {
+		return value + delta, nil
+	})
+	return err
+}
+
+// Increment atomically adds one to the counter.
+func (c *Counter) Increment() error {
+	return c.Add(1)
+}
+
+// Get returns the current value of the counter, a counter which has never been written to has a value of zero.
+func (c *Counter) Get() (int64, error) {
+	var total int64
+	for _, key := range c.Keys() {
+		entry, err := c.kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		value, err := entry.UnmarshalValue()
+		if err != nil {
+			return 0, errors.Annotatef(err, "failed to decode value for key %q", key)
+		}
+		total += value
+	}
+	return total, nil
+}
+
+// Reset sets the counter back to zero. Modifications made concurrently with a reset of a sharded counter may
+// be lost.
+func (c *Counter) Reset() error {
+	for _, key := range c.Keys() {
+		if _, err := c.kv.Put(key, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch returns a channel which receives the value of the counter whenever it changes, starting with the current
+// value. The channel is closed once the context is done.
+func (c *Counter) Watch(ctx context.Context) (<-chan int64, error) {
+	keys := c.key
+	if c.shards > 1 {
+		keys = c.key + SubjectSeparator + SubjectStar
+	}
+
+	watcher, err := c.kv.Watch(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan int64, 1)
+
+	go func() {
+		defer close(ch)
+		defer func() { _ = watcher.Stop() }()
+
+		updates := watcher.UpdatesUnmarshalled()
+		values := make(map[string]int64)
+		initialised := false
+
+		for {
+			var entry KeyValueEntry[int64]
+			var ok bool
+
+			select {
+			case <-ctx.Done():
+				return
+			case entry, ok = <-updates:
+				if !ok {
+					return
+				}
+			}
+
+			if entry == nil {
+				// initial values have been received
+				initialised = true
+			} else {
+				var value int64
+				if entry.Operation() == nats.KeyValuePut {
+					var err error
+					if value, err = entry.UnmarshalValue(); err != nil {
+						// already logged when decoding
+						continue
+					}
+				}
+				values[entry.Key()] = value
+			}
+
+			if !initialised {
+				continue
+			}
+
+			var total int64
+			for _, value := range values {
+				total += value
+			}
+
+			// only the latest value matters, replace anything which has not yet been consumed
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- total
+		}
+	}()
+
+	return ch, nil
+}
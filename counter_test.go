@@ -0,0 +1,164 @@
+package natsutil_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[int64](createTestBucket(t, js), &encoder)
+
+	c := natsutil.NewCounter(kv, "hits")
+	assert.Equal(t, []string{"hits"}, c.Keys())
+
+	// unwritten counters are zero
+	v, err := c.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), v)
+
+	assert.Nil(t, c.Increment())
+	assert.Nil(t, c.Add(10))
+	assert.Nil(t, c.Add(-3))
+
+	v, err = c.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8), v)
+
+	assert.Nil(t, c.Reset())
+	v, err = c.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), v)
+}
+
+func testCounterContention(t *testing.T, opts ...natsutil.CounterOption) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	createTestBucket(t, js)
+
+	const writers = 5
+	const increments = 20
+
+	// a writer only retries when another succeeds, so it can never need more attempts than there are increments
+	opts = append(opts, natsutil.WithCounterMaxRetries(writers*increments))
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		_, js := jsClient(t, s)
+		bucket, err := js.KeyValue("TestBucket")
+		assert.Nil(t, err)
+		c := natsutil.NewCounter(natsutil.NewKeyValue[int64](bucket, &encoder), "hits", opts...)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				assert.Nil(t, c.Increment())
+			}
+		}()
+	}
+	wg.Wait()
+
+	bucket, err := js.KeyValue("TestBucket")
+	assert.Nil(t, err)
+	c := natsutil.NewCounter(natsutil.NewKeyValue[int64](bucket, &encoder), "hits", opts...)
+
+	v, err := c.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(writers*increments), v)
+}
+
+func TestCounter_Contention(t *testing.T) {
+	testCounterContention(t)
+}
+
+func TestCounter_ShardedContention(t *testing.T) {
+	testCounterContention(t, natsutil.WithCounterShards(4))
+}
+
+func TestCounter_Sharded(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[int64](createTestBucket(t, js), &encoder)
+
+	c := natsutil.NewCounter(kv, "hits", natsutil.WithCounterShards(3))
+	assert.Equal(t, []string{"hits.0", "hits.1", "hits.2"}, c.Keys())
+
+	for i := 0; i < 30; i++ {
+		assert.Nil(t, c.Increment())
+	}
+
+	v, err := c.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(30), v)
+
+	// the total is spread across the shards
+	var sum int64
+	for _, key := range c.Keys() {
+		entry, err := kv.Get(key)
+		if err != nil {
+			continue
+		}
+		shard, err := entry.UnmarshalValue()
+		assert.Nil(t, err)
+		sum += shard
+	}
+	assert.Equal(t, int64(30), sum)
+
+	assert.Nil(t, c.Reset())
+	v, err = c.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), v)
+}
+
+func TestCounter_Watch(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[int64](createTestBucket(t, js), &encoder)
+
+	for _, shards := range []int{1, 3} {
+		c := natsutil.NewCounter(kv, "watched", natsutil.WithCounterShards(shards))
+		assert.Nil(t, c.Reset())
+		assert.Nil(t, c.Add(5))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, err := c.Watch(ctx)
+		assert.Nil(t, err)
+
+		// starts with the current value
+		assert.Equal(t, int64(5), <-ch)
+
+		assert.Nil(t, c.Add(2))
+		assert.Nil(t, c.Add(3))
+
+		// intermediate values may be skipped, but we must eventually see the latest
+		deadline := time.After(5 * time.Second)
+		for done := false; !done; {
+			select {
+			case v := <-ch:
+				done = v == 10
+			case <-deadline:
+				t.Fatal("latest value not observed")
+			}
+		}
+
+		cancel()
+		for range ch {
+		}
+	}
+}
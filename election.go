@@ -0,0 +1,245 @@
+package natsutil
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	ErrNotLeader     = errors.ConstError("not the leader")
+	ErrAlreadyLeader = errors.ConstError("already the leader")
+	ErrNoLeader      = errors.ConstError("no leader has been elected")
+)
+
+// campaignRetryDelay is how long Run waits before campaigning again after a campaign fails with an error.
+const campaignRetryDelay = time.Second
+
+// ElectionOption configures an Election.
+type ElectionOption func(e *Election)
+
+// WithOnElected registers a callback which is invoked when leadership is gained. The context passed to it is
+// cancelled when leadership is lost, the callback should return promptly and perform any long-running work in
+// a separate routine.
+func WithOnElected(fn func(ctx context.Context)) ElectionOption {
+	return func(e *Election) {
+		e.onElected = fn
+	}
+}
+
+// WithOnRevoked registers a callback which is invoked when leadership is lost or resigned.
+func WithOnRevoked(fn func()) ElectionOption {
+	return func(e *Election) {
+		e.onRevoked = fn
+	}
+}
+
+// Election campaigns for leadership using a Lock, the owner of the lock is the leader.
+//
+// Leadership is gained by acquiring the lock and retained by the lock renewing its lease. Whilst leader the key is
+// watched so that any change made by another candidate, such as stealing an expired lease or deleting the key,
+// revokes leadership immediately rather than when the next renewal fails.
+type Election struct {
+	lock      *Lock
+	onElected func(ctx context.Context)
+	onRevoked func()
+	logger    *slog.Logger
+
+	mu   sync.Mutex
+	term *term
+}
+
+// term tracks a single period of leadership.
+type term struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	token  uint64
+	once   sync.Once
+}
+
+// NewElection creates an Election which campaigns using the provided lock.
+func NewElection(lock *Lock, opts ...ElectionOption) *Election {
+	e := &Election{lock: lock, logger: lock.logger}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Campaign blocks until leadership has been gained or the context is done. The returned context is cancelled
+// when leadership is lost or resigned.
+func (e *Election) Campaign(ctx context.Context) (context.Context, error) {
+	e.mu.Lock()
+	leader := e.term != nil
+	e.mu.Unlock()
+	if leader {
+		return nil, ErrAlreadyLeader
+	}
+
+	token, err := e.lock.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lost := e.lock.Done()
+
+	// any change made after we acquired the lock will be delivered by the watcher
+	watcher, err := e.lock.kv.Watch(e.lock.key)
+	if err != nil {
+		_ = e.lock.Release()
+		return nil, err
+	}
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	t := &term{ctx: leaderCtx, cancel: cancel, token: token}
+
+	e.mu.Lock()
+	e.term = t
+	e.mu.Unlock()
+
+	e.logger.Info("elected leader", slog.Uint64(LogKeyRevision, token))
+	if e.onElected != nil {
+		e.onElected(leaderCtx)
+	}
+
+	// monitor after notifying so that a revocation is never reported before the election
+	go e.monitor(t, watcher, lost)
+
+	return leaderCtx, nil
+}
+
+// monitor revokes leadership as soon as the lease is lost or modified by another candidate.
+func (e *Election) monitor(t *term, watcher KeyWatcher[LockLease], lost <-chan struct{}) {
+	defer func() { _ = watcher.Stop() }()
+
+	updates := watcher.UpdatesUnmarshalled()
+	for {
+		var reason string
+
+		select {
+		case <-t.ctx.Done():
+			// resigned
+			return
+		case <-lost:
+			reason = "lease could not be renewed"
+		case entry, ok := <-updates:
+			if !ok {
+				reason = "watcher closed"
+				break
+			}
+			if entry == nil {
+				// end of initial values
+				continue
+			}
+			if entry.Operation() != nats.KeyValuePut {
+				reason = "lease was removed"
+				break
+			}
+			lease, err := entry.UnmarshalValue()
+			if err != nil || lease.Owner != e.lock.owner {
+				reason = "lease was taken by another candidate"
+				break
+			}
+			// our own renewal
+			continue
+		}
+
+		e.logger.Warn("leadership lost", slog.String("reason", reason))
+		_ = e.revoke(t)
+		return
+	}
+}
+
+// revoke ends the term, releasing the lock if we still hold it. It is safe to call more than once.
+func (e *Election) revoke(t *term) (err error) {
+	t.once.Do(func() {
+		e.mu.Lock()
+		if e.term == t {
+			e.term = nil
+		}
+		e.mu.Unlock()
+
+		t.cancel()
+
+		if err = e.lock.Release(); errors.Is(err, ErrLockNotHeld) {
+			// the lease had already been lost
+			err = nil
+		}
+
+		e.logger.Info("leadership revoked", slog.Uint64(LogKeyRevision, t.token))
+		if e.onRevoked != nil {
+			e.onRevoked()
+		}
+	})
+	return err
+}
+
+// Resign gives up leadership, allowing another candidate to be elected.
+func (e *Election) Resign() error {
+	e.mu.Lock()
+	t := e.term
+	e.mu.Unlock()
+
+	if t == nil {
+		return ErrNotLeader
+	}
+	return e.revoke(t)
+}
+
+// IsLeader returns true whilst leadership is held.
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.term != nil
+}
+
+// Leader returns the owner of the lock of the currently elected leader.
+func (e *Election) Leader() (string, error) {
+	entry, err := e.lock.kv.Get(e.lock.key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return "", ErrNoLeader
+	} else if err != nil {
+		return "", err
+	}
+
+	lease, err := entry.UnmarshalValue()
+	if err != nil {
+		return "", err
+	}
+	return lease.Owner, nil
+}
+
+// Run campaigns repeatedly until the context is done, campaigning again whenever leadership is lost.
+// Leadership is resigned before returning.
+func (e *Election) Run(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		leaderCtx, err := e.Campaign(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			e.logger.Warn("campaign failed, retrying", slog.Int(LogKeyAttempt, attempt), slog.Any("error", err))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(campaignRetryDelay):
+			}
+			continue
+		}
+		attempt = 0
+
+		select {
+		case <-ctx.Done():
+			if err := e.Resign(); err != nil && !errors.Is(err, ErrNotLeader) {
+				return err
+			}
+			return ctx.Err()
+		case <-leaderCtx.Done():
+			// leadership lost, campaign again
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package natsutil_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElection_CampaignResign(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](createTestBucket(t, js), &encoder)
+
+	var elected, revoked atomic.Int32
+
+	a := natsutil.NewElection(
+		newLock(t, kv, "leader", natsutil.WithLockOwner("a")),
+		natsutil.WithOnElected(func(ctx context.Context) { elected.Add(1) }),
+		natsutil.WithOnRevoked(func() { revoked.Add(1) }),
+	)
+	b := natsutil.NewElection(newLock(t, kv, "leader", natsutil.WithLockOwner("b")))
+
+	_, err := a.Leader()
+	assert.ErrorIs(t, err, natsutil.ErrNoLeader)
+	assert.ErrorIs(t, a.Resign(), natsutil.ErrNotLeader)
+
+	ctx, err := a.Campaign(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, a.IsLeader())
+	assert.Equal(t, int32(1), elected.Load())
+
+	_, err = a.Campaign(context.Background())
+	assert.ErrorIs(t, err, natsutil.ErrAlreadyLeader)
+
+	leader, err := b.Leader()
+	assert.Nil(t, err)
+	assert.Equal(t, "a", leader)
+
+	// b blocks until a resigns
+	bElected := make(chan context.Context)
+	go func() {
+		ctx, err := b.Campaign(context.Background())
+		assert.Nil(t, err)
+		bElected <- ctx
+	}()
+
+	select {
+	case <-bElected:
+		t.Fatal("b elected whilst a is leader")
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.False(t, b.IsLeader())
+
+	assert.Nil(t, a.Resign())
+	assert.False(t, a.IsLeader())
+	assert.Equal(t, int32(1), revoked.Load())
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	select {
+	case <-bElected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("b not elected after a resigned")
+	}
+	assert.True(t, b.IsLeader())
+
+	leader, err = a.Leader()
+	assert.Nil(t, err)
+	assert.Equal(t, "b", leader)
+
+	assert.Nil(t, b.Resign())
+}
+
+func TestElection_WatchDetectsLoss(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](createTestBucket(t, js), &encoder)
+
+	revoked := make(chan struct{})
+
+	// renewals are infrequent so that only the watch can detect the loss in time
+	e := natsutil.NewElection(
+		newLock(t, kv, "leader", natsutil.WithLockTTL(time.Minute)),
+		natsutil.WithOnRevoked(func() { close(revoked) }),
+	)
+
+	ctx, err := e.Campaign(context.Background())
+	assert.Nil(t, err)
+
+	// another candidate takes over the key
+	_, err = kv.Put("leader", natsutil.LockLease{Owner: "usurper", TTL: time.Minute})
+	assert.Nil(t, err)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("loss of leadership not detected")
+	}
+	<-revoked
+	assert.False(t, e.IsLeader())
+
+	// the usurper's lease was left intact
+	leader, err := e.Leader()
+	assert.Nil(t, err)
+	assert.Equal(t, "usurper", leader)
+}
+
+func TestElection_Run(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](createTestBucket(t, js), &encoder)
+
+	var terms atomic.Int32
+	elected := make(chan struct{}, 2)
+
+	e := natsutil.NewElection(
+		newLock(t, kv, "leader", natsutil.WithLockTTL(time.Minute)),
+		natsutil.WithOnElected(func(ctx context.Context) {
+			terms.Add(1)
+			elected <- struct{}{}
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- e.Run(ctx) }()
+
+	<-elected
+
+	// deleting the key revokes leadership after which Run campaigns again
+	assert.Nil(t, kv.Delete("leader"))
+	select {
+	case <-elected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not re-elected")
+	}
+	assert.Equal(t, int32(2), terms.Load())
+
+	cancel()
+	assert.ErrorIs(t, <-result, context.Canceled)
+	assert.False(t, e.IsLeader())
+
+	_, err := e.Leader()
+	assert.ErrorIs(t, err, natsutil.ErrNoLeader)
+}
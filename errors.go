@@ -0,0 +1,17 @@
+package natsutil
+
+import (
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+// JSErrCodeStreamWrongLastSequence is returned by the server when a write expects a revision which does not match
+// the latest revision of the key.
+const JSErrCodeStreamWrongLastSequence nats.ErrorCode = 10071
+
+// IsWrongRevision reports whether err was caused by the revision check of a Create, Update, Delete or Purge failing,
+// which indicates another writer modified the key first.
+func IsWrongRevision(err error) bool {
+	var apiErr *nats.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == JSErrCodeStreamWrongLastSequence
+}
@@ -0,0 +1,38 @@
+package natsutil_test
+
+import (
+	"testing"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsWrongRevision(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[testPayload](createTestBucket(t, js), &encoder)
+
+	assert.False(t, natsutil.IsWrongRevision(nil))
+
+	_, err := kv.Create("foo", testPayload{1})
+	assert.Nil(t, err)
+
+	_, err = kv.Create("foo", testPayload{2})
+	assert.True(t, natsutil.IsWrongRevision(err))
+	assert.True(t, natsutil.IsWrongRevision(errors.Annotate(err, "wrapped")))
+
+	_, err = kv.Update("foo", testPayload{3}, 5)
+	assert.True(t, natsutil.IsWrongRevision(err))
+
+	err = kv.Delete("foo", nats.LastRevision(5))
+	assert.True(t, natsutil.IsWrongRevision(err))
+
+	_, err = kv.Get("bar")
+	assert.False(t, natsutil.IsWrongRevision(err))
+}
@@ -0,0 +1,280 @@
+package flags
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/41north/natsutil.go"
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	ErrNotStarted     = errors.ConstError("flags client has not been started")
+	ErrAlreadyStarted = errors.ConstError("flags client has already been started")
+	ErrWatchEnded     = errors.ConstError("flags watch ended before the current flags were loaded")
+)
+
+// DefaultPrefix is the subject prefix under which flags are stored if no prefix has been configured.
+const DefaultPrefix = "flags"
+
+// Option configures a Client.
+type Option func(c *Client)
+
+// WithPrefix sets the subject prefix under which flags are stored, defaults to DefaultPrefix.
+func WithPrefix(prefix *natsutil.SubjectBuilder) Option {
+	return func(c *Client) {
+		c.prefix = prefix.String()
+	}
+}
+
+// WithLogger sets the logger which receives snapshot updates.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// Change is a single revision of a flag from its audit trail.
+type Change struct {
+	Revision  uint64
+	Time      time.Time
+	Operation nats.KeyValueOp
+	// Flag is the definition after the change, it is empty when the flag was deleted.
+	Flag Flag
+}
+
+// Client evaluates flags against a local snapshot which is kept up to date by watching the bucket, so evaluations
+// never make a network round trip. It also manages flag definitions.
+//
+// Each flag is stored under '<prefix>.<key>'.
+type Client struct {
+	kv     natsutil.KeyValue[Flag]
+	prefix string
+	logger *slog.Logger
+
+	mu       sync.RWMutex
+	started  bool
+	snapshot map[string]Flag
+}
+
+// NewClient creates a Client for flags stored in the provided bucket.
+func NewClient(kv natsutil.KeyValue[Flag], opts ...Option) *Client {
+	c := &Client{kv: kv, prefix: DefaultPrefix, snapshot: make(map[string]Flag)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.logger == nil {
+		c.logger = natsutil.DiscardLogger()
+	}
+	c.logger = c.logger.With(slog.String(natsutil.LogKeyBucket, kv.Bucket()))
+	return c
+}
+
+func (c *Client) storageKey(key string) (string, error) {
+	// the prefix was validated when it was built
+	if err := natsutil.ValidateSubjectToken(key, natsutil.SubjectProfileStrict); err != nil {
+		return "", errors.Annotatef(err, "invalid flag key %q", key)
+	}
+	return c.prefix + natsutil.SubjectSeparator + key, nil
+}
+
+// Start loads the current flags and keeps the snapshot up to date until the context is done. ErrWatchEnded is
+// returned if the watch ends before the current flags have been loaded, for example because the connection closed.
+// The client can be started again if Start returns an error.
+func (c *Client) Start(ctx context.Context) error {
+	c.mu.Lock()
+	if c.started {
+		c.mu.Unlock()
+		return ErrAlreadyStarted
+	}
+	c.started = true
+	c.mu.Unlock()
+
+	watcher, err := c.kv.Watch(c.prefix + natsutil.SubjectSeparator + natsutil.SubjectStar)
+	if err != nil {
+		c.mu.Lock()
+		c.started = false
+		c.mu.Unlock()
+		return err
+	}
+
+	updates := watcher.UpdatesUnmarshalled()
+	ready := make(chan struct{})
+	// readyErr is written before ready is closed
+	var readyErr error
+
+	go func() {
+		defer func() { _ = watcher.Stop() }()
+
+		initialised := false
+		for {
+			select {
+			case <-ctx.Done():
+				if !initialised {
+					close(ready)
+				}
+				return
+			case entry, ok := <-updates:
+				if !ok {
+					if !initialised {
+						readyErr = ErrWatchEnded
+						close(ready)
+					} else {
+						c.logger.Warn("flags watch ended, the snapshot is no longer updated")
+					}
+					return
+				}
+				if entry == nil {
+					if !initialised {
+						initialised = true
+						close(ready)
+					}
+					continue
+				}
+				c.apply(entry)
+			}
+		}
+	}()
+
+	select {
+	case <-ready:
+		err = readyErr
+		if err == nil {
+			err = ctx.Err()
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		// allow starting again, the routine exits once the context is done or the watch has ended
+		c.mu.Lock()
+		c.started = false
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// apply updates the snapshot with a change received from the watcher.
+func (c *Client) apply(entry natsutil.KeyValueEntry[Flag]) {
+	key := entry.Key()[len(c.prefix)+len(natsutil.SubjectSeparator):]
+
+	if entry.Operation() != nats.KeyValuePut {
+		c.mu.Lock()
+		delete(c.snapshot, key)
+		c.mu.Unlock()
+		c.logger.Debug("flag removed", slog.String("flag", key))
+		return
+	}
+
+	flag, err := entry.UnmarshalValue()
+	if err != nil {
+		// keep the last known definition, the failure has already been logged when decoding
+		return
+	}
+
+	c.mu.Lock()
+	c.snapshot[key] = flag
+	c.mu.Unlock()
+	c.logger.Debug("flag updated", slog.String("flag", key), slog.Uint64(natsutil.LogKeyRevision, entry.Revision()))
+}
+
+// Flag returns the definition of a flag from the snapshot.
+func (c *Client) Flag(key string) (Flag, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	flag, ok := c.snapshot[key]
+	return flag, ok
+}
+
+// Flags returns every flag in the snapshot.
+func (c *Client) Flags() []Flag {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	flags := make([]Flag, 0, len(c.snapshot))
+	for _, flag := range c.snapshot {
+		flags = append(flags, flag)
+	}
+	return flags
+}
+
+// Evaluate determines whether a flag is enabled for the target. Unknown flags evaluate to false.
+func (c *Client) Evaluate(key string, target Target) (Evaluation, error) {
+	c.mu.RLock()
+	started := c.started
+	flag, ok := c.snapshot[key]
+	c.mu.RUnlock()
+
+	if !started {
+		return Evaluation{Key: key}, ErrNotStarted
+	}
+	if !ok {
+		return Evaluation{Key: key, Reason: ReasonNotFound}, nil
+	}
+	return flag.Evaluate(target), nil
+}
+
+// Bool is a convenience for Evaluate which returns fallback if the flag is unknown or the client is not started.
+func (c *Client) Bool(key string, target Target, fallback bool) bool {
+	evaluation, err := c.Evaluate(key, target)
+	if err != nil || evaluation.Reason == ReasonNotFound {
+		return fallback
+	}
+	return evaluation.Enabled
+}
+
+// Set stores the flag definition, returning its revision.
+func (c *Client) Set(flag Flag) (uint64, error) {
+	key, err := c.storageKey(flag.Key)
+	if err != nil {
+		return 0, err
+	}
+	return c.kv.Put(key, flag)
+}
+
+// Update stores the flag definition provided it has not been modified since the given revision.
+func (c *Client) Update(flag Flag, last uint64) (uint64, error) {
+	key, err := c.storageKey(flag.Key)
+	if err != nil {
+		return 0, err
+	}
+	return c.kv.Update(key, flag, last)
+}
+
+// Delete removes a flag, its history is retained for the audit trail.
+func (c *Client) Delete(key string) error {
+	storageKey, err := c.storageKey(key)
+	if err != nil {
+		return err
+	}
+	return c.kv.Delete(storageKey)
+}
+
+// History returns the audit trail of a flag, oldest change first. How many changes are retained is determined by
+// the history setting of the bucket.
+func (c *Client) History(key string) ([]Change, error) {
+	storageKey, err := c.storageKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := c.kv.History(storageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]Change, len(entries))
+	for idx, entry := range entries {
+		change := Change{Revision: entry.Revision(), Time: entry.Created(), Operation: entry.Operation()}
+		if entry.Operation() == nats.KeyValuePut {
+			if change.Flag, err = entry.UnmarshalValue(); err != nil {
+				return nil, errors.Annotatef(err, "failed to decode revision %d of flag %q", entry.Revision(), key)
+			}
+		}
+		changes[idx] = change
+	}
+	return changes, nil
+}
@@ -0,0 +1,200 @@
+package flags_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/41north/natsutil.go"
+	"github.com/41north/natsutil.go/flags"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/encoders/builtin"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[flags.Flag](createTestBucket(t, js), &builtin.JsonEncoder{})
+
+	client := flags.NewClient(kv)
+
+	_, err := client.Evaluate("new_checkout", flags.Target{})
+	assert.ErrorIs(t, err, flags.ErrNotStarted)
+	assert.True(t, client.Bool("new_checkout", flags.Target{}, true))
+
+	_, err = client.Set(flags.Flag{Key: "new_checkout", Enabled: false})
+	assert.Nil(t, err)
+
+	_, err = client.Set(flags.Flag{Key: "invalid key"})
+	assert.NotNil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.Nil(t, client.Start(ctx))
+	assert.ErrorIs(t, client.Start(ctx), flags.ErrAlreadyStarted)
+
+	target := flags.Target{Key: "u1", Attributes: map[string]string{"country": "ie"}}
+
+	// flags which existed before starting are loaded
+	evaluation, err := client.Evaluate("new_checkout", target)
+	assert.Nil(t, err)
+	assert.Equal(t, flags.ReasonDisabled, evaluation.Reason)
+
+	evaluation, err = client.Evaluate("unknown", target)
+	assert.Nil(t, err)
+	assert.Equal(t, flags.Evaluation{Key: "unknown", Reason: flags.ReasonNotFound}, evaluation)
+	assert.True(t, client.Bool("unknown", target, true))
+
+	// changes are applied to the snapshot
+	rev, err := client.Set(flags.Flag{
+		Key:     "new_checkout",
+		Enabled: true,
+		Rules:   []flags.Rule{{Attribute: "country", Operator: flags.OpIn, Values: []string{"ie"}, Serve: true}},
+		Rollout: percentage(0),
+	})
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		return client.Bool("new_checkout", target, false)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, client.Bool("new_checkout", flags.Target{Key: "u2"}, true))
+
+	// updates are rejected if the flag has been modified concurrently
+	_, err = client.Update(flags.Flag{Key: "new_checkout"}, rev-1)
+	assert.True(t, natsutil.IsWrongRevision(err))
+
+	_, err = client.Update(flags.Flag{Key: "new_checkout", Enabled: true}, rev)
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		flag, ok := client.Flag("new_checkout")
+		return ok && len(flag.Rules) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, len(client.Flags()))
+
+	assert.Nil(t, client.Delete("new_checkout"))
+	assert.Eventually(t, func() bool {
+		_, ok := client.Flag("new_checkout")
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// every change is retained in the audit trail
+	changes, err := client.History("new_checkout")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(changes))
+	assert.Equal(t, nats.KeyValuePut, changes[0].Operation)
+	assert.False(t, changes[0].Flag.Enabled)
+	assert.Equal(t, rev, changes[1].Revision)
+	assert.Equal(t, 1, len(changes[1].Flag.Rules))
+	assert.Equal(t, nats.KeyValueDelete, changes[3].Operation)
+	assert.Equal(t, flags.Flag{}, changes[3].Flag)
+	for _, change := range changes {
+		assert.False(t, change.Time.IsZero())
+	}
+}
+
+func TestClient_Prefix(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[flags.Flag](createTestBucket(t, js), &builtin.JsonEncoder{})
+
+	prefix := natsutil.SubjectBuilder{}
+	prefix.MustPush("app", "flags")
+
+	client := flags.NewClient(kv, flags.WithPrefix(&prefix))
+	_, err := client.Set(flags.Flag{Key: "dark_mode", Enabled: true})
+	assert.Nil(t, err)
+
+	_, err = kv.Get("app.flags.dark_mode")
+	assert.Nil(t, err)
+
+	// flags stored under another prefix are not loaded
+	_, err = flags.NewClient(kv).Set(flags.Flag{Key: "other", Enabled: true})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(t, client.Start(ctx))
+
+	assert.True(t, client.Bool("dark_mode", flags.Target{}, false))
+	_, ok := client.Flag("other")
+	assert.False(t, ok)
+}
+
+// endedWatcher is a watcher whose updates end before the initial values have been delivered.
+type endedWatcher struct {
+	natsutil.KeyWatcher[flags.Flag]
+}
+
+func (w endedWatcher) UpdatesUnmarshalled() <-chan natsutil.KeyValueEntry[flags.Flag] {
+	ch := make(chan natsutil.KeyValueEntry[flags.Flag])
+	close(ch)
+	return ch
+}
+
+func (w endedWatcher) Stop() error {
+	return nil
+}
+
+type endedWatchKeyValue struct {
+	natsutil.KeyValue[flags.Flag]
+}
+
+func (kv endedWatchKeyValue) Watch(string, ...nats.WatchOpt) (natsutil.KeyWatcher[flags.Flag], error) {
+	return endedWatcher{}, nil
+}
+
+func TestClient_StartWatchEnded(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[flags.Flag](createTestBucket(t, js), &builtin.JsonEncoder{})
+
+	client := flags.NewClient(endedWatchKeyValue{kv})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.ErrorIs(t, client.Start(ctx), flags.ErrWatchEnded)
+
+	// the client can be started again
+	_, err := client.Evaluate("new_checkout", flags.Target{})
+	assert.ErrorIs(t, err, flags.ErrNotStarted)
+	assert.ErrorIs(t, client.Start(ctx), flags.ErrWatchEnded)
+}
+
+func TestClient_StartCancelled(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[flags.Flag](createTestBucket(t, js), &builtin.JsonEncoder{})
+
+	client := flags.NewClient(kv)
+	_, err := client.Set(flags.Flag{Key: "new_checkout", Enabled: false})
+	assert.Nil(t, err)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, client.Start(cancelled), context.Canceled)
+
+	// the client can be started again once the first attempt has failed
+	_, err = client.Evaluate("new_checkout", flags.Target{})
+	assert.ErrorIs(t, err, flags.ErrNotStarted)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.Nil(t, client.Start(ctx))
+	evaluation, err := client.Evaluate("new_checkout", flags.Target{})
+	assert.Nil(t, err)
+	assert.Equal(t, flags.ReasonDisabled, evaluation.Reason)
+}
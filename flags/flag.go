@@ -0,0 +1,148 @@
+// Package flags provides feature flags stored in a JetStream Key-Value bucket and evaluated locally against a
+// watched, in-memory snapshot.
+package flags
+
+import (
+	"hash/fnv"
+	"strings"
+)
+
+// Operator determines how a Rule compares an attribute against its values.
+type Operator string
+
+const (
+	// OpIn matches when the attribute equals any of the values.
+	OpIn Operator = "in"
+	// OpNotIn matches when the attribute is present and equals none of the values.
+	OpNotIn Operator = "notIn"
+	// OpPrefix matches when the attribute starts with any of the values.
+	OpPrefix Operator = "prefix"
+	// OpSuffix matches when the attribute ends with any of the values.
+	OpSuffix Operator = "suffix"
+	// OpContains matches when the attribute contains any of the values.
+	OpContains Operator = "contains"
+)
+
+// Reason explains the outcome of an evaluation.
+type Reason string
+
+const (
+	ReasonNotFound  Reason = "not_found"
+	ReasonDisabled  Reason = "disabled"
+	ReasonRuleMatch Reason = "rule_match"
+	ReasonRollout   Reason = "rollout"
+	ReasonDefault   Reason = "default"
+)
+
+// rolloutBuckets is the resolution of percentage rollouts, allowing percentages with two decimal places.
+const rolloutBuckets = 10000
+
+// Flag is the definition of a feature flag as stored in the bucket.
+type Flag struct {
+	// Key uniquely identifies the flag.
+	Key string `json:"key"`
+	// Description explains what the flag controls.
+	Description string `json:"description,omitempty"`
+	// Enabled is a kill switch, a disabled flag always evaluates to false.
+	Enabled bool `json:"enabled"`
+	// Rules are evaluated in order, the first to match determines the outcome.
+	Rules []Rule `json:"rules,omitempty"`
+	// Rollout is the percentage, between 0 and 100, of targets which evaluate to true when no rule matches.
+	// When nil every target evaluates to true.
+	Rollout *float64 `json:"rollout,omitempty"`
+}
+
+// Rule targets evaluations based on the attributes of the target.
+type Rule struct {
+	// Attribute is the name of the target attribute to compare.
+	Attribute string `json:"attribute"`
+	// Operator determines how the attribute is compared against the values.
+	Operator Operator `json:"operator"`
+	// Values to compare the attribute against.
+	Values []string `json:"values"`
+	// Serve is the outcome when the rule matches.
+	Serve bool `json:"serve"`
+}
+
+// Target is what a flag is evaluated for, typically a user or a request.
+type Target struct {
+	// Key identifies the target, it is hashed to determine percentage rollouts.
+	Key string
+	// Attributes are matched against rules.
+	Attributes map[string]string
+}
+
+// Evaluation is the outcome of evaluating a flag.
+type Evaluation struct {
+	Key     string
+	Enabled bool
+	Reason  Reason
+	// Rule is the index of the matching rule when Reason is ReasonRuleMatch.
+	Rule int
+}
+
+// Matches reports whether the rule applies to the target. A rule never matches a target missing its attribute.
+func (r Rule) Matches(target Target) bool {
+	value, ok := target.Attributes[r.Attribute]
+	if !ok {
+		return false
+	}
+
+	var compare func(value, candidate string) bool
+	switch r.Operator {
+	case OpIn:
+		compare = func(value, candidate string) bool { return value == candidate }
+	case OpNotIn:
+		for _, candidate := range r.Values {
+			if value == candidate {
+				return false
+			}
+		}
+		return true
+	case OpPrefix:
+		compare = strings.HasPrefix
+	case OpSuffix:
+		compare = strings.HasSuffix
+	case OpContains:
+		compare = strings.Contains
+	default:
+		return false
+	}
+
+	for _, candidate := range r.Values {
+		if compare(value, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate determines whether the flag is enabled for the target.
+func (f Flag) Evaluate(target Target) Evaluation {
+	if !f.Enabled {
+		return Evaluation{Key: f.Key, Reason: ReasonDisabled}
+	}
+
+	for idx, rule := range f.Rules {
+		if rule.Matches(target) {
+			return Evaluation{Key: f.Key, Enabled: rule.Serve, Reason: ReasonRuleMatch, Rule: idx}
+		}
+	}
+
+	if f.Rollout != nil {
+		enabled := target.Key != "" && float64(RolloutBucket(f.Key, target.Key)) < *f.Rollout*rolloutBuckets/100
+		return Evaluation{Key: f.Key, Enabled: enabled, Reason: ReasonRollout}
+	}
+
+	return Evaluation{Key: f.Key, Enabled: true, Reason: ReasonDefault}
+}
+
+// RolloutBucket deterministically maps a target to one of 10000 buckets for the given flag. The flag key is
+// included in the hash so that the same targets are not always the first to receive every rollout.
+func RolloutBucket(flagKey string, targetKey string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(flagKey))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(targetKey))
+	return h.Sum32() % rolloutBuckets
+}
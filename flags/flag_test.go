@@ -0,0 +1,106 @@
+package flags_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/41north/natsutil.go/flags"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func percentage(p float64) *float64 {
+	return &p
+}
+
+func TestRule_Matches(t *testing.T) {
+	target := flags.Target{Key: "u1", Attributes: map[string]string{"country": "ie", "email": "jane@example.com"}}
+
+	tests := []struct {
+		rule    flags.Rule
+		matches bool
+	}{
+		{flags.Rule{Attribute: "country", Operator: flags.OpIn, Values: []string{"gb", "ie"}}, true},
+		{flags.Rule{Attribute: "country", Operator: flags.OpIn, Values: []string{"gb"}}, false},
+		{flags.Rule{Attribute: "country", Operator: flags.OpNotIn, Values: []string{"gb"}}, true},
+		{flags.Rule{Attribute: "country", Operator: flags.OpNotIn, Values: []string{"ie"}}, false},
+		{flags.Rule{Attribute: "email", Operator: flags.OpPrefix, Values: []string{"jane@"}}, true},
+		{flags.Rule{Attribute: "email", Operator: flags.OpSuffix, Values: []string{"@example.com"}}, true},
+		{flags.Rule{Attribute: "email", Operator: flags.OpContains, Values: []string{"example"}}, true},
+		{flags.Rule{Attribute: "email", Operator: flags.OpContains, Values: []string{"other"}}, false},
+		// missing attributes never match, not even for notIn
+		{flags.Rule{Attribute: "plan", Operator: flags.OpNotIn, Values: []string{"free"}}, false},
+		{flags.Rule{Attribute: "country", Operator: "unknown", Values: []string{"ie"}}, false},
+	}
+
+	for idx, test := range tests {
+		assert.Equal(t, test.matches, test.rule.Matches(target), "test %d", idx)
+	}
+}
+
+func TestFlag_Evaluate(t *testing.T) {
+	flag := flags.Flag{
+		Key:     "new_checkout",
+		Enabled: true,
+		Rules: []flags.Rule{
+			{Attribute: "plan", Operator: flags.OpIn, Values: []string{"free"}, Serve: false},
+			{Attribute: "email", Operator: flags.OpSuffix, Values: []string{"@example.com"}, Serve: true},
+		},
+	}
+
+	internal := flags.Target{Key: "u1", Attributes: map[string]string{"email": "jane@example.com"}}
+	free := flags.Target{Key: "u2", Attributes: map[string]string{"email": "joe@example.com", "plan": "free"}}
+	other := flags.Target{Key: "u3"}
+
+	evaluation := func(enabled bool, reason flags.Reason, rule int) flags.Evaluation {
+		return flags.Evaluation{Key: "new_checkout", Enabled: enabled, Reason: reason, Rule: rule}
+	}
+
+	assert.Equal(t, evaluation(true, flags.ReasonRuleMatch, 1), flag.Evaluate(internal))
+	assert.Equal(t, evaluation(false, flags.ReasonRuleMatch, 0), flag.Evaluate(free))
+	assert.Equal(t, evaluation(true, flags.ReasonDefault, 0), flag.Evaluate(other))
+
+	flag.Rollout = percentage(0)
+	assert.Equal(t, evaluation(false, flags.ReasonRollout, 0), flag.Evaluate(other))
+
+	flag.Rollout = percentage(100)
+	assert.Equal(t, evaluation(true, flags.ReasonRollout, 0), flag.Evaluate(other))
+
+	flag.Enabled = false
+	assert.Equal(t, flags.Evaluation{Key: "new_checkout", Reason: flags.ReasonDisabled}, flag.Evaluate(internal))
+}
+
+func TestFlag_Rollout(t *testing.T) {
+	flag := flags.Flag{Key: "new_checkout", Enabled: true, Rollout: percentage(25)}
+
+	enabled := 0
+	for i := 0; i < 10000; i++ {
+		target := flags.Target{Key: fmt.Sprintf("user-%d", i)}
+		evaluation := flag.Evaluate(target)
+		if evaluation.Enabled {
+			enabled++
+		}
+		// evaluations are deterministic
+		assert.Equal(t, evaluation, flag.Evaluate(target))
+	}
+	assert.InDelta(t, 2500, enabled, 250)
+
+	// increasing the percentage only adds targets
+	wider := flags.Flag{Key: "new_checkout", Enabled: true, Rollout: percentage(50)}
+	for i := 0; i < 1000; i++ {
+		target := flags.Target{Key: fmt.Sprintf("user-%d", i)}
+		if flag.Evaluate(target).Enabled {
+			assert.True(t, wider.Evaluate(target).Enabled)
+		}
+	}
+
+	// targets without a key cannot be bucketed
+	assert.False(t, wider.Evaluate(flags.Target{}).Enabled)
+}
+
+func TestRolloutBucket(t *testing.T) {
+	assert.Equal(t, flags.RolloutBucket("a", "user"), flags.RolloutBucket("a", "user"))
+	assert.Less(t, flags.RolloutBucket("a", "user"), uint32(10000))
+	// the separator prevents ambiguous concatenations
+	assert.NotEqual(t, flags.RolloutBucket("ab", "c"), flags.RolloutBucket("a", "bc"))
+}
@@ -0,0 +1,12 @@
+package flags_test
+
+import (
+	"github.com/41north/natsutil.go/internal/natstest"
+)
+
+var (
+	runBasicJetStreamServer          = natstest.RunBasicJetStreamServer
+	jsClient                         = natstest.JSClient
+	shutdownJSServerAndRemoveStorage = natstest.ShutdownJSServerAndRemoveStorage
+	createTestBucket                 = natstest.CreateTestBucket
+)
@@ -83,7 +83,7 @@
           [
             gcc
             delve # https://github.com/go-delve/delve
-            go_1_19 # https://go.dev/
+            go_1_21 # https://go.dev/
             gotools # https://go.googlesource.com/tools
             websocat # https://github.com/vi/websocat
           ]
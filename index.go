@@ -0,0 +1,338 @@
+package natsutil
+
+import (
+	"encoding/base64"
+	"log/slog"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	ErrIndexNotFound = errors.ConstError("index not found")
+	ErrIndexExists   = errors.ConstError("index already exists")
+)
+
+// IndexFunc extracts the values an entry is indexed by. An entry can be indexed by any number of values, empty
+// values are ignored.
+type IndexFunc[T any] func(value T) []string
+
+// IndexOption configures an IndexedKeyValue.
+type IndexOption func(c *indexConfig)
+
+type indexConfig struct {
+	logger *slog.Logger
+}
+
+// WithIndexLogger sets the logger which receives index maintenance events.
+func WithIndexLogger(logger *slog.Logger) IndexOption {
+	return func(c *indexConfig) {
+		c.logger = logger
+	}
+}
+
+// IndexedKeyValue is a KeyValue which maintains secondary indexes in a companion bucket, allowing entries to be
+// found by the values extracted from them rather than only by key.
+//
+// Index entries are stored in the companion bucket under '<index>.<value>.<key>', with the value and key base64
+// encoded so that they can contain any character. Writes add index entries for the new value before writing the
+// entry and remove those for the previous value afterwards, so a failure part way through can leave stale index
+// entries but never missing ones. Stale entries are filtered out when querying and removed by Rebuild.
+//
+// Concurrent Puts to the same key can race such that one removes an index entry the other still needs, keys with
+// concurrent writers should be modified with Update so that only one of the writes succeeds.
+type IndexedKeyValue[T any] struct {
+	KeyValue[T]
+	index  nats.KeyValue
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	indexes map[string]IndexFunc[T]
+}
+
+// NewIndexedKeyValue wraps kv so that the indexes registered with AddIndex are maintained in the index bucket.
+func NewIndexedKeyValue[T any](kv KeyValue[T], index nats.KeyValue, opts ...IndexOption) *IndexedKeyValue[T] {
+	config := indexConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.logger == nil {
+		config.logger = DiscardLogger()
+	}
+	return &IndexedKeyValue[T]{
+		KeyValue: kv,
+		index:    index,
+		logger:   config.logger.With(slog.String(LogKeyBucket, kv.Bucket()), slog.String(LogKeyIndexBucket, index.Bucket())),
+		indexes:  make(map[string]IndexFunc[T]),
+	}
+}
+
+// AddIndex registers an index, the name must be a valid subject token. Entries written before the index was added
+// are not indexed until Rebuild is called.
+func (k *IndexedKeyValue[T]) AddIndex(name string, fn IndexFunc[T]) error {
+	if err := ValidateSubjectToken(name, SubjectProfileStrict); err != nil {
+		return errors.Annotatef(err, "invalid index name %q", name)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.indexes[name]; ok {
+		return ErrIndexExists
+	}
+	k.indexes[name] = fn
+	return nil
+}
+
+// Indexes returns the names of the registered indexes.
+func (k *IndexedKeyValue[T]) Indexes() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	names := make([]string, 0, len(k.indexes))
+	for name := range k.indexes {
+		names = append(names, name)
+	}
+	return names
+}
+
+func encodeIndexToken(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func indexKey(name, value, key string) string {
+	return name + SubjectSeparator + encodeIndexToken(value) + SubjectSeparator + encodeIndexToken(key)
+}
+
+// indexKeys returns the keys of every index entry for a value stored under key.
+func (k *IndexedKeyValue[T]) indexKeys(key string, value T) map[string]struct{} {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make(map[string]struct{})
+	for name, fn := range k.indexes {
+		for _, v := range fn(value) {
+			if v != "" {
+				keys[indexKey(name, v, key)] = struct{}{}
+			}
+		}
+	}
+	return keys
+}
+
+// currentIndexKeys returns the keys of the index entries for the value currently stored under key.
+func (k *IndexedKeyValue[T]) currentIndexKeys(key string) (map[string]struct{}, error) {
+	entry, err := k.KeyValue.Get(key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	value, err := entry.UnmarshalValue()
+	if err != nil {
+		// any index entries for the current value are left for Rebuild to remove
+		k.logger.Warn("failed to decode current value, its index entries cannot be removed", entryAttrs(entry)...)
+		return nil, nil
+	}
+	return k.indexKeys(key, value), nil
+}
+
+// write wraps a write to key so that the indexes are updated to reflect value, or removed if value is nil.
+func (k *IndexedKeyValue[T]) write(key string, value *T, fn func() (uint64, error)) (uint64, error) {
+	previous, err := k.currentIndexKeys(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var next map[string]struct{}
+	if value != nil {
+		next = k.indexKeys(key, *value)
+	}
+
+	for idxKey := range next {
+		if _, ok := previous[idxKey]; ok {
+			continue
+		}
+		if _, err := k.index.Put(idxKey, []byte(key)); err != nil {
+			return 0, errors.Annotatef(err, "failed to add index entry %q", idxKey)
+		}
+	}
+
+	revision, err := fn()
+	if err != nil {
+		return 0, err
+	}
+
+	for idxKey := range previous {
+		if _, ok := next[idxKey]; ok {
+			continue
+		}
+		if err := k.index.Purge(idxKey); err != nil {
+			// the entry is stale and will be filtered out of queries
+			k.logger.Warn("failed to remove index entry",
+				slog.String(LogKeyKey, idxKey),
+				slog.Any("error", err),
+			)
+		}
+	}
+
+	return revision, nil
+}
+
+// Put will place the new value for the key into the store, updating the indexes.
+func (k *IndexedKeyValue[T]) Put(key string, value T) (uint64, error) {
+	return k.write(key, &value, func() (uint64, error) {
+		return k.KeyValue.Put(key, value)
+	})
+}
+
+// Create will add the key/value pair iff it does not exist, updating the indexes.
+func (k *IndexedKeyValue[T]) Create(key string, value T) (uint64, error) {
+	return k.write(key, &value, func() (uint64, error) {
+		return k.KeyValue.Create(key, value)
+	})
+}
+
+// Update will update the value iff the latest revision matches, updating the indexes.
+func (k *IndexedKeyValue[T]) Update(key string, value T, last uint64) (uint64, error) {
+	return k.write(key, &value, func() (uint64, error) {
+		return k.KeyValue.Update(key, value, last)
+	})
+}
+
+// PutMany places the values into the store concurrently, updating the indexes.
+func (k *IndexedKeyValue[T]) PutMany(values map[string]T, opts ...BatchOption) (map[string]uint64, error) {
+	return putMany(values, newBatchConfig(opts), k.Put)
+}
+
+// Delete will place a delete marker and leave all revisions, removing the key from the indexes.
+func (k *IndexedKeyValue[T]) Delete(key string, opts ...nats.DeleteOpt) error {
+	_, err := k.write(key, nil, func() (uint64, error) {
+		return 0, k.KeyValue.Delete(key, opts...)
+	})
+	return err
+}
+
+// Purge will place a delete marker and remove all previous revisions, removing the key from the indexes.
+func (k *IndexedKeyValue[T]) Purge(key string, opts ...nats.DeleteOpt) error {
+	_, err := k.write(key, nil, func() (uint64, error) {
+		return 0, k.KeyValue.Purge(key, opts...)
+	})
+	return err
+}
+
+// FindBy returns the entries which the named index maps to value.
+func (k *IndexedKeyValue[T]) FindBy(name string, value string) ([]KeyValueEntry[T], error) {
+	k.mu.RLock()
+	fn, ok := k.indexes[name]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, ErrIndexNotFound
+	} else if value == "" {
+		// empty values are never indexed
+		return nil, nil
+	}
+
+	keys, err := k.indexedKeys(name + SubjectSeparator + encodeIndexToken(value) + SubjectSeparator + SubjectStar)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []KeyValueEntry[T]
+	for _, key := range keys {
+		entry, err := k.KeyValue.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		current, err := entry.UnmarshalValue()
+		if err != nil {
+			continue
+		}
+
+		// the index entry may be stale, or for a write which has not yet completed
+		matches := false
+		for _, v := range fn(current) {
+			matches = matches || v == value
+		}
+		if matches {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// indexedKeys returns the keys of the entries referenced by the index entries matching subject.
+func (k *IndexedKeyValue[T]) indexedKeys(subject string) ([]string, error) {
+	watcher, err := k.index.Watch(subject, nats.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = watcher.Stop() }()
+
+	var keys []string
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		keys = append(keys, string(entry.Value()))
+	}
+	return keys, nil
+}
+
+// Rebuild scans every entry in the bucket and brings the index bucket into line with it, adding missing index
+// entries and removing stale ones, including those of indexes which are no longer registered.
+func (k *IndexedKeyValue[T]) Rebuild() error {
+	expected := make(map[string]string)
+
+	watcher, err := k.KeyValue.WatchAll(nats.IgnoreDeletes())
+	if err != nil {
+		return err
+	}
+	for entry := range watcher.UpdatesUnmarshalled() {
+		if entry == nil {
+			break
+		}
+		value, err := entry.UnmarshalValue()
+		if err != nil {
+			// already logged when decoding
+			continue
+		}
+		for idxKey := range k.indexKeys(entry.Key(), value) {
+			expected[idxKey] = entry.Key()
+		}
+	}
+	if err = watcher.Stop(); err != nil {
+		return err
+	}
+
+	existing, err := k.index.Keys()
+	if err != nil && !errors.Is(err, nats.ErrNoKeysFound) {
+		return err
+	}
+
+	removed := 0
+	for _, idxKey := range existing {
+		if _, ok := expected[idxKey]; ok {
+			delete(expected, idxKey)
+			continue
+		}
+		if err := k.index.Purge(idxKey); err != nil {
+			return errors.Annotatef(err, "failed to remove index entry %q", idxKey)
+		}
+		removed++
+	}
+
+	for idxKey, key := range expected {
+		if _, err := k.index.Put(idxKey, []byte(key)); err != nil {
+			return errors.Annotatef(err, "failed to add index entry %q", idxKey)
+		}
+	}
+
+	k.logger.Info("rebuilt indexes", slog.Int("added", len(expected)), slog.Int("removed", removed))
+	return nil
+}
@@ -0,0 +1,138 @@
+package natsutil_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+	"github.com/nats-io/nats.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testUser struct {
+	Email string   `json:"email"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+func newIndexedUsers(t *testing.T, js nats.JetStreamContext) (*natsutil.IndexedKeyValue[testUser], nats.KeyValue) {
+	t.Helper()
+
+	index, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "TestIndexBucket"})
+	assert.Nil(t, err)
+
+	kv := natsutil.NewIndexedKeyValue[testUser](
+		natsutil.NewKeyValue[testUser](createTestBucket(t, js), &encoder),
+		index,
+	)
+	assert.Nil(t, kv.AddIndex("email", func(user testUser) []string { return []string{user.Email} }))
+	assert.Nil(t, kv.AddIndex("role", func(user testUser) []string { return user.Roles }))
+
+	return kv, index
+}
+
+func findKeys(t *testing.T, kv *natsutil.IndexedKeyValue[testUser], name string, value string) []string {
+	t.Helper()
+	entries, err := kv.FindBy(name, value)
+	assert.Nil(t, err)
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Key())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestIndexedKeyValue(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv, _ := newIndexedUsers(t, js)
+
+	assert.ErrorIs(t, kv.AddIndex("email", nil), natsutil.ErrIndexExists)
+	assert.NotNil(t, kv.AddIndex("%", nil))
+
+	_, err := kv.FindBy("unknown", "")
+	assert.ErrorIs(t, err, natsutil.ErrIndexNotFound)
+
+	_, err = kv.Put("users.1", testUser{Email: "jane@example.com", Roles: []string{"admin", "dev"}})
+	assert.Nil(t, err)
+	rev, err := kv.Create("users.2", testUser{Email: "joe@example.com", Roles: []string{"dev"}})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"users.1"}, findKeys(t, kv, "email", "jane@example.com"))
+	assert.Equal(t, []string{"users.1", "users.2"}, findKeys(t, kv, "role", "dev"))
+	assert.Empty(t, findKeys(t, kv, "email", "other@example.com"))
+
+	entries, err := kv.FindBy("email", "joe@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	user, err := entries[0].UnmarshalValue()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"dev"}, user.Roles)
+
+	// changes to indexed values move the entry between index values
+	_, err = kv.Update("users.2", testUser{Email: "joseph@example.com", Roles: []string{"admin"}}, rev)
+	assert.Nil(t, err)
+
+	assert.Empty(t, findKeys(t, kv, "email", "joe@example.com"))
+	assert.Equal(t, []string{"users.2"}, findKeys(t, kv, "email", "joseph@example.com"))
+	assert.Equal(t, []string{"users.1"}, findKeys(t, kv, "role", "dev"))
+	assert.Equal(t, []string{"users.1", "users.2"}, findKeys(t, kv, "role", "admin"))
+
+	// a failed write leaves the indexes unchanged
+	_, err = kv.Update("users.2", testUser{Email: "stale@example.com"}, rev)
+	assert.True(t, natsutil.IsWrongRevision(err))
+	assert.Empty(t, findKeys(t, kv, "email", "stale@example.com"))
+
+	assert.Nil(t, kv.Delete("users.1"))
+	assert.Empty(t, findKeys(t, kv, "email", "jane@example.com"))
+	assert.Equal(t, []string{"users.2"}, findKeys(t, kv, "role", "admin"))
+
+	assert.Nil(t, kv.Purge("users.2"))
+	assert.Empty(t, findKeys(t, kv, "role", "admin"))
+
+	// batched writes are indexed too
+	_, err = kv.PutMany(map[string]testUser{
+		"users.3": {Email: "a@example.com", Roles: []string{"ops"}},
+		"users.4": {Email: "b@example.com", Roles: []string{"ops"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users.3", "users.4"}, findKeys(t, kv, "role", "ops"))
+}
+
+func TestIndexedKeyValue_Rebuild(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv, index := newIndexedUsers(t, js)
+
+	// entries written without maintaining the indexes
+	_, err := kv.KeyValue.Put("users.1", testUser{Email: "jane@example.com"})
+	assert.Nil(t, err)
+	_, err = kv.Put("users.2", testUser{Email: "joe@example.com"})
+	assert.Nil(t, err)
+	_, err = kv.KeyValue.Put("users.2", testUser{Email: "joseph@example.com"})
+	assert.Nil(t, err)
+
+	assert.Empty(t, findKeys(t, kv, "email", "jane@example.com"))
+	// stale index entries are filtered out
+	assert.Empty(t, findKeys(t, kv, "email", "joe@example.com"))
+
+	assert.Nil(t, kv.Rebuild())
+
+	assert.Equal(t, []string{"users.1"}, findKeys(t, kv, "email", "jane@example.com"))
+	assert.Equal(t, []string{"users.2"}, findKeys(t, kv, "email", "joseph@example.com"))
+
+	keys, err := index.Keys()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(keys))
+
+	// rebuilding is idempotent
+	assert.Nil(t, kv.Rebuild())
+	keys, err = index.Keys()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(keys))
+}
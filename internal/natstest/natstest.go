@@ -0,0 +1,71 @@
+// Package natstest provides the embedded NATS server fixtures shared by the tests of this module.
+package natstest
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+// RunBasicJetStreamServer starts an embedded server with JetStream enabled on a random port.
+func RunBasicJetStreamServer(t testing.TB) *server.Server {
+	t.Helper()
+	opts := test.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	return test.RunServer(&opts)
+}
+
+// Client connects to the server, failing the test if it cannot.
+func Client(t testing.TB, s *server.Server, opts ...nats.Option) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect(s.ClientURL(), opts...)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return nc
+}
+
+// JSClient connects to the server and returns a JetStream context for the connection.
+func JSClient(t testing.TB, s *server.Server, opts ...nats.Option) (*nats.Conn, nats.JetStreamContext) {
+	t.Helper()
+	nc := Client(t, s, opts...)
+	js, err := nc.JetStream(nats.MaxWait(10 * time.Second))
+	if err != nil {
+		t.Fatalf("Unexpected error getting JetStream context: %v", err)
+	}
+	return nc, js
+}
+
+// ShutdownJSServerAndRemoveStorage stops the server and removes its JetStream storage directory.
+func ShutdownJSServerAndRemoveStorage(t testing.TB, s *server.Server) {
+	t.Helper()
+	var sd string
+	if config := s.JetStreamConfig(); config != nil {
+		sd = config.StoreDir
+	}
+	s.Shutdown()
+	if sd != "" {
+		if err := os.RemoveAll(sd); err != nil {
+			t.Fatalf("Unable to remove storage %q: %v", sd, err)
+		}
+	}
+	s.WaitForShutdown()
+}
+
+// CreateTestBucket creates the Key-Value bucket 'TestBucket' holding up to 10 revisions of each key.
+func CreateTestBucket(t testing.TB, js nats.JetStreamContext) nats.KeyValue {
+	t.Helper()
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:  "TestBucket",
+		History: 10,
+	})
+	assert.Nil(t, err, "failed to create test bucket")
+	return kv
+}
@@ -1,6 +1,11 @@
 package natsutil
 
-import "github.com/nats-io/nats.go"
+import (
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
 
 // KeyValue provides a generic interface for nats.KeyValue.
 type KeyValue[T any] interface {
@@ -8,21 +13,35 @@ type KeyValue[T any] interface {
 	Delegate() nats.KeyValue
 	// Encoder returns the codec used for marshalling to and from bytes.
 	Encoder() nats.Encoder
+	// KeyEncoding returns the encoding applied to keys, or nil if keys are used as they are.
+	KeyEncoding() TokenEncoding
 	// Get returns the latest value for the key.
 	Get(key string) (entry KeyValueEntry[T], err error)
 	// GetRevision returns a specific revision value for the key.
 	GetRevision(key string, revision uint64) (entry KeyValueEntry[T], err error)
+	// GetMany returns the latest entries for the keys, fetching them concurrently. Keys which could not be fetched,
+	// including those which do not exist, are omitted from the result and reported in a *BatchError.
+	GetMany(keys []string, opts ...BatchOption) (entries map[string]KeyValueEntry[T], err error)
 	// Put will place the new value for the key into the store.
 	Put(key string, value T) (revision uint64, err error)
 	// Create will add the key/value pair iff it does not exist.
 	Create(key string, value T) (revision uint64, err error)
 	// Update will update the value iff the latest revision matches.
 	Update(key string, value T, last uint64) (revision uint64, err error)
+	// PutMany places the values into the store concurrently, returning the revision each key was written at. Keys
+	// which could not be written are omitted from the result and reported in a *BatchError.
+	PutMany(values map[string]T, opts ...BatchOption) (revisions map[string]uint64, err error)
+	// Delete will place a delete marker and leave all revisions.
+	Delete(key string, opts ...nats.DeleteOpt) error
+	// Purge will place a delete marker and remove all previous revisions.
+	Purge(key string, opts ...nats.DeleteOpt) error
 	// Watch for any updates to keys that match the keys argument which could include wildcards.
 	// Watch will send a nil entry when it has received all initial values.
 	Watch(keys string, opts ...nats.WatchOpt) (KeyWatcher[T], error)
 	// WatchAll will invoke the callback for all updates.
 	WatchAll(opts ...nats.WatchOpt) (KeyWatcher[T], error)
+	// Keys will return all the keys in the bucket, or nats.ErrNoKeysFound if there are none.
+	Keys(opts ...nats.WatchOpt) ([]string, error)
 	// History will return all historical values for the key.
 	History(key string, opts ...nats.WatchOpt) ([]KeyValueEntry[T], error)
 	// Bucket returns the current bucket name.
@@ -30,8 +49,11 @@ type KeyValue[T any] interface {
 }
 
 type kv[T any] struct {
-	encoder  nats.Encoder
-	delegate nats.KeyValue
+	encoder   nats.Encoder
+	keys      TokenEncoding
+	delegate  nats.KeyValue
+	telemetry *telemetry
+	logger    *slog.Logger
 }
 
 func (k *kv[T]) Delegate() nats.KeyValue {
@@ -42,72 +64,153 @@ func (k *kv[T]) Encoder() nats.Encoder {
 	return k.encoder
 }
 
+func (k *kv[T]) KeyEncoding() TokenEncoding {
+	return k.keys
+}
+
 func (k *kv[T]) Get(key string) (entry KeyValueEntry[T], err error) {
-	delegate, err := k.delegate.Get(key)
+	op := k.startOperation("Get", key)
+	defer func() { op.end(err) }()
+
+	delegate, err := k.delegate.Get(EncodeKey(k.keys, key))
 	if err != nil {
 		return nil, err
 	}
-	return &kve[T]{delegate: delegate, encoder: k.encoder}, nil
+	op.setRevision(delegate.Revision())
+	return k.newEntry(delegate), nil
 }
 
 func (k *kv[T]) GetRevision(key string, revision uint64) (entry KeyValueEntry[T], err error) {
-	delegate, err := k.delegate.GetRevision(key, revision)
+	op := k.startOperation("GetRevision", key)
+	defer func() { op.end(err) }()
+
+	op.setRevision(revision)
+	delegate, err := k.delegate.GetRevision(EncodeKey(k.keys, key), revision)
 	if err != nil {
 		return nil, err
 	}
-	return &kve[T]{delegate: delegate, encoder: k.encoder}, nil
+	return k.newEntry(delegate), nil
 }
 
 func (k *kv[T]) Put(key string, value T) (revision uint64, err error) {
-	bytes, err := k.encoder.Encode("", value)
+	op := k.startOperation("Put", key)
+	defer func() { op.end(err) }()
+
+	bytes, err := k.encode(op, value)
 	if err != nil {
 		return 0, err
 	}
-	return k.delegate.Put(key, bytes)
+	revision, err = k.delegate.Put(EncodeKey(k.keys, key), bytes)
+	op.setRevision(revision)
+	return revision, err
 }
 
 func (k *kv[T]) Create(key string, value T) (revision uint64, err error) {
-	bytes, err := k.encoder.Encode("", value)
+	op := k.startOperation("Create", key)
+	defer func() { op.end(err) }()
+
+	bytes, err := k.encode(op, value)
 	if err != nil {
 		return 0, err
 	}
-	return k.delegate.Create(key, bytes)
+	revision, err = k.delegate.Create(EncodeKey(k.keys, key), bytes)
+	op.setRevision(revision)
+	return revision, err
 }
 
 func (k *kv[T]) Update(key string, value T, last uint64) (revision uint64, err error) {
-	bytes, err := k.encoder.Encode("", value)
+	op := k.startOperation("Update", key)
+	defer func() { op.end(err) }()
+
+	bytes, err := k.encode(op, value)
 	if err != nil {
 		return 0, err
 	}
-	return k.delegate.Update(key, bytes, last)
+	revision, err = k.delegate.Update(EncodeKey(k.keys, key), bytes, last)
+	op.setRevision(revision)
+	return revision, err
+}
+
+func (k *kv[T]) Delete(key string, opts ...nats.DeleteOpt) (err error) {
+	op := k.startOperation("Delete", key)
+	defer func() { op.end(err) }()
+
+	return k.delegate.Delete(EncodeKey(k.keys, key), opts...)
+}
+
+func (k *kv[T]) Purge(key string, opts ...nats.DeleteOpt) (err error) {
+	op := k.startOperation("Purge", key)
+	defer func() { op.end(err) }()
+
+	return k.delegate.Purge(EncodeKey(k.keys, key), opts...)
 }
 
-func (k *kv[T]) Watch(keys string, opts ...nats.WatchOpt) (KeyWatcher[T], error) {
-	kw, err := k.delegate.Watch(keys, opts...)
+func (k *kv[T]) Watch(keys string, opts ...nats.WatchOpt) (watcher KeyWatcher[T], err error) {
+	op := k.startOperation("Watch", keys)
+	defer func() { op.end(err) }()
+
+	kw, err := k.delegate.Watch(encodePattern(k.keys, keys), opts...)
 	if err != nil {
 		return nil, err
 	}
-	return NewKeyWatcher[T](kw, k.encoder), nil
+	k.logger.Debug("watcher started", slog.String(LogKeyKey, keys))
+	return newKeyWatcher[T](kw, k.encoder, k.keys, k.telemetry, k.logger), nil
 }
 
-func (k *kv[T]) WatchAll(opts ...nats.WatchOpt) (KeyWatcher[T], error) {
+func (k *kv[T]) WatchAll(opts ...nats.WatchOpt) (watcher KeyWatcher[T], err error) {
+	op := k.startOperation("WatchAll", "")
+	defer func() { op.end(err) }()
+
 	kw, err := k.delegate.WatchAll(opts...)
 	if err != nil {
 		return nil, err
 	}
-	return NewKeyWatcher[T](kw, k.encoder), nil
+	k.logger.Debug("watcher started")
+	return newKeyWatcher[T](kw, k.encoder, k.keys, k.telemetry, k.logger), nil
+}
+
+func (k *kv[T]) Keys(opts ...nats.WatchOpt) (keys []string, err error) {
+	op := k.startOperation("Keys", "")
+	defer func() { op.end(err) }()
+
+	watcher, err := k.delegate.WatchAll(append(opts, nats.IgnoreDeletes(), nats.MetaOnly())...)
+	if err != nil {
+		return nil, err
+	}
+	return collectKeys(newKeyWatcher[T](watcher, k.encoder, k.keys, k.telemetry, k.logger))
+}
+
+// collectKeys returns the keys of the initial values received by the watcher before stopping it.
+func collectKeys[T any](watcher KeyWatcher[T]) ([]string, error) {
+	var keys []string
+	for entry := range watcher.UpdatesUnmarshalled() {
+		if entry == nil {
+			break
+		}
+		keys = append(keys, entry.Key())
+	}
+	if err := watcher.Stop(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nats.ErrNoKeysFound
+	}
+	return keys, nil
 }
 
-func (k *kv[T]) History(key string, opts ...nats.WatchOpt) ([]KeyValueEntry[T], error) {
-	entries, err := k.delegate.History(key, opts...)
+func (k *kv[T]) History(key string, opts ...nats.WatchOpt) (typedEntries []KeyValueEntry[T], err error) {
+	op := k.startOperation("History", key)
+	defer func() { op.end(err) }()
+
+	entries, err := k.delegate.History(EncodeKey(k.keys, key), opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	// convert into typed entries
-	typedEntries := make([]KeyValueEntry[T], len(entries))
+	typedEntries = make([]KeyValueEntry[T], len(entries))
 	for idx, delegate := range entries {
-		typedEntries[idx] = &kve[T]{delegate: delegate, encoder: k.encoder}
+		typedEntries[idx] = k.newEntry(delegate)
 	}
 
 	return typedEntries, nil
@@ -117,6 +220,34 @@ func (k *kv[T]) Bucket() string {
 	return k.delegate.Bucket()
 }
 
-func NewKeyValue[T any](delegate nats.KeyValue, encoder nats.Encoder) KeyValue[T] {
-	return &kv[T]{delegate: delegate, encoder: encoder}
+// startOperation begins tracking an operation against the bucket, errors are logged when it ends.
+func (k *kv[T]) startOperation(name string, key string) *operation {
+	op := k.telemetry.startOperation(name, k.Bucket(), key)
+	op.logger = k.logger
+	op.key = key
+	return op
+}
+
+// encode marshals the value into bytes, recording the time taken and the size of the result.
+func (k *kv[T]) encode(op *operation, value T) ([]byte, error) {
+	start := time.Now()
+	bytes, err := k.encoder.Encode("", value)
+	op.recordEncode(time.Since(start), len(bytes), err)
+	return bytes, err
+}
+
+func (k *kv[T]) newEntry(delegate nats.KeyValueEntry) *kve[T] {
+	return newKeyValueEntry[T](delegate, k.encoder, k.keys, k.telemetry, k.logger)
+}
+
+// NewKeyValue creates a generic KeyValue which uses the provided encoder for marshalling values to and from bytes.
+func NewKeyValue[T any](delegate nats.KeyValue, encoder nats.Encoder, opts ...Option) KeyValue[T] {
+	options := newOptions(opts)
+	return &kv[T]{
+		delegate:  delegate,
+		encoder:   encoder,
+		keys:      options.keyEncoding,
+		telemetry: newTelemetry(options),
+		logger:    newLogger(options).With(slog.String(LogKeyBucket, delegate.Bucket())),
+	}
 }
@@ -1,6 +1,8 @@
 package natsutil
 
 import (
+	"context"
+	"log/slog"
 	"sync/atomic"
 	"time"
 
@@ -23,16 +25,38 @@ type kve[T any] struct {
 	value atomic.Pointer[async.Result[T]]
 	// delegate is the underlying nats.KeyValueEntry returned from the nats library.
 	delegate nats.KeyValueEntry
+	// key is the decoded key of the delegate.
+	key string
+	// telemetry records how long it takes to decode the value.
+	telemetry *telemetry
+	// logger receives decode failures.
+	logger *slog.Logger
 }
 
 func (e *kve[T]) Bucket() string             { return e.delegate.Bucket() }
-func (e *kve[T]) Key() string                { return e.delegate.Key() }
+func (e *kve[T]) Key() string                { return e.key }
 func (e *kve[T]) Value() []byte              { return e.delegate.Value() }
 func (e *kve[T]) Revision() uint64           { return e.delegate.Revision() }
 func (e *kve[T]) Created() time.Time         { return e.delegate.Created() }
 func (e *kve[T]) Delta() uint64              { return e.delegate.Delta() }
 func (e *kve[T]) Operation() nats.KeyValueOp { return e.delegate.Operation() }
 
+func newKeyValueEntry[T any](
+	delegate nats.KeyValueEntry,
+	encoder nats.Encoder,
+	keys TokenEncoding,
+	telemetry *telemetry,
+	logger *slog.Logger,
+) *kve[T] {
+	key, err := DecodeKey(keys, delegate.Key())
+	if err != nil {
+		// the key was not written with the encoding, report it as it is
+		logger.Warn("failed to decode key", append(entryAttrs(delegate), slog.Any("error", err))...)
+		key = delegate.Key()
+	}
+	return &kve[T]{delegate: delegate, key: key, encoder: encoder, telemetry: telemetry, logger: logger}
+}
+
 func (e *kve[T]) UnmarshalValue() (T, error) {
 	// check if we have already unmarshalled the value
 	v := e.value.Load()
@@ -42,7 +66,19 @@ func (e *kve[T]) UnmarshalValue() (T, error) {
 	}
 
 	var value T
-	err := e.encoder.Decode("", e.delegate.Value(), &value)
+	bytes := e.delegate.Value()
+	start := time.Now()
+	err := e.encoder.Decode("", bytes, &value)
+	e.telemetry.recordDecode(e.delegate.Bucket(), time.Since(start), len(bytes), err)
+	if err != nil {
+		// delete and purge markers carry no value so failing to decode them is expected
+		level := slog.LevelWarn
+		if e.delegate.Operation() != nats.KeyValuePut {
+			level = slog.LevelDebug
+		}
+		e.logger.Log(context.Background(), level, "failed to decode value",
+			append(entryAttrs(e.delegate), slog.Any("error", err))...)
+	}
 	result := async.NewResult[T](value, err)
 
 	// cache the result and return
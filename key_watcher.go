@@ -2,6 +2,7 @@ package natsutil
 
 import (
 	"context"
+	"log/slog"
 
 	"github.com/nats-io/nats.go"
 )
@@ -16,8 +17,14 @@ type KeyWatcher[T any] interface {
 type kw[T any] struct {
 	// encoder defines how to decode update values into type T.
 	encoder nats.Encoder
+	// keys decodes the keys of updates, if nil keys are not encoded.
+	keys TokenEncoding
 	// delegate is the underlying nats.KeyWatcher returned from the nats library.
 	delegate nats.KeyWatcher
+	// telemetry records delivery lag and buffer occupancy for updates.
+	telemetry *telemetry
+	// logger receives lifecycle events of the routine which decodes updates.
+	logger *slog.Logger
 }
 
 func (k *kw[T]) Context() context.Context {
@@ -25,6 +32,7 @@ func (k *kw[T]) Context() context.Context {
 }
 
 func (k *kw[T]) Stop() error {
+	k.logger.Debug("watcher stopping")
 	return k.delegate.Stop()
 }
 
@@ -42,19 +50,35 @@ func (k *kw[T]) UpdatesUnmarshalled() <-chan KeyValueEntry[T] {
 	go func() {
 		// close channel upon completion
 		defer close(ch)
+		defer k.logger.Debug("watcher updates closed")
 		for delegate := range updates {
 			var entry KeyValueEntry[T]
 			// TODO why do we seem to get an initial nil entry when a key doesn't exist yet?
 			if delegate != nil {
-				entry = &kve[T]{delegate: delegate, encoder: k.encoder}
+				entry = newKeyValueEntry[T](delegate, k.encoder, k.keys, k.telemetry, k.logger)
 			}
 			ch <- entry
+			if delegate != nil {
+				k.telemetry.recordDelivery(delegate, len(ch))
+			}
 		}
 	}()
 
 	return ch
 }
 
-func NewKeyWatcher[T any](watcher nats.KeyWatcher, encoder nats.Encoder) KeyWatcher[T] {
-	return &kw[T]{delegate: watcher, encoder: encoder}
+// NewKeyWatcher creates a generic KeyWatcher which uses the provided encoder for unmarshalling updates.
+func NewKeyWatcher[T any](watcher nats.KeyWatcher, encoder nats.Encoder, opts ...Option) KeyWatcher[T] {
+	options := newOptions(opts)
+	return newKeyWatcher[T](watcher, encoder, options.keyEncoding, newTelemetry(options), newLogger(options))
+}
+
+func newKeyWatcher[T any](
+	watcher nats.KeyWatcher,
+	encoder nats.Encoder,
+	keys TokenEncoding,
+	telemetry *telemetry,
+	logger *slog.Logger,
+) KeyWatcher[T] {
+	return &kw[T]{delegate: watcher, encoder: encoder, keys: keys, telemetry: telemetry, logger: logger}
 }
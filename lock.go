@@ -0,0 +1,396 @@
+package natsutil
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+const (
+	ErrLockHeld            = errors.ConstError("lock is held by another owner")
+	ErrLockNotHeld         = errors.ConstError("lock is not held")
+	ErrLockAlreadyAcquired = errors.ConstError("lock has already been acquired")
+	ErrLockTTLInvalid      = errors.ConstError("lock renew interval must be positive and less than the TTL")
+)
+
+const (
+	// DefaultLockTTL is how long a lease remains valid without being renewed if no TTL has been configured.
+	DefaultLockTTL = 30 * time.Second
+	// maxAcquireAttempts bounds how many times a single acquisition attempt will retry when the key is being
+	// concurrently deleted and recreated by other owners.
+	maxAcquireAttempts = 3
+)
+
+// LockLease is the value stored under the key of a held Lock.
+type LockLease struct {
+	// Owner identifies who holds the lock.
+	Owner string `json:"owner"`
+	// TTL is how long the lease remains valid after it was last written.
+	TTL time.Duration `json:"ttl"`
+}
+
+// LockOption configures a Lock.
+type LockOption func(l *Lock)
+
+// WithLockOwner sets the identity recorded in the lease, defaults to a unique id.
+func WithLockOwner(owner string) LockOption {
+	return func(l *Lock) {
+		l.owner = owner
+	}
+}
+
+// WithLockTTL sets how long a lease remains valid without being renewed, defaults to DefaultLockTTL.
+func WithLockTTL(ttl time.Duration) LockOption {
+	return func(l *Lock) {
+		l.ttl = ttl
+	}
+}
+
+// WithLockRenewInterval sets how often the lease is renewed whilst held, defaults to a third of the TTL.
+func WithLockRenewInterval(interval time.Duration) LockOption {
+	return func(l *Lock) {
+		l.renewInterval = interval
+	}
+}
+
+// WithLockLogger sets the logger which receives acquisition, renewal and release events.
+func WithLockLogger(logger *slog.Logger) LockOption {
+	return func(l *Lock) {
+		l.logger = logger
+	}
+}
+
+// Lock is a distributed mutex stored under a single key.
+//
+// A lease is written with Create when the lock is free and renewed in the background with Update against the
+// last known revision. Leases expire TTL after they were last written, measured using the timestamp assigned by
+// the server, at which point they can be stolen by another owner. Each acquisition returns a fencing token, the
+// revision of the write which acquired the lock, which increases with every acquisition and can be passed to
+// downstream systems so they can reject writes from a previous holder.
+//
+// A Lock represents a single owner and is not intended for mutual exclusion between goroutines of the same process.
+type Lock struct {
+	kv            KeyValue[LockLease]
+	key           string
+	owner         string
+	ttl           time.Duration
+	renewInterval time.Duration
+	logger        *slog.Logger
+
+	mu   sync.Mutex
+	held *heldLock
+}
+
+// heldLock tracks a single acquisition of the lock.
+type heldLock struct {
+	token    uint64
+	revision atomic.Uint64
+	// done is closed when the lock is released or lost.
+	done     chan struct{}
+	doneOnce sync.Once
+	// stop signals the renewal routine to exit, stopped is closed once it has.
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func (h *heldLock) end() {
+	h.doneOnce.Do(func() { close(h.done) })
+}
+
+// NewLock creates a Lock which stores its lease under key within the provided bucket. Returns ErrLockTTLInvalid unless
+// the TTL is positive and the renew interval is positive and less than the TTL, so that leases are renewed before
+// they expire.
+func NewLock(kv KeyValue[LockLease], key string, opts ...LockOption) (*Lock, error) {
+	l := &Lock{kv: kv, key: key, owner: nuid.Next(), ttl: DefaultLockTTL}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.renewInterval == 0 {
+		l.renewInterval = l.ttl / 3
+	}
+	if l.ttl <= 0 || l.renewInterval <= 0 || l.renewInterval >= l.ttl {
+		return nil, errors.Annotatef(ErrLockTTLInvalid, "ttl %v, renew interval %v", l.ttl, l.renewInterval)
+	}
+	if l.logger == nil {
+		l.logger = DiscardLogger()
+	}
+	l.logger = l.logger.With(
+		slog.String(LogKeyBucket, kv.Bucket()),
+		slog.String(LogKeyKey, key),
+		slog.String(LogKeyOwner, l.owner),
+	)
+	return l, nil
+}
+
+// Owner returns the identity recorded in leases written by this lock.
+func (l *Lock) Owner() string {
+	return l.owner
+}
+
+// Key returns the key under which the lease is stored.
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// TryAcquire makes a single attempt to acquire the lock, returning the fencing token for this acquisition.
+// ErrLockHeld is returned if another owner holds an unexpired lease.
+func (l *Lock) TryAcquire() (token uint64, err error) {
+	token, _, err = l.tryAcquire()
+	return token, err
+}
+
+// Acquire blocks until the lock has been acquired or the context is done, returning the fencing token for this
+// acquisition. Rather than polling, the key is watched so that a release is noticed immediately and a retry is
+// scheduled for when the current lease expires.
+func (l *Lock) Acquire(ctx context.Context) (token uint64, err error) {
+	var updates <-chan nats.KeyValueEntry
+
+	for attempt := 1; ; attempt++ {
+		token, expires, err := l.tryAcquire()
+		if !errors.Is(err, ErrLockHeld) {
+			return token, err
+		}
+
+		if updates == nil {
+			// watch after the first failed attempt, any delete which happened in between will be in the initial values
+			watcher, err := l.kv.Watch(l.key, nats.MetaOnly())
+			if err != nil {
+				return 0, err
+			}
+			defer func() { _ = watcher.Stop() }()
+			updates = watcher.Updates()
+		}
+
+		l.logger.Debug("waiting for lock", slog.Int(LogKeyAttempt, attempt), slog.Time("expires", expires))
+		if err := waitForRelease(ctx, updates, expires); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// waitForRelease blocks until a lease is deleted, the provided expiry is reached or the context is done.
+func waitForRelease(ctx context.Context, updates <-chan nats.KeyValueEntry, expires time.Time) error {
+	timer := time.NewTimer(time.Until(expires))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		case entry, ok := <-updates:
+			if !ok {
+				return errors.New("lock watcher closed unexpectedly")
+			}
+			// renewals by the current holder are ignored, we only care about the lease being removed
+			if entry != nil && entry.Operation() != nats.KeyValuePut {
+				return nil
+			}
+		}
+	}
+}
+
+// tryAcquire makes a single attempt to acquire the lock. When the lock is held by another owner the time at which
+// their lease expires is returned alongside ErrLockHeld.
+func (l *Lock) tryAcquire() (token uint64, expires time.Time, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.held != nil {
+		return 0, time.Time{}, ErrLockAlreadyAcquired
+	}
+
+	lease := LockLease{Owner: l.owner, TTL: l.ttl}
+
+	for attempt := 0; attempt < maxAcquireAttempts; attempt++ {
+		issued := time.Now()
+		revision, err := l.kv.Create(l.key, lease)
+		if err == nil {
+			l.acquired(revision, issued)
+			return revision, time.Time{}, nil
+		}
+		if !IsWrongRevision(err) {
+			return 0, time.Time{}, err
+		}
+
+		entry, err := l.kv.Get(l.key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			// released between our create and get, try again
+			continue
+		} else if err != nil {
+			return 0, time.Time{}, err
+		}
+
+		holder, err := entry.UnmarshalValue()
+		if err != nil {
+			return 0, time.Time{}, errors.Annotatef(err, "failed to decode lease for lock %q", l.key)
+		}
+
+		expires = entry.Created().Add(holder.TTL)
+		if time.Now().Before(expires) {
+			return 0, expires, ErrLockHeld
+		}
+
+		// the lease has expired, attempt to steal it
+		issued = time.Now()
+		revision, err = l.kv.Update(l.key, lease, entry.Revision())
+		if IsWrongRevision(err) {
+			// someone else renewed or stole the lease first
+			return 0, time.Now(), ErrLockHeld
+		} else if err != nil {
+			return 0, time.Time{}, err
+		}
+
+		l.logger.Info("stole expired lock", slog.String("previousOwner", holder.Owner))
+		l.acquired(revision, issued)
+		return revision, time.Time{}, nil
+	}
+
+	return 0, time.Now(), ErrLockHeld
+}
+
+// acquired records the acquisition and starts renewing the lease, it must be called whilst holding mu. Issued is the
+// time at which the write of the lease was issued.
+func (l *Lock) acquired(revision uint64, issued time.Time) {
+	h := &heldLock{
+		token:   revision,
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	h.revision.Store(revision)
+	l.held = h
+
+	l.logger.Debug("lock acquired", slog.Uint64(LogKeyRevision, revision))
+
+	go l.renew(h, issued)
+}
+
+// renew periodically updates the lease until stopped or the lease is lost.
+//
+// The server timestamps each write after it has been issued, so other owners may steal the lease from TTL after the
+// last successful write was issued. The lock is considered lost at that point, which is tracked by a timer rather
+// than checked when renewing so that Done is closed on time even whilst a renewal is blocked.
+func (l *Lock) renew(h *heldLock, issued time.Time) {
+	defer close(h.stopped)
+
+	expiry := time.AfterFunc(time.Until(issued.Add(l.ttl)), func() {
+		l.logger.Warn("lock lost, lease expired before it could be renewed")
+		l.lost(h)
+	})
+	defer expiry.Stop()
+
+	ticker := time.NewTicker(l.renewInterval)
+	defer ticker.Stop()
+
+	lease := LockLease{Owner: l.owner, TTL: l.ttl}
+	attempt := 0
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-h.done:
+			return
+		case <-ticker.C:
+		}
+
+		issued := time.Now()
+		revision, err := l.kv.Update(l.key, lease, h.revision.Load())
+		if err == nil {
+			if !expiry.Stop() {
+				// the lease expired whilst being renewed, the lock has already been lost
+				return
+			}
+			h.revision.Store(revision)
+			expiry.Reset(time.Until(issued.Add(l.ttl)))
+			attempt = 0
+			continue
+		}
+
+		if IsWrongRevision(err) {
+			l.logger.Warn("lock lost, lease was modified by another owner", slog.Any("error", err))
+			l.lost(h)
+			return
+		}
+
+		attempt++
+		l.logger.Warn("failed to renew lock lease, retrying",
+			slog.Int(LogKeyAttempt, attempt), slog.Any("error", err))
+	}
+}
+
+// lost marks the acquisition as having ended without being released.
+func (l *Lock) lost(h *heldLock) {
+	l.mu.Lock()
+	if l.held == h {
+		l.held = nil
+	}
+	l.mu.Unlock()
+	h.end()
+}
+
+// Release stops renewing the lease and deletes it, provided it has not been modified by another owner.
+// ErrLockNotHeld is returned if the lock was not acquired or has since been lost.
+func (l *Lock) Release() error {
+	l.mu.Lock()
+	h := l.held
+	l.held = nil
+	l.mu.Unlock()
+
+	if h == nil {
+		return ErrLockNotHeld
+	}
+
+	// stop renewals before deleting so we know the final revision
+	close(h.stop)
+	<-h.stopped
+	defer h.end()
+
+	select {
+	case <-h.done:
+		return ErrLockNotHeld
+	default:
+	}
+
+	revision := h.revision.Load()
+	if err := l.kv.Delete(l.key, nats.LastRevision(revision)); err != nil {
+		if IsWrongRevision(err) {
+			return ErrLockNotHeld
+		}
+		return err
+	}
+
+	l.logger.Debug("lock released", slog.Uint64(LogKeyRevision, revision))
+	return nil
+}
+
+// Token returns the fencing token of the current acquisition and whether the lock is currently held.
+func (l *Lock) Token() (uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		return 0, false
+	}
+	return l.held.token, true
+}
+
+// Done returns a channel which is closed when the current acquisition is released or lost.
+// If the lock is not held the returned channel is already closed.
+func (l *Lock) Done() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	return l.held.done
+}
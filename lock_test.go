@@ -0,0 +1,317 @@
+package natsutil_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newLock(
+	t *testing.T,
+	kv natsutil.KeyValue[natsutil.LockLease],
+	key string,
+	opts ...natsutil.LockOption,
+) *natsutil.Lock {
+	l, err := natsutil.NewLock(kv, key, opts...)
+	assert.Nil(t, err)
+	return l
+}
+
+func TestNewLock_InvalidTTL(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](createTestBucket(t, js), &encoder)
+
+	for _, opts := range [][]natsutil.LockOption{
+		{natsutil.WithLockTTL(0)},
+		{natsutil.WithLockTTL(-time.Second)},
+		{natsutil.WithLockRenewInterval(-time.Second)},
+		{natsutil.WithLockTTL(time.Second), natsutil.WithLockRenewInterval(time.Second)},
+		{natsutil.WithLockTTL(time.Second), natsutil.WithLockRenewInterval(2 * time.Second)},
+	} {
+		_, err := natsutil.NewLock(kv, "lock", opts...)
+		assert.ErrorIs(t, err, natsutil.ErrLockTTLInvalid)
+	}
+
+	_, err := natsutil.NewLock(kv, "lock", natsutil.WithLockTTL(time.Second))
+	assert.Nil(t, err)
+}
+
+func TestLock_TryAcquireRelease(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](createTestBucket(t, js), &encoder)
+
+	a := newLock(t, kv, "lock", natsutil.WithLockOwner("a"))
+	b := newLock(t, kv, "lock", natsutil.WithLockOwner("b"))
+
+	assert.Equal(t, "a", a.Owner())
+	assert.Equal(t, "lock", a.Key())
+
+	// not held yet
+	_, held := a.Token()
+	assert.False(t, held)
+	assert.ErrorIs(t, a.Release(), natsutil.ErrLockNotHeld)
+
+	token, err := a.TryAcquire()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), token)
+
+	current, held := a.Token()
+	assert.True(t, held)
+	assert.Equal(t, token, current)
+
+	_, err = a.TryAcquire()
+	assert.ErrorIs(t, err, natsutil.ErrLockAlreadyAcquired)
+
+	_, err = b.TryAcquire()
+	assert.ErrorIs(t, err, natsutil.ErrLockHeld)
+
+	// the lease records the owner
+	entry, err := kv.Get("lock")
+	assert.Nil(t, err)
+	lease, err := entry.UnmarshalValue()
+	assert.Nil(t, err)
+	assert.Equal(t, "a", lease.Owner)
+	assert.Equal(t, natsutil.DefaultLockTTL, lease.TTL)
+
+	done := a.Done()
+	assert.Nil(t, a.Release())
+	<-done
+
+	// fencing tokens increase with each acquisition
+	next, err := b.TryAcquire()
+	assert.Nil(t, err)
+	assert.Greater(t, next, token)
+	assert.Nil(t, b.Release())
+}
+
+func TestLock_AcquireWaitsForRelease(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](createTestBucket(t, js), &encoder)
+
+	a := newLock(t, kv, "lock")
+	b := newLock(t, kv, "lock")
+
+	_, err := a.TryAcquire()
+	assert.Nil(t, err)
+
+	acquired := make(chan uint64)
+	go func() {
+		token, err := b.Acquire(context.Background())
+		assert.Nil(t, err)
+		acquired <- token
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired whilst held")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	assert.Nil(t, a.Release())
+
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock not acquired after release")
+	}
+	assert.Nil(t, b.Release())
+
+	// acquire respects the context
+	_, err = a.TryAcquire()
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = b.Acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, a.Release())
+}
+
+func TestLock_StealExpiredLease(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](createTestBucket(t, js), &encoder)
+
+	// simulate a holder which crashed without releasing
+	crashed, err := kv.Create("lock", natsutil.LockLease{Owner: "crashed", TTL: 200 * time.Millisecond})
+	assert.Nil(t, err)
+
+	l := newLock(t, kv, "lock", natsutil.WithLockOwner("survivor"))
+
+	_, err = l.TryAcquire()
+	assert.ErrorIs(t, err, natsutil.ErrLockHeld)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := l.Acquire(ctx)
+	assert.Nil(t, err)
+	assert.Greater(t, token, crashed)
+
+	entry, err := kv.Get("lock")
+	assert.Nil(t, err)
+	lease, err := entry.UnmarshalValue()
+	assert.Nil(t, err)
+	assert.Equal(t, "survivor", lease.Owner)
+
+	assert.Nil(t, l.Release())
+}
+
+func TestLock_RenewalAndLoss(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](createTestBucket(t, js), &encoder)
+
+	l := newLock(t, kv, "lock",
+		natsutil.WithLockTTL(300*time.Millisecond),
+		natsutil.WithLockRenewInterval(50*time.Millisecond),
+	)
+
+	token, err := l.TryAcquire()
+	assert.Nil(t, err)
+
+	// the lease is renewed well beyond its ttl
+	time.Sleep(600 * time.Millisecond)
+	other := newLock(t, kv, "lock")
+	_, err = other.TryAcquire()
+	assert.ErrorIs(t, err, natsutil.ErrLockHeld)
+
+	entry, err := kv.Get("lock")
+	assert.Nil(t, err)
+	assert.Greater(t, entry.Revision(), token)
+
+	// the token remains stable across renewals
+	current, held := l.Token()
+	assert.True(t, held)
+	assert.Equal(t, token, current)
+
+	// overwrite the lease, the next renewal should notice
+	_, err = kv.Put("lock", natsutil.LockLease{Owner: "intruder", TTL: time.Minute})
+	assert.Nil(t, err)
+
+	select {
+	case <-l.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock loss not detected")
+	}
+
+	_, held = l.Token()
+	assert.False(t, held)
+	assert.ErrorIs(t, l.Release(), natsutil.ErrLockNotHeld)
+
+	// the intruder's lease was left intact
+	entry, err = kv.Get("lock")
+	assert.Nil(t, err)
+	lease, err := entry.UnmarshalValue()
+	assert.Nil(t, err)
+	assert.Equal(t, "intruder", lease.Owner)
+}
+
+func TestLock_LostAtExpiry(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	bucket := createTestBucket(t, js)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](bucket, &encoder)
+
+	l := newLock(t, kv, "lock",
+		natsutil.WithLockTTL(300*time.Millisecond),
+		natsutil.WithLockRenewInterval(250*time.Millisecond),
+	)
+
+	start := time.Now()
+	_, err := l.TryAcquire()
+	assert.Nil(t, err)
+
+	// renewals fail from now on, the lock is lost once the ttl has passed rather than on the following renewal
+	assert.Nil(t, js.DeleteKeyValue(bucket.Bucket()))
+
+	select {
+	case <-l.Done():
+		assert.Less(t, time.Since(start), 450*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock expiry not detected")
+	}
+
+	_, held := l.Token()
+	assert.False(t, held)
+	assert.ErrorIs(t, l.Release(), natsutil.ErrLockNotHeld)
+}
+
+func TestLock_Contention(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	createTestBucket(t, js)
+
+	const clients = 5
+	const iterations = 10
+
+	var inside atomic.Int32
+	var total atomic.Int32
+	var lastToken atomic.Uint64
+	var wg sync.WaitGroup
+
+	for i := 0; i < clients; i++ {
+		// each client uses its own connection
+		_, js := jsClient(t, s)
+		bucket, err := js.KeyValue("TestBucket")
+		assert.Nil(t, err)
+
+		kv := natsutil.NewKeyValue[natsutil.LockLease](bucket, &encoder)
+		l := newLock(t, kv, "lock")
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				token, err := l.Acquire(ctx)
+				cancel()
+				if !assert.Nil(t, err) {
+					return
+				}
+
+				assert.Equal(t, int32(1), inside.Add(1), "more than one holder")
+				assert.Greater(t, token, lastToken.Swap(token), "fencing token did not increase")
+				total.Add(1)
+				time.Sleep(time.Millisecond)
+				inside.Add(-1)
+
+				assert.Nil(t, l.Release())
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, int32(clients*iterations), total.Load())
+
+	// the key is left deleted
+	bucket, err := js.KeyValue("TestBucket")
+	assert.Nil(t, err)
+	_, err = bucket.Get("lock")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+}
@@ -0,0 +1,49 @@
+package natsutil
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Attribute keys used when logging.
+const (
+	LogKeyBucket      = "bucket"
+	LogKeyKey         = "key"
+	LogKeyRevision    = "revision"
+	LogKeyOperation   = "operation"
+	LogKeyAttempt     = "attempt"
+	LogKeyOwner       = "owner"
+	LogKeyIndexBucket = "indexBucket"
+)
+
+// discardHandler drops all log records, it is used when no logger has been configured.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// DiscardLogger returns a logger which drops all records, it is used when no logger has been configured.
+func DiscardLogger() *slog.Logger {
+	return slog.New(discardHandler{})
+}
+
+// newLogger returns the configured logger, or one which discards everything if no logger was provided.
+func newLogger(opts *options) *slog.Logger {
+	if opts.logger == nil {
+		return DiscardLogger()
+	}
+	return opts.logger
+}
+
+// entryAttrs returns the attributes which identify an entry within its bucket. The bucket is omitted since loggers
+// already carry it.
+func entryAttrs(entry nats.KeyValueEntry) []any {
+	return []any{
+		slog.String(LogKeyKey, entry.Key()),
+		slog.Uint64(LogKeyRevision, entry.Revision()),
+	}
+}
@@ -0,0 +1,111 @@
+package natsutil_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer guards a buffer as the watcher routine logs concurrently with the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var records []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(b.buf.Bytes()), []byte("\n")) {
+		record := make(map[string]any)
+		assert.Nil(t, json.Unmarshal(line, &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func findRecord(records []map[string]any, msg string) map[string]any {
+	for _, record := range records {
+		if record[slog.MessageKey] == msg {
+			return record
+		}
+	}
+	return nil
+}
+
+func TestKv_Logging(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+
+	buf := &syncBuffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	kv := natsutil.NewKeyValue[testPayload](createTestBucket(t, js), &encoder, natsutil.WithLogger(logger))
+
+	w, err := kv.Watch("foo")
+	assert.Nil(t, err)
+	ch := w.UpdatesUnmarshalled()
+
+	// write a value which cannot be decoded
+	_, err = kv.Delegate().Put("foo", []byte("not json"))
+	assert.Nil(t, err)
+
+	entry, err := kv.Get("foo")
+	assert.Nil(t, err)
+	_, err = entry.UnmarshalValue()
+	assert.NotNil(t, err)
+
+	// fail an operation
+	_, err = kv.Create("foo", testPayload{1})
+	assert.NotNil(t, err)
+
+	// stop the watcher and drain the channel so the routine has finished
+	assert.Nil(t, w.Stop())
+	for range ch {
+	}
+
+	records := buf.records(t)
+
+	// each attribute appears once, decoding as a map would hide a duplicate
+	buf.mu.Lock()
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.buf.Bytes()), []byte("\n")) {
+		assert.Equal(t, 1, bytes.Count(line, []byte(`"`+natsutil.LogKeyBucket+`":`)), string(line))
+	}
+	buf.mu.Unlock()
+
+	started := findRecord(records, "watcher started")
+	assert.NotNil(t, started)
+	assert.Equal(t, "DEBUG", started[slog.LevelKey])
+	assert.Equal(t, "TestBucket", started[natsutil.LogKeyBucket])
+	assert.Equal(t, "foo", started[natsutil.LogKeyKey])
+
+	decode := findRecord(records, "failed to decode value")
+	assert.NotNil(t, decode)
+	assert.Equal(t, "WARN", decode[slog.LevelKey])
+	assert.Equal(t, "TestBucket", decode[natsutil.LogKeyBucket])
+	assert.Equal(t, "foo", decode[natsutil.LogKeyKey])
+	assert.Equal(t, float64(1), decode[natsutil.LogKeyRevision])
+
+	failed := findRecord(records, "operation failed")
+	assert.NotNil(t, failed)
+	assert.Equal(t, "Create", failed[natsutil.LogKeyOperation])
+
+	assert.NotNil(t, findRecord(records, "watcher stopping"))
+	assert.NotNil(t, findRecord(records, "watcher updates closed"))
+}
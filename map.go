@@ -0,0 +1,218 @@
+package natsutil
+
+import (
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+// Map is a map stored in a bucket whose operations are atomic with respect to other writers.
+type Map[K comparable, V any] interface {
+	// Get returns the value for the key and whether it is present.
+	Get(key K) (V, bool, error)
+	// Put sets the value for the key.
+	Put(key K, value V) error
+	// Update atomically replaces the value for the key with the result of fn, which is passed the current value and
+	// whether it is present. fn may be called more than once if the map is concurrently modified.
+	Update(key K, fn func(value V, exists bool) (V, error)) (V, error)
+	// Remove removes the key, reporting whether it was present.
+	Remove(key K) (bool, error)
+	// Range calls fn with each key and value of a consistent snapshot of the map until fn returns false.
+	Range(fn func(key K, value V) bool) error
+	// Len returns the number of keys in the map.
+	Len() (int, error)
+}
+
+// MapEntry is the value stored for each key of a keyed Map, the key is included so that it can be recovered when
+// iterating.
+type MapEntry[K comparable, V any] struct {
+	Key   K `json:"key"`
+	Value V `json:"value"`
+}
+
+// valueMap stores the whole map in a single value, which is modified with compare-and-swap updates.
+type valueMap[K comparable, V any] struct {
+	kv     KeyValue[map[K]V]
+	key    string
+	config collectionConfig
+}
+
+// NewMap creates a Map stored as a single value under key. Every modification rewrites the whole map, so this suits
+// small maps. The key type must be supported by the encoder, the JSON encoder for example requires string, integer
+// or encoding.TextMarshaler keys.
+func NewMap[K comparable, V any](kv KeyValue[map[K]V], key string, opts ...CollectionOption) Map[K, V] {
+	return &valueMap[K, V]{kv: kv, key: key, config: newCollectionConfig(kv.Bucket(), key, opts)}
+}
+
+func (m *valueMap[K, V]) values() (map[K]V, error) {
+	entry, err := m.kv.Get(m.key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	values, err := entry.UnmarshalValue()
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to decode value for key %q", m.key)
+	}
+	return values, nil
+}
+
+func (m *valueMap[K, V]) Get(key K) (V, bool, error) {
+	values, err := m.values()
+	value, ok := values[key]
+	return value, ok, err
+}
+
+func (m *valueMap[K, V]) Put(key K, value V) error {
+	_, err := m.Update(key, func(V, bool) (V, error) { return value, nil })
+	return err
+}
+
+func (m *valueMap[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) (result V, err error) {
+	_, _, err = casUpdate[map[K]V](m.kv, m.key, m.config.maxRetries, m.config.logger,
+		func(values map[K]V, _ bool) (map[K]V, error) {
+			current, ok := values[key]
+			if result, err = fn(current, ok); err != nil {
+				return nil, err
+			}
+
+			// copy so that a retry is passed the map as it was read
+			updated := make(map[K]V, len(values)+1)
+			for k, v := range values {
+				updated[k] = v
+			}
+			updated[key] = result
+			return updated, nil
+		})
+	return result, err
+}
+
+func (m *valueMap[K, V]) Remove(key K) (bool, error) {
+	_, _, err := casUpdate[map[K]V](m.kv, m.key, m.config.maxRetries, m.config.logger,
+		func(values map[K]V, _ bool) (map[K]V, error) {
+			if _, ok := values[key]; !ok {
+				return nil, errUnchanged
+			}
+
+			// copy so that a retry is passed the map as it was read
+			updated := make(map[K]V, len(values))
+			for k, v := range values {
+				updated[k] = v
+			}
+			delete(updated, key)
+			return updated, nil
+		})
+	if errors.Is(err, errUnchanged) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *valueMap[K, V]) Range(fn func(key K, value V) bool) error {
+	values, err := m.values()
+	if err != nil {
+		return err
+	}
+	for k, v := range values {
+		if !fn(k, v) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *valueMap[K, V]) Len() (int, error) {
+	values, err := m.values()
+	return len(values), err
+}
+
+// keyedMap stores each key under its own key in the bucket.
+type keyedMap[K comparable, V any] struct {
+	kv     KeyValue[MapEntry[K, V]]
+	prefix string
+	token  func(key K) string
+	config collectionConfig
+}
+
+// NewKeyedMap creates a Map which stores each key under '<prefix>.<token>', where token maps a key to a single, valid
+// subject token. Keys are modified independently, so large maps and many concurrent writers are supported, and Range
+// uses the bucket revision to provide a consistent snapshot.
+func NewKeyedMap[K comparable, V any](
+	kv KeyValue[MapEntry[K, V]],
+	prefix string,
+	token func(key K) string,
+	opts ...CollectionOption,
+) Map[K, V] {
+	return &keyedMap[K, V]{kv: kv, prefix: prefix, token: token, config: newCollectionConfig(kv.Bucket(), prefix, opts)}
+}
+
+func (m *keyedMap[K, V]) Get(key K) (value V, ok bool, err error) {
+	storageKey, err := elementKey(m.prefix, m.token(key))
+	if err != nil {
+		return value, false, err
+	}
+
+	entry, err := m.kv.Get(storageKey)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return value, false, nil
+	} else if err != nil {
+		return value, false, err
+	}
+
+	mapEntry, err := entry.UnmarshalValue()
+	if err != nil {
+		return value, false, errors.Annotatef(err, "failed to decode value for key %q", storageKey)
+	}
+	return mapEntry.Value, true, nil
+}
+
+func (m *keyedMap[K, V]) Put(key K, value V) error {
+	storageKey, err := elementKey(m.prefix, m.token(key))
+	if err != nil {
+		return err
+	}
+	_, err = m.kv.Put(storageKey, MapEntry[K, V]{Key: key, Value: value})
+	return err
+}
+
+func (m *keyedMap[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) (V, error) {
+	storageKey, err := elementKey(m.prefix, m.token(key))
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+
+	entry, _, err := casUpdate[MapEntry[K, V]](m.kv, storageKey, m.config.maxRetries, m.config.logger,
+		func(current MapEntry[K, V], exists bool) (MapEntry[K, V], error) {
+			value, err := fn(current.Value, exists)
+			return MapEntry[K, V]{Key: key, Value: value}, err
+		})
+	return entry.Value, err
+}
+
+func (m *keyedMap[K, V]) Remove(key K) (bool, error) {
+	storageKey, err := elementKey(m.prefix, m.token(key))
+	if err != nil {
+		return false, err
+	}
+	return removeKey(m.kv, storageKey, m.config)
+}
+
+func (m *keyedMap[K, V]) Range(fn func(key K, value V) bool) error {
+	return rangeSnapshot[MapEntry[K, V]](m.kv, m.prefix, func(entry KeyValueEntry[MapEntry[K, V]]) (bool, error) {
+		mapEntry, err := entry.UnmarshalValue()
+		if err != nil {
+			return false, errors.Annotatef(err, "failed to decode value for key %q", entry.Key())
+		}
+		return fn(mapEntry.Key, mapEntry.Value), nil
+	})
+}
+
+func (m *keyedMap[K, V]) Len() (int, error) {
+	count := 0
+	err := rangeSnapshot[MapEntry[K, V]](m.kv, m.prefix, func(KeyValueEntry[MapEntry[K, V]]) (bool, error) {
+		count++
+		return true, nil
+	})
+	return count, err
+}
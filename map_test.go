@@ -0,0 +1,119 @@
+package natsutil_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testMap(t *testing.T, m natsutil.Map[string, int]) {
+	_, ok, err := m.Get("a")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, m.Put("a", 1))
+	assert.Nil(t, m.Put("b", 2))
+
+	value, ok, err := m.Get("a")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	// concurrent updates to the same key are not lost
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Update("a", func(value int, exists bool) (int, error) {
+				assert.True(t, exists)
+				return value + 1, nil
+			})
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	value, _, err = m.Get("a")
+	assert.Nil(t, err)
+	assert.Equal(t, 11, value)
+
+	value, err = m.Update("c", func(value int, exists bool) (int, error) {
+		assert.False(t, exists)
+		return 5, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, value)
+
+	// an error aborts the update
+	failure := errors.New("failure")
+	_, err = m.Update("c", func(int, bool) (int, error) { return 0, failure })
+	assert.ErrorIs(t, err, failure)
+
+	values := make(map[string]int)
+	assert.Nil(t, m.Range(func(key string, value int) bool {
+		values[key] = value
+		return true
+	}))
+	assert.Equal(t, map[string]int{"a": 11, "b": 2, "c": 5}, values)
+
+	length, err := m.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, length)
+
+	removed, err := m.Remove("b")
+	assert.Nil(t, err)
+	assert.True(t, removed)
+
+	removed, err = m.Remove("b")
+	assert.Nil(t, err)
+	assert.False(t, removed)
+
+	length, err = m.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, length)
+}
+
+func TestMap(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[map[string]int](createTestBucket(t, js), &encoder)
+
+	testMap(t, natsutil.NewMap[string, int](kv, "maps.counts"))
+}
+
+func TestKeyedMap(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.MapEntry[string, int]](createTestBucket(t, js), &encoder)
+
+	m := natsutil.NewKeyedMap[string, int](kv, "maps.counts", identity)
+	testMap(t, m)
+
+	entry, err := kv.Get("maps.counts.a")
+	assert.Nil(t, err)
+	mapEntry, err := entry.UnmarshalValue()
+	assert.Nil(t, err)
+	assert.Equal(t, natsutil.MapEntry[string, int]{Key: "a", Value: 11}, mapEntry)
+
+	// values are resolved to the revision the snapshot was taken at
+	values := make(map[string]int)
+	assert.Nil(t, m.Range(func(key string, value int) bool {
+		if len(values) == 0 {
+			assert.Nil(t, m.Put("a", 100))
+			assert.Nil(t, m.Put("c", 100))
+			assert.Nil(t, m.Put("d", 100))
+		}
+		values[key] = value
+		return true
+	}))
+	assert.Equal(t, map[string]int{"a": 11, "c": 5}, values)
+}
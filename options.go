@@ -0,0 +1,63 @@
+package natsutil
+
+import (
+	"log/slog"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures optional behaviour of the wrappers returned by NewKeyValue and NewKeyWatcher.
+type Option func(opts *options)
+
+type options struct {
+	// tracerProvider is used to create spans for key value operations.
+	tracerProvider trace.TracerProvider
+	// meterProvider is used to create the instruments for recording key value metrics.
+	meterProvider metric.MeterProvider
+	// logger receives lifecycle events, decode failures and retries.
+	logger *slog.Logger
+	// keyEncoding is applied to each token of a key before it is passed to the delegate.
+	keyEncoding TokenEncoding
+}
+
+// WithTracerProvider enables tracing of key value operations using the provided trace.TracerProvider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(opts *options) {
+		opts.tracerProvider = provider
+	}
+}
+
+// WithMeterProvider enables recording of key value metrics using the provided metric.MeterProvider.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(opts *options) {
+		opts.meterProvider = provider
+	}
+}
+
+// WithLogger enables structured logging of lifecycle events, decode failures and retries.
+func WithLogger(logger *slog.Logger) Option {
+	return func(opts *options) {
+		opts.logger = logger
+	}
+}
+
+// WithKeyEncoding encodes each '.' separated token of a key with the encoding before it reaches the bucket, allowing
+// keys to contain any characters. Entries and watch updates report the decoded keys, and wildcards in watch patterns
+// are left as they are. A '.' in a key always separates tokens, see EncodeKey.
+//
+// The encoding is not applied to the underlying nats.KeyValue returned by Delegate, or to the entries of the
+// Updates channel of a KeyWatcher.
+func WithKeyEncoding(encoding TokenEncoding) Option {
+	return func(opts *options) {
+		opts.keyEncoding = encoding
+	}
+}
+
+func newOptions(opts []Option) *options {
+	result := &options{}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
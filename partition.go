@@ -0,0 +1,328 @@
+package natsutil
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	ErrPartitionCount       = errors.ConstError("partition count must be at least one")
+	ErrPartitionOutOfRange  = errors.ConstError("partition is out of range")
+	ErrMembershipWatchEnded = errors.ConstError("membership watch ended")
+)
+
+// DefaultRebalanceDelay is how long a PartitionAssigner waits for membership to settle before rebalancing if no
+// delay has been configured.
+const DefaultRebalanceDelay = 500 * time.Millisecond
+
+// Partitioner maps keys to a fixed number of partitions, each with its own subject '<prefix>.<partition>'.
+//
+// Keys are mapped with jump consistent hashing, so the partition of a key is stable across processes and changing the
+// number of partitions moves only the keys which have to move.
+type Partitioner struct {
+	prefix *SubjectBuilder
+	count  int
+}
+
+// NewPartitioner creates a Partitioner with count partitions under prefix. The prefix is copied, so later changes to
+// the builder do not affect the partitioner. Returns ErrPrefixInvalid if the prefix is empty or contains wildcards,
+// and ErrPartitionCount if count is less than one.
+func NewPartitioner(prefix *SubjectBuilder, count int) (*Partitioner, error) {
+	if prefix.Len() == 0 || prefix.IsWildcard() {
+		return nil, errors.Annotatef(ErrPrefixInvalid, "%q", prefix.String())
+	}
+	if count < 1 {
+		return nil, errors.Annotatef(ErrPartitionCount, "%d", count)
+	}
+	return &Partitioner{prefix: prefix.Prefix(prefix.Len()), count: count}, nil
+}
+
+// Count returns the number of partitions.
+func (p *Partitioner) Count() int {
+	return p.count
+}
+
+// Partition returns the partition of a key, from 0 up to but not including Count.
+func (p *Partitioner) Partition(key string) int {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return jumpHash(h.Sum64(), p.count)
+}
+
+// Subject returns a builder holding the subject of a partition, to which further tokens can be pushed. Returns
+// ErrPartitionOutOfRange if the partition is negative or not less than Count.
+func (p *Partitioner) Subject(partition int) (*SubjectBuilder, error) {
+	if partition < 0 || partition >= p.count {
+		return nil, errors.Annotatef(ErrPartitionOutOfRange, "%d of %d", partition, p.count)
+	}
+	sb := p.prefix.Prefix(p.prefix.Len())
+	sb.MustPush(strconv.Itoa(partition))
+	return sb, nil
+}
+
+// KeySubject returns a builder holding the subject of the partition of a key.
+func (p *Partitioner) KeySubject(key string) *SubjectBuilder {
+	sb, _ := p.Subject(p.Partition(key))
+	return sb
+}
+
+// Filter returns a filter matching every subject below a partition, '<prefix>.<partition>.>'.
+func (p *Partitioner) Filter(partition int) (*SubjectBuilder, error) {
+	sb, err := p.Subject(partition)
+	if err != nil {
+		return nil, err
+	}
+	if err := sb.Chevron(); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// Assign divides the partitions between members, returning the sorted partitions of each member. Members with no
+// partitions are omitted, as happens when there are more members than partitions.
+//
+// The result depends only on the set of members, so every member computes the same assignment without coordinating.
+// Each partition prefers the members in the order of their rendezvous hash with the partition and is assigned to the
+// first whose share is not yet full, a share being Count divided by the number of members rounded up. This keeps
+// the assignment balanced whilst moving few partitions when members join or leave.
+func (p *Partitioner) Assign(members []string) map[string][]int {
+	members = slices.Clone(members)
+	sort.Strings(members)
+	members = slices.Compact(members)
+
+	assignment := make(map[string][]int)
+	if len(members) == 0 {
+		return assignment
+	}
+
+	hashes := make([]uint64, len(members))
+	for i, member := range members {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(member))
+		hashes[i] = h.Sum64()
+	}
+
+	share := (p.count + len(members) - 1) / len(members)
+	ranked := make([]int, len(members))
+	scores := make([]uint64, len(members))
+
+	for partition := 0; partition < p.count; partition++ {
+		for i := range members {
+			ranked[i] = i
+			scores[i] = mix64(hashes[i] ^ mix64(uint64(partition)))
+		}
+		// ties are broken by the member name, which the members are sorted by
+		sort.SliceStable(ranked, func(i, j int) bool { return scores[ranked[i]] > scores[ranked[j]] })
+
+		for _, i := range ranked {
+			if len(assignment[members[i]]) < share {
+				assignment[members[i]] = append(assignment[members[i]], partition)
+				break
+			}
+		}
+	}
+
+	return assignment
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach, mapping a key to one of buckets.
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// mix64 is the splitmix64 finalizer, spreading the bits of x.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// AssignerOption configures a PartitionAssigner.
+type AssignerOption func(c *assignerConfig)
+
+type assignerConfig struct {
+	delay      time.Duration
+	onAssigned func(partitions []int)
+	onRevoked  func(partitions []int)
+}
+
+// WithRebalanceDelay sets how long to wait after a membership change before rebalancing, so that several instances
+// starting or stopping together cause a single rebalance. Defaults to DefaultRebalanceDelay.
+func WithRebalanceDelay(delay time.Duration) AssignerOption {
+	return func(c *assignerConfig) {
+		c.delay = delay
+	}
+}
+
+// WithOnPartitionsAssigned registers a callback which is invoked with the partitions gained by a rebalance.
+func WithOnPartitionsAssigned(fn func(partitions []int)) AssignerOption {
+	return func(c *assignerConfig) {
+		c.onAssigned = fn
+	}
+}
+
+// WithOnPartitionsRevoked registers a callback which is invoked with the partitions lost by a rebalance, and with all
+// assigned partitions when the assigner stops.
+func WithOnPartitionsRevoked(fn func(partitions []int)) AssignerOption {
+	return func(c *assignerConfig) {
+		c.onRevoked = fn
+	}
+}
+
+// PartitionAssigner tracks which partitions are assigned to an instance of a service, based on the instances
+// which are alive according to a Registry.
+//
+// Whenever the membership of the service changes every instance recomputes Partitioner.Assign and invokes its
+// callbacks for the partitions it has lost and then those it has gained. Instances observe membership changes at
+// slightly different times, so a partition may briefly be assigned to two instances during a rebalance; work which
+// must never run concurrently should additionally be protected with a Lock.
+type PartitionAssigner[T any] struct {
+	partitioner *Partitioner
+	registry    *Registry[T]
+	service     string
+	id          string
+	config      assignerConfig
+	logger      *slog.Logger
+
+	mu       sync.Mutex
+	assigned []int
+}
+
+// NewPartitionAssigner creates a PartitionAssigner for the instance id of service, which should be registered with
+// the registry under the same id. Instances which are not registered are not assigned any partitions.
+func NewPartitionAssigner[T any](
+	partitioner *Partitioner,
+	registry *Registry[T],
+	service string,
+	id string,
+	opts ...AssignerOption,
+) *PartitionAssigner[T] {
+	config := assignerConfig{delay: DefaultRebalanceDelay}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return &PartitionAssigner[T]{
+		partitioner: partitioner,
+		registry:    registry,
+		service:     service,
+		id:          id,
+		config:      config,
+		logger:      registry.config.logger.With(slog.String("service", service), slog.String("instance", id)),
+	}
+}
+
+// Assigned returns the partitions currently assigned to the instance.
+func (a *PartitionAssigner[T]) Assigned() []int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.assigned)
+}
+
+// Run watches the membership of the service and rebalances until the context is done, at which point all assigned
+// partitions are revoked. Callbacks are invoked from the calling routine and should return promptly.
+func (a *PartitionAssigner[T]) Run(ctx context.Context) error {
+	events, err := a.registry.Watch(ctx, a.service)
+	if err != nil {
+		return err
+	}
+	defer a.rebalance(nil)
+
+	members := make(map[string]struct{})
+
+	timer := time.NewTimer(a.config.delay)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return ErrMembershipWatchEnded
+			}
+
+			switch event.Type {
+			case InstanceJoined:
+				members[event.Instance.ID] = struct{}{}
+			case InstanceLeft:
+				delete(members, event.Instance.ID)
+			default:
+				// descriptor changes do not affect the assignment
+				continue
+			}
+
+			// drain a tick which has not been received so that it cannot cut the new delay short
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(a.config.delay)
+
+		case <-timer.C:
+			ids := make([]string, 0, len(members))
+			for id := range members {
+				ids = append(ids, id)
+			}
+			a.rebalance(a.partitioner.Assign(ids)[a.id])
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// rebalance replaces the assigned partitions, invoking the callbacks with the differences.
+func (a *PartitionAssigner[T]) rebalance(partitions []int) {
+	a.mu.Lock()
+	previous := a.assigned
+	a.assigned = partitions
+	a.mu.Unlock()
+
+	revoked := difference(previous, partitions)
+	assigned := difference(partitions, previous)
+	if len(revoked) == 0 && len(assigned) == 0 {
+		return
+	}
+
+	a.logger.Info("partitions rebalanced",
+		slog.Any("assigned", assigned), slog.Any("revoked", revoked), slog.Int("total", len(partitions)))
+
+	if len(revoked) > 0 && a.config.onRevoked != nil {
+		a.config.onRevoked(revoked)
+	}
+	if len(assigned) > 0 && a.config.onAssigned != nil {
+		a.config.onAssigned(assigned)
+	}
+}
+
+// difference returns the elements of a which are not in b, both of which are sorted.
+func difference(a, b []int) []int {
+	var result []int
+	for _, x := range a {
+		if _, found := slices.BinarySearch(b, x); !found {
+			result = append(result, x)
+		}
+	}
+	return result
+}
@@ -0,0 +1,219 @@
+package natsutil_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newPartitioner(t *testing.T, count int) *natsutil.Partitioner {
+	prefix := natsutil.SubjectBuilder{}
+	prefix.MustPush("jobs")
+	p, err := natsutil.NewPartitioner(&prefix, count)
+	assert.Nil(t, err)
+	return p
+}
+
+func TestNewPartitioner_Invalid(t *testing.T) {
+	prefix := natsutil.SubjectBuilder{}
+	_, err := natsutil.NewPartitioner(&prefix, 4)
+	assert.ErrorIs(t, err, natsutil.ErrPrefixInvalid)
+
+	prefix.MustPush("jobs")
+	prefix.Star()
+	_, err = natsutil.NewPartitioner(&prefix, 4)
+	assert.ErrorIs(t, err, natsutil.ErrPrefixInvalid)
+
+	_, err = natsutil.NewPartitioner(prefix.Prefix(1), 0)
+	assert.ErrorIs(t, err, natsutil.ErrPartitionCount)
+}
+
+func TestPartitioner_Subjects(t *testing.T) {
+	p := newPartitioner(t, 8)
+	assert.Equal(t, 8, p.Count())
+
+	sb, err := p.Subject(3)
+	assert.Nil(t, err)
+	assert.Equal(t, "jobs.3", sb.String())
+
+	// the returned builders are independent of each other
+	sb.MustPush("created")
+	sb, err = p.Subject(3)
+	assert.Nil(t, err)
+	assert.Equal(t, "jobs.3", sb.String())
+
+	filter, err := p.Filter(7)
+	assert.Nil(t, err)
+	assert.Equal(t, "jobs.7.>", filter.String())
+
+	for _, partition := range []int{-1, 8} {
+		_, err = p.Subject(partition)
+		assert.ErrorIs(t, err, natsutil.ErrPartitionOutOfRange)
+		_, err = p.Filter(partition)
+		assert.ErrorIs(t, err, natsutil.ErrPartitionOutOfRange)
+	}
+
+	subject := p.KeySubject("order-1")
+	assert.Equal(t, fmt.Sprintf("jobs.%d", p.Partition("order-1")), subject.String())
+}
+
+func TestPartitioner_Partition(t *testing.T) {
+	const keys = 10000
+	p := newPartitioner(t, 10)
+	grown := newPartitioner(t, 11)
+
+	counts := make([]int, p.Count())
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("order-%d", i)
+		partition := p.Partition(key)
+		assert.Equal(t, partition, p.Partition(key))
+		counts[partition]++
+
+		// growing the partition count only moves keys to the new partition
+		if next := grown.Partition(key); next != partition {
+			assert.Equal(t, 10, next)
+			moved++
+		}
+	}
+
+	for _, count := range counts {
+		assert.InDelta(t, keys/10, count, keys/50)
+	}
+	assert.InDelta(t, keys/11, moved, keys/50)
+}
+
+func TestPartitioner_Assign(t *testing.T) {
+	p := newPartitioner(t, 64)
+
+	assert.Empty(t, p.Assign(nil))
+
+	members := []string{"d", "a", "c", "b"}
+	assignment := p.Assign(members)
+
+	// every partition is assigned exactly once and shares are balanced
+	owners := make(map[int]string)
+	for member, partitions := range assignment {
+		assert.Len(t, partitions, 16)
+		assert.IsIncreasing(t, partitions)
+		for _, partition := range partitions {
+			assert.NotContains(t, owners, partition)
+			owners[partition] = member
+		}
+	}
+	assert.Len(t, owners, 64)
+
+	// the result does not depend on the order of the members or duplicates
+	assert.Equal(t, assignment, p.Assign([]string{"a", "b", "c", "d", "a"}))
+
+	// a joining member takes partitions without the others exchanging many between themselves
+	moved := 0
+	for member, partitions := range p.Assign(append(members, "e")) {
+		for _, partition := range partitions {
+			if owners[partition] != member {
+				moved++
+			}
+		}
+	}
+	assert.GreaterOrEqual(t, moved, 12)
+	assert.LessOrEqual(t, moved, 2*13)
+
+	// members beyond the partition count are left without partitions
+	small := newPartitioner(t, 2)
+	assignment = small.Assign([]string{"a", "b", "c"})
+	assert.Len(t, assignment, 2)
+}
+
+type partitionTracker struct {
+	mu       sync.Mutex
+	assigned map[int]bool
+}
+
+func (p *partitionTracker) options() []natsutil.AssignerOption {
+	p.assigned = make(map[int]bool)
+	return []natsutil.AssignerOption{
+		natsutil.WithRebalanceDelay(50 * time.Millisecond),
+		natsutil.WithOnPartitionsAssigned(func(partitions []int) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			for _, partition := range partitions {
+				p.assigned[partition] = true
+			}
+		}),
+		natsutil.WithOnPartitionsRevoked(func(partitions []int) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			for _, partition := range partitions {
+				delete(p.assigned, partition)
+			}
+		}),
+	}
+}
+
+func (p *partitionTracker) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.assigned)
+}
+
+func TestPartitionAssigner(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.ServiceInstance[testDescriptor]](createTestBucket(t, js), &encoder)
+	registry := newRegistry(t, kv, natsutil.WithRegistryTTL(time.Second))
+	p := newPartitioner(t, 8)
+
+	a, err := registry.Register("workers", "a", testDescriptor{})
+	assert.Nil(t, err)
+	defer func() { _ = a.Deregister() }()
+
+	var trackerA, trackerB partitionTracker
+	assignerA := natsutil.NewPartitionAssigner(p, registry, "workers", "a", trackerA.options()...)
+	assignerB := natsutil.NewPartitionAssigner(p, registry, "workers", "b", trackerB.options()...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctxB, cancelB := context.WithCancel(ctx)
+
+	done := make(chan error, 2)
+	go func() { done <- assignerA.Run(ctx) }()
+	go func() { done <- assignerB.Run(ctxB) }()
+
+	// only a is registered so it is assigned every partition
+	assert.Eventually(t, func() bool { return trackerA.count() == 8 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, trackerB.count())
+	assert.Empty(t, assignerB.Assigned())
+
+	// the partitions are divided once b registers
+	b, err := registry.Register("workers", "b", testDescriptor{})
+	assert.Nil(t, err)
+
+	expected := p.Assign([]string{"a", "b"})
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(expected["a"], assignerA.Assigned()) &&
+			assert.ObjectsAreEqual(expected["b"], assignerB.Assigned())
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, len(expected["a"]), trackerA.count())
+	assert.Equal(t, len(expected["b"]), trackerB.count())
+
+	// stopping b revokes its partitions, deregistering it returns them to a
+	cancelB()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, 0, trackerB.count())
+	assert.Empty(t, assignerB.Assigned())
+
+	assert.Nil(t, b.Deregister())
+	assert.Eventually(t, func() bool { return trackerA.count() == 8 }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, 0, trackerA.count())
+}
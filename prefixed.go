@@ -0,0 +1,253 @@
+package natsutil
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+const ErrPrefixInvalid = errors.ConstError("prefix must be a non-empty subject without wildcards")
+
+// prefixedKV is a view of the keys of a KeyValue which share a prefix.
+type prefixedKV[T any] struct {
+	kv KeyValue[T]
+	// prefix is the prefix followed by a separator.
+	prefix string
+}
+
+// NewPrefixedKeyValue returns a view of the keys of kv which start with prefix, allowing several tenants or
+// components to share a bucket without each having to manage the prefix. Keys passed to the view are prepended with
+// the prefix, Watch, WatchAll and Keys are limited to keys under the prefix, and the prefix is removed from the keys of
+// entries and watch updates.
+//
+// The prefix is copied, so later changes to the builder do not affect the view. Delegate returns the underlying
+// nats.KeyValue, which is not limited to the prefix. Returns ErrPrefixInvalid if the prefix is empty or contains
+// wildcards.
+func NewPrefixedKeyValue[T any](kv KeyValue[T], prefix *SubjectBuilder) (KeyValue[T], error) {
+	if prefix.Len() == 0 || prefix.IsWildcard() {
+		return nil, errors.Annotatef(ErrPrefixInvalid, "%q", prefix.String())
+	}
+	return &prefixedKV[T]{kv: kv, prefix: prefix.String() + SubjectSeparator}, nil
+}
+
+func (p *prefixedKV[T]) key(key string) string {
+	return p.prefix + key
+}
+
+func (p *prefixedKV[T]) entry(entry KeyValueEntry[T]) KeyValueEntry[T] {
+	if entry == nil {
+		return nil
+	}
+	return &prefixedEntry[T]{KeyValueEntry: entry, key: strings.TrimPrefix(entry.Key(), p.prefix)}
+}
+
+func (p *prefixedKV[T]) Delegate() nats.KeyValue {
+	return p.kv.Delegate()
+}
+
+func (p *prefixedKV[T]) Encoder() nats.Encoder {
+	return p.kv.Encoder()
+}
+
+func (p *prefixedKV[T]) KeyEncoding() TokenEncoding {
+	return p.kv.KeyEncoding()
+}
+
+func (p *prefixedKV[T]) Get(key string) (KeyValueEntry[T], error) {
+	entry, err := p.kv.Get(p.key(key))
+	if err != nil {
+		return nil, err
+	}
+	return p.entry(entry), nil
+}
+
+func (p *prefixedKV[T]) GetRevision(key string, revision uint64) (KeyValueEntry[T], error) {
+	entry, err := p.kv.GetRevision(p.key(key), revision)
+	if err != nil {
+		return nil, err
+	}
+	return p.entry(entry), nil
+}
+
+func (p *prefixedKV[T]) GetMany(keys []string, opts ...BatchOption) (map[string]KeyValueEntry[T], error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = p.key(key)
+	}
+
+	entries, err := p.kv.GetMany(prefixed, opts...)
+	result := make(map[string]KeyValueEntry[T], len(entries))
+	for key, entry := range entries {
+		result[strings.TrimPrefix(key, p.prefix)] = p.entry(entry)
+	}
+	return result, p.batchError(err)
+}
+
+func (p *prefixedKV[T]) Put(key string, value T) (uint64, error) {
+	return p.kv.Put(p.key(key), value)
+}
+
+func (p *prefixedKV[T]) Create(key string, value T) (uint64, error) {
+	return p.kv.Create(p.key(key), value)
+}
+
+func (p *prefixedKV[T]) Update(key string, value T, last uint64) (uint64, error) {
+	return p.kv.Update(p.key(key), value, last)
+}
+
+func (p *prefixedKV[T]) PutMany(values map[string]T, opts ...BatchOption) (map[string]uint64, error) {
+	prefixed := make(map[string]T, len(values))
+	for key, value := range values {
+		prefixed[p.key(key)] = value
+	}
+
+	revisions, err := p.kv.PutMany(prefixed, opts...)
+	result := make(map[string]uint64, len(revisions))
+	for key, revision := range revisions {
+		result[strings.TrimPrefix(key, p.prefix)] = revision
+	}
+	return result, p.batchError(err)
+}
+
+// batchError removes the prefix from the keys of a *BatchError.
+func (p *prefixedKV[T]) batchError(err error) error {
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		return err
+	}
+	errs := make(map[string]error, len(batchErr.Errors))
+	for key, err := range batchErr.Errors {
+		errs[strings.TrimPrefix(key, p.prefix)] = err
+	}
+	return &BatchError{Errors: errs}
+}
+
+func (p *prefixedKV[T]) Delete(key string, opts ...nats.DeleteOpt) error {
+	return p.kv.Delete(p.key(key), opts...)
+}
+
+func (p *prefixedKV[T]) Purge(key string, opts ...nats.DeleteOpt) error {
+	return p.kv.Purge(p.key(key), opts...)
+}
+
+func (p *prefixedKV[T]) Watch(keys string, opts ...nats.WatchOpt) (KeyWatcher[T], error) {
+	watcher, err := p.kv.Watch(p.key(keys), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &prefixedWatcher[T]{KeyWatcher: watcher, view: p, stop: make(chan struct{})}, nil
+}
+
+func (p *prefixedKV[T]) WatchAll(opts ...nats.WatchOpt) (KeyWatcher[T], error) {
+	return p.Watch(SubjectChevron, opts...)
+}
+
+func (p *prefixedKV[T]) Keys(opts ...nats.WatchOpt) ([]string, error) {
+	watcher, err := p.WatchAll(append(opts, nats.IgnoreDeletes(), nats.MetaOnly())...)
+	if err != nil {
+		return nil, err
+	}
+	return collectKeys(watcher)
+}
+
+func (p *prefixedKV[T]) History(key string, opts ...nats.WatchOpt) ([]KeyValueEntry[T], error) {
+	entries, err := p.kv.History(p.key(key), opts...)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		entries[i] = p.entry(entry)
+	}
+	return entries, nil
+}
+
+func (p *prefixedKV[T]) Bucket() string {
+	return p.kv.Bucket()
+}
+
+// prefixedEntry is an entry whose key has had the prefix of a view removed.
+type prefixedEntry[T any] struct {
+	KeyValueEntry[T]
+	key string
+}
+
+func (e *prefixedEntry[T]) Key() string {
+	return e.key
+}
+
+// rawPrefixedEntry is a nats.KeyValueEntry whose key has had the prefix of a view removed.
+type rawPrefixedEntry struct {
+	nats.KeyValueEntry
+	key string
+}
+
+func (e *rawPrefixedEntry) Key() string {
+	return e.key
+}
+
+// prefixedWatcher removes the prefix of a view from the keys of updates.
+//
+// Each channel is created on first use and returned by every later call, so that callers share one stream of updates
+// rather than splitting it. The routines feeding them exit once the watcher is stopped, even if nothing is reading.
+type prefixedWatcher[T any] struct {
+	KeyWatcher[T]
+	view *prefixedKV[T]
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	updates          chan nats.KeyValueEntry
+	updatesOnce      sync.Once
+	unmarshalled     chan KeyValueEntry[T]
+	unmarshalledOnce sync.Once
+}
+
+func (w *prefixedWatcher[T]) Stop() error {
+	w.stopOnce.Do(func() { close(w.stop) })
+	return w.KeyWatcher.Stop()
+}
+
+// Updates removes the prefix from the keys of the raw entries, which are encoded if the view has a key encoding.
+func (w *prefixedWatcher[T]) Updates() <-chan nats.KeyValueEntry {
+	w.updatesOnce.Do(func() {
+		updates := w.KeyWatcher.Updates()
+		prefix := EncodeKey(w.view.KeyEncoding(), strings.TrimSuffix(w.view.prefix, SubjectSeparator)) + SubjectSeparator
+
+		w.updates = make(chan nats.KeyValueEntry, 256)
+		go func() {
+			defer close(w.updates)
+			for entry := range updates {
+				if entry != nil {
+					entry = &rawPrefixedEntry{KeyValueEntry: entry, key: strings.TrimPrefix(entry.Key(), prefix)}
+				}
+				select {
+				case w.updates <- entry:
+				case <-w.stop:
+					return
+				}
+			}
+		}()
+	})
+	return w.updates
+}
+
+func (w *prefixedWatcher[T]) UpdatesUnmarshalled() <-chan KeyValueEntry[T] {
+	w.unmarshalledOnce.Do(func() {
+		updates := w.KeyWatcher.UpdatesUnmarshalled()
+
+		w.unmarshalled = make(chan KeyValueEntry[T], 256)
+		go func() {
+			defer close(w.unmarshalled)
+			for entry := range updates {
+				select {
+				case w.unmarshalled <- w.view.entry(entry):
+				case <-w.stop:
+					return
+				}
+			}
+		}()
+	})
+	return w.unmarshalled
+}
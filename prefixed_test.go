@@ -0,0 +1,232 @@
+package natsutil_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/41north/natsutil.go"
+	"github.com/nats-io/nats.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTenant(t *testing.T, kv natsutil.KeyValue[int], tokens ...string) natsutil.KeyValue[int] {
+	prefix := natsutil.SubjectBuilder{}
+	prefix.MustPush(tokens...)
+	tenant, err := natsutil.NewPrefixedKeyValue(kv, &prefix)
+	assert.Nil(t, err)
+	return tenant
+}
+
+func watchKeys(t *testing.T, watcher natsutil.KeyWatcher[int]) []string {
+	var keys []string
+	for entry := range watcher.UpdatesUnmarshalled() {
+		if entry == nil {
+			break
+		}
+		keys = append(keys, entry.Key())
+	}
+	assert.Nil(t, watcher.Stop())
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPrefixedKeyValue(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	bucket := createTestBucket(t, js)
+	kv := natsutil.NewKeyValue[int](bucket, &encoder)
+
+	acme := newTenant(t, kv, "tenants", "acme")
+	globex := newTenant(t, kv, "tenants", "globex")
+
+	_, err := acme.Put("users.alice", 1)
+	assert.Nil(t, err)
+	_, err = acme.Create("users.bob", 2)
+	assert.Nil(t, err)
+	revision, err := globex.Put("users.alice", 3)
+	assert.Nil(t, err)
+
+	// keys are written under the prefix and each tenant only sees its own
+	raw, err := bucket.Keys()
+	assert.Nil(t, err)
+	sort.Strings(raw)
+	assert.Equal(t, []string{"tenants.acme.users.alice", "tenants.acme.users.bob", "tenants.globex.users.alice"}, raw)
+
+	entry, err := acme.Get("users.alice")
+	assert.Nil(t, err)
+	assert.Equal(t, "users.alice", entry.Key())
+	value, err := entry.UnmarshalValue()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, value)
+
+	_, err = globex.Get("users.bob")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+
+	_, err = globex.Update("users.alice", 4, revision)
+	assert.Nil(t, err)
+	history, err := globex.History("users.alice")
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+	for _, entry := range history {
+		assert.Equal(t, "users.alice", entry.Key())
+	}
+	entry, err = globex.GetRevision("users.alice", revision)
+	assert.Nil(t, err)
+	assert.Equal(t, "users.alice", entry.Key())
+
+	// watches and key listings are limited to the prefix
+	keys, err := acme.Keys()
+	assert.Nil(t, err)
+	sort.Strings(keys)
+	assert.Equal(t, []string{"users.alice", "users.bob"}, keys)
+
+	watcher, err := acme.WatchAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users.alice", "users.bob"}, watchKeys(t, watcher))
+
+	watcher, err = globex.Watch("users.*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users.alice"}, watchKeys(t, watcher))
+
+	watcher, err = acme.Watch("users.bob")
+	assert.Nil(t, err)
+	update := <-watcher.Updates()
+	assert.Equal(t, "users.bob", update.Key())
+	assert.Nil(t, watcher.Stop())
+
+	// deletes and purges only affect the tenant's keys
+	assert.Nil(t, acme.Delete("users.alice"))
+	_, err = acme.Get("users.alice")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+	_, err = globex.Get("users.alice")
+	assert.Nil(t, err)
+
+	assert.Nil(t, globex.Purge("users.alice"))
+	_, err = globex.Keys()
+	assert.ErrorIs(t, err, nats.ErrNoKeysFound)
+
+	keys, err = kv.Keys()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tenants.acme.users.bob"}, keys)
+}
+
+func TestPrefixedKeyValue_Batch(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[int](createTestBucket(t, js), &encoder)
+	acme := newTenant(t, kv, "acme")
+
+	revisions, err := acme.PutMany(map[string]int{"a": 1, "b": 2})
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 2)
+	assert.Contains(t, revisions, "a")
+	assert.Contains(t, revisions, "b")
+
+	for _, opts := range [][]natsutil.BatchOption{nil, {natsutil.WithDirectGet(js)}} {
+		entries, err := acme.GetMany([]string{"a", "b", "missing"}, opts...)
+		assert.Len(t, entries, 2)
+		assert.Equal(t, "a", entries["a"].Key())
+
+		var batchErr *natsutil.BatchError
+		assert.ErrorAs(t, err, &batchErr)
+		assert.Len(t, batchErr.Errors, 1)
+		assert.ErrorIs(t, batchErr.Errors["missing"], nats.ErrKeyNotFound)
+	}
+}
+
+func TestNewPrefixedKeyValue_Invalid(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[int](createTestBucket(t, js), &encoder)
+
+	empty := natsutil.SubjectBuilder{}
+	star := natsutil.SubjectBuilder{}
+	star.MustPush("a")
+	star.Star()
+	chevron := natsutil.SubjectBuilder{}
+	chevron.Chevron()
+
+	for _, prefix := range []*natsutil.SubjectBuilder{&empty, &star, &chevron} {
+		_, err := natsutil.NewPrefixedKeyValue(kv, prefix)
+		assert.ErrorIs(t, err, natsutil.ErrPrefixInvalid, prefix.String())
+	}
+}
+
+func TestPrefixedKeyValue_KeyEncoding(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	bucket := createTestBucket(t, js)
+	kv := natsutil.NewKeyValue[int](bucket, &encoder, natsutil.WithKeyEncoding(natsutil.TokenEncodingEscape))
+	tenant := newTenant(t, kv, "region=eu")
+
+	_, err := tenant.Put("bob smith", 1)
+	assert.Nil(t, err)
+
+	raw, err := bucket.Keys()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"region=3Deu.bob=20smith"}, raw)
+
+	keys, err := tenant.Keys()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bob smith"}, keys)
+
+	// raw updates have the encoded prefix removed but the key itself remains encoded
+	watcher, err := tenant.WatchAll()
+	assert.Nil(t, err)
+	update := <-watcher.Updates()
+	assert.Equal(t, "bob=20smith", update.Key())
+	assert.Nil(t, watcher.Stop())
+}
+
+func TestPrefixedKeyValue_WatchStop(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[int](createTestBucket(t, js), &encoder)
+	acme := newTenant(t, kv, "acme")
+
+	// more than the channel buffers
+	const keys = 300
+	for i := 0; i < keys; i++ {
+		_, err := acme.Put(fmt.Sprintf("k%d", i), i)
+		assert.Nil(t, err)
+	}
+
+	watcher, err := acme.WatchAll()
+	assert.Nil(t, err)
+
+	// repeated calls share a single stream of updates
+	updates := watcher.Updates()
+	assert.Equal(t, updates, watcher.Updates())
+
+	// stopping whilst nothing is reading ends the updates rather than delivering the remainder
+	assert.Eventually(t, func() bool { return len(updates) == cap(updates) }, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, watcher.Stop())
+
+	received := 0
+	timeout := time.After(5 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-updates:
+			closed = !ok
+			if ok {
+				received++
+			}
+		case <-timeout:
+			t.Fatal("updates not closed after stopping")
+		}
+	}
+	assert.Less(t, received, keys+1)
+}
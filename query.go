@@ -0,0 +1,228 @@
+package natsutil
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// QueryOrder determines the order of the results returned by Query.
+type QueryOrder int
+
+const (
+	// QueryUnordered returns results in the order they are decoded, which allows a limit to end the query early.
+	QueryUnordered QueryOrder = iota
+	// QueryOrderByKey returns results sorted by key.
+	QueryOrderByKey
+	// QueryOrderByRevision returns results sorted by the revision they were last written at.
+	QueryOrderByRevision
+)
+
+// QueryResult is an entry matched by a query along with its decoded value.
+type QueryResult[T any] struct {
+	Entry KeyValueEntry[T]
+	Value T
+}
+
+// QueryOption configures Scan and Query.
+type QueryOption[T any] func(q *query[T])
+
+type query[T any] struct {
+	filter      func(key string, value T) bool
+	limit       int
+	order       QueryOrder
+	descending  bool
+	concurrency int
+}
+
+// WithQueryFilter sets a predicate which entries must satisfy to be included in the results.
+func WithQueryFilter[T any](filter func(key string, value T) bool) QueryOption[T] {
+	return func(q *query[T]) {
+		q.filter = filter
+	}
+}
+
+// WithQueryLimit sets the maximum number of results, zero means no limit.
+func WithQueryLimit[T any](limit int) QueryOption[T] {
+	return func(q *query[T]) {
+		q.limit = limit
+	}
+}
+
+// WithQueryOrder sets the order results are returned in by Query. Ordering requires every matching entry to be
+// decoded before any results are returned. It has no effect on Scan.
+func WithQueryOrder[T any](order QueryOrder) QueryOption[T] {
+	return func(q *query[T]) {
+		q.order = order
+	}
+}
+
+// WithQueryDescending reverses the order results are returned in by Query.
+func WithQueryDescending[T any]() QueryOption[T] {
+	return func(q *query[T]) {
+		q.descending = true
+	}
+}
+
+// WithQueryConcurrency sets how many values are decoded concurrently, defaults to the number of CPUs.
+func WithQueryConcurrency[T any](concurrency int) QueryOption[T] {
+	return func(q *query[T]) {
+		q.concurrency = concurrency
+	}
+}
+
+func newQuery[T any](opts []QueryOption[T]) query[T] {
+	q := query[T]{concurrency: runtime.NumCPU()}
+	for _, opt := range opts {
+		opt(&q)
+	}
+	if q.concurrency < 1 {
+		q.concurrency = 1
+	}
+	return q
+}
+
+// Scan calls fn with each entry whose key matches pattern, which may contain wildcards, and whose value satisfies
+// the filter. Values are decoded concurrently so fn is called in no particular order, though never concurrently.
+// Entries whose value cannot be decoded are skipped.
+//
+// Scanning ends once every matching entry has been visited, the limit has been reached, fn returns false or the
+// context is done, in which case the context error is returned.
+func Scan[T any](
+	ctx context.Context,
+	kv KeyValue[T],
+	pattern string,
+	fn func(result QueryResult[T]) bool,
+	opts ...QueryOption[T],
+) error {
+	q := newQuery(opts)
+
+	watcher, err := kv.Watch(pattern, nats.IgnoreDeletes())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = watcher.Stop() }()
+
+	scanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	entries := make(chan KeyValueEntry[T])
+	results := make(chan QueryResult[T])
+
+	go func() {
+		defer close(entries)
+		updates := watcher.UpdatesUnmarshalled()
+		for {
+			select {
+			case <-scanCtx.Done():
+				return
+			case entry, ok := <-updates:
+				if !ok || entry == nil {
+					// all current values have been received
+					return
+				}
+				select {
+				case entries <- entry:
+				case <-scanCtx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range entries {
+				value, err := entry.UnmarshalValue()
+				if err != nil {
+					// already logged when decoding
+					continue
+				}
+				if q.filter != nil && !q.filter(entry.Key(), value) {
+					continue
+				}
+				select {
+				case results <- QueryResult[T]{Entry: entry, Value: value}:
+				case <-scanCtx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	count := 0
+	for result := range results {
+		if scanCtx.Err() != nil {
+			break
+		}
+		count++
+		if !fn(result) || count == q.limit {
+			cancel()
+			break
+		}
+	}
+
+	// wait for the workers to exit
+	for range results {
+	}
+
+	return ctx.Err()
+}
+
+// Query returns the entries whose key matches pattern, which may contain wildcards, and whose value satisfies the
+// filter, in the configured order and up to the configured limit. Entries whose value cannot be decoded are skipped.
+func Query[T any](
+	ctx context.Context,
+	kv KeyValue[T],
+	pattern string,
+	opts ...QueryOption[T],
+) ([]QueryResult[T], error) {
+	q := newQuery(opts)
+
+	scanOpts := opts
+	if q.order != QueryUnordered {
+		// every match must be found before sorting, the limit is applied afterwards
+		scanOpts = append(append([]QueryOption[T](nil), opts...), WithQueryLimit[T](0))
+	}
+
+	var results []QueryResult[T]
+	err := Scan(ctx, kv, pattern, func(result QueryResult[T]) bool {
+		results = append(results, result)
+		return true
+	}, scanOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.order == QueryUnordered {
+		return results, nil
+	}
+
+	less := func(i, j int) bool {
+		a, b := results[i].Entry, results[j].Entry
+		if q.descending {
+			a, b = b, a
+		}
+		if q.order == QueryOrderByRevision {
+			return a.Revision() < b.Revision()
+		}
+		return a.Key() < b.Key()
+	}
+	sort.Slice(results, less)
+
+	if q.limit > 0 && len(results) > q.limit {
+		results = results[:q.limit]
+	}
+	return results, nil
+}
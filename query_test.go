@@ -0,0 +1,114 @@
+package natsutil_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testJob struct {
+	Status string `json:"status"`
+}
+
+func resultKeys[T any](results []natsutil.QueryResult[T]) []string {
+	keys := make([]string, len(results))
+	for idx, result := range results {
+		keys[idx] = result.Entry.Key()
+	}
+	return keys
+}
+
+func TestQuery(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[testJob](createTestBucket(t, js), &encoder)
+
+	// written in reverse key order so that key and revision order differ
+	for i := 9; i >= 0; i-- {
+		status := "ok"
+		if i%3 == 0 {
+			status = "failed"
+		}
+		_, err := kv.Put(fmt.Sprintf("jobs.%d", i), testJob{Status: status})
+		assert.Nil(t, err)
+	}
+	_, err := kv.Put("other.0", testJob{Status: "failed"})
+	assert.Nil(t, err)
+	_, err = kv.Delegate().Put("jobs.invalid", []byte("not json"))
+	assert.Nil(t, err)
+	assert.Nil(t, kv.Delete("jobs.6"))
+
+	ctx := context.Background()
+	failed := natsutil.WithQueryFilter(func(_ string, job testJob) bool { return job.Status == "failed" })
+
+	results, err := natsutil.Query(ctx, kv, "jobs.*", failed, natsutil.WithQueryOrder[testJob](natsutil.QueryOrderByKey))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"jobs.0", "jobs.3", "jobs.9"}, resultKeys(results))
+	assert.Equal(t, testJob{Status: "failed"}, results[0].Value)
+
+	results, err = natsutil.Query(ctx, kv, "jobs.*", failed,
+		natsutil.WithQueryOrder[testJob](natsutil.QueryOrderByRevision),
+		natsutil.WithQueryLimit[testJob](2),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"jobs.9", "jobs.3"}, resultKeys(results))
+
+	results, err = natsutil.Query(ctx, kv, "jobs.*",
+		natsutil.WithQueryOrder[testJob](natsutil.QueryOrderByKey),
+		natsutil.WithQueryDescending[testJob](),
+		natsutil.WithQueryLimit[testJob](3),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"jobs.9", "jobs.8", "jobs.7"}, resultKeys(results))
+
+	// unordered queries stop once the limit is reached
+	results, err = natsutil.Query(ctx, kv, ">", natsutil.WithQueryLimit[testJob](4))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(results))
+
+	// deleted and undecodable entries are skipped
+	results, err = natsutil.Query(ctx, kv, ">", natsutil.WithQueryConcurrency[testJob](1))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(results))
+
+	results, err = natsutil.Query(ctx, kv, "missing.*")
+	assert.Nil(t, err)
+	assert.Empty(t, results)
+}
+
+func TestScan_EarlyTermination(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[testJob](createTestBucket(t, js), &encoder)
+
+	for i := 0; i < 50; i++ {
+		_, err := kv.Put(fmt.Sprintf("jobs.%d", i), testJob{Status: "ok"})
+		assert.Nil(t, err)
+	}
+
+	visited := 0
+	err := natsutil.Scan(context.Background(), kv, "jobs.*", func(natsutil.QueryResult[testJob]) bool {
+		visited++
+		return visited < 5
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, visited)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	visited = 0
+	err = natsutil.Scan(ctx, kv, "jobs.*", func(natsutil.QueryResult[testJob]) bool {
+		visited++
+		cancel()
+		return true
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, visited)
+}
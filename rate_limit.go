@@ -0,0 +1,335 @@
+package natsutil
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	ErrRateLimitExceedsCapacity = errors.ConstError("requested more than the rate limiter can ever allow at once")
+	ErrRateLimitInvalid         = errors.ConstError("rate limiter rate, window and capacity must be positive")
+	ErrRateLimitBatchInvalid    = errors.ConstError("rate limiter batch ttl must be positive")
+	ErrRateLimitRequestInvalid  = errors.ConstError("number of requests must be positive")
+)
+
+// errRateLimited aborts a state update when the request cannot be allowed, so that nothing is written.
+const errRateLimited = errors.ConstError("rate limited")
+
+// TokenBucketState is the shared state of a token bucket rate limiter.
+type TokenBucketState struct {
+	// Tokens available as of Updated.
+	Tokens float64 `json:"tokens"`
+	// Updated is when tokens were last taken from the bucket.
+	Updated time.Time `json:"updated"`
+}
+
+// SlidingWindowState is the shared state of a sliding window rate limiter.
+type SlidingWindowState struct {
+	// Start of the current fixed window.
+	Start time.Time `json:"start"`
+	// Current is the number of requests allowed in the current window.
+	Current int64 `json:"current"`
+	// Previous is the number of requests allowed in the window before the current one.
+	Previous int64 `json:"previous"`
+}
+
+// RateLimitOption configures a RateLimiter.
+type RateLimitOption func(l *RateLimiter)
+
+// WithRateLimitBatch reserves up to size tokens from the shared state at once and hands them out locally, reducing
+// round trips to one per batch. Reserved tokens which have not been handed out within ttl are discarded, they are
+// not returned to the shared state. Batching trades accuracy for throughput: tokens reserved by one process cannot be
+// used by another, so a process may be limited whilst another holds unused reservations. The ttl must be positive
+// when size is greater than one.
+func WithRateLimitBatch(size int64, ttl time.Duration) RateLimitOption {
+	return func(l *RateLimiter) {
+		l.batch = size
+		l.batchTTL = ttl
+	}
+}
+
+// WithRateLimitMaxRetries sets how many times an update is retried when another process modifies the state first,
+// defaults to DefaultMaxRetries.
+func WithRateLimitMaxRetries(maxRetries int) RateLimitOption {
+	return func(l *RateLimiter) {
+		l.maxRetries = maxRetries
+	}
+}
+
+// WithRateLimitLogger sets the logger which receives retries and rejected requests.
+func WithRateLimitLogger(logger *slog.Logger) RateLimitOption {
+	return func(l *RateLimiter) {
+		l.logger = logger
+	}
+}
+
+// takeFunc takes between need and want tokens from the shared state, returning how many were granted. If fewer than
+// need are available nothing is taken and the time after which they should be available is returned instead.
+type takeFunc func(need, want int64) (granted int64, retryAfter time.Duration, err error)
+
+// RateLimiter limits the rate of requests across every process sharing its key.
+//
+// The state of the limiter is read and written with Update against the revision which was read, retrying if
+// another process modified it first. A request which is not allowed never modifies the state. If the state remains
+// contended for more than the configured number of retries ErrTooManyRetries is returned, leaving the caller to
+// decide whether to fail open or closed.
+//
+// Time is taken from the local clock of each process, so clocks should be kept in sync. A clock which is behind the
+// time last recorded in the state is treated as if no time has passed.
+type RateLimiter struct {
+	key        string
+	capacity   int64
+	batch      int64
+	batchTTL   time.Duration
+	maxRetries int
+	logger     *slog.Logger
+	take       takeFunc
+
+	mu            sync.Mutex
+	reserved      int64
+	reservedUntil time.Time
+}
+
+func newRateLimiter(bucket string, key string, capacity int64, opts []RateLimitOption) (*RateLimiter, error) {
+	l := &RateLimiter{key: key, capacity: capacity, batch: 1, maxRetries: DefaultMaxRetries}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.batch > 1 && l.batchTTL <= 0 {
+		return nil, errors.Annotatef(ErrRateLimitBatchInvalid, "batch %d, ttl %v", l.batch, l.batchTTL)
+	}
+	if l.batch < 1 {
+		l.batch = 1
+	}
+	if l.batch > capacity {
+		l.batch = capacity
+	}
+	if l.logger == nil {
+		l.logger = DiscardLogger()
+	}
+	l.logger = l.logger.With(slog.String(LogKeyBucket, bucket), slog.String(LogKeyKey, key))
+	return l, nil
+}
+
+// NewTokenBucket creates a RateLimiter stored under key which allows bursts of up to burst requests, refilling at
+// rate tokens per second. Returns ErrRateLimitInvalid unless rate is positive and finite and burst is positive, and
+// ErrRateLimitBatchInvalid if batching is configured without a positive ttl.
+func NewTokenBucket(
+	kv KeyValue[TokenBucketState],
+	key string,
+	rate float64,
+	burst int64,
+	opts ...RateLimitOption,
+) (*RateLimiter, error) {
+	if !(rate > 0) || math.IsInf(rate, 1) || burst < 1 {
+		return nil, errors.Annotatef(ErrRateLimitInvalid, "rate %v, burst %d", rate, burst)
+	}
+	l, err := newRateLimiter(kv.Bucket(), key, burst, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	l.take = func(need, want int64) (granted int64, retryAfter time.Duration, err error) {
+		_, _, err = casUpdate[TokenBucketState](kv, key, l.maxRetries, l.logger,
+			func(state TokenBucketState, exists bool) (TokenBucketState, error) {
+				now := time.Now()
+
+				tokens := float64(burst)
+				if exists {
+					tokens = state.Tokens
+					if elapsed := now.Sub(state.Updated); elapsed > 0 {
+						tokens = math.Min(float64(burst), tokens+elapsed.Seconds()*rate)
+					} else {
+						now = state.Updated
+					}
+				}
+
+				if tokens < float64(need) {
+					granted = 0
+					retryAfter = time.Duration((float64(need) - tokens) / rate * float64(time.Second))
+					return state, errRateLimited
+				}
+
+				granted = int64(math.Min(float64(want), math.Floor(tokens)))
+				return TokenBucketState{Tokens: tokens - float64(granted), Updated: now}, nil
+			})
+		return granted, retryAfter, err
+	}
+
+	return l, nil
+}
+
+// NewSlidingWindow creates a RateLimiter stored under key which allows up to limit requests in any window.
+//
+// The number of requests in the sliding window is estimated from counts for the current and previous fixed windows,
+// weighting the previous count by how much of it still overlaps the sliding window. Returns ErrRateLimitInvalid
+// unless limit and window are positive, and ErrRateLimitBatchInvalid if batching is configured without a positive
+// ttl.
+func NewSlidingWindow(
+	kv KeyValue[SlidingWindowState],
+	key string,
+	limit int64,
+	window time.Duration,
+	opts ...RateLimitOption,
+) (*RateLimiter, error) {
+	if limit < 1 || window <= 0 {
+		return nil, errors.Annotatef(ErrRateLimitInvalid, "limit %d, window %v", limit, window)
+	}
+	l, err := newRateLimiter(kv.Bucket(), key, limit, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	l.take = func(need, want int64) (granted int64, retryAfter time.Duration, err error) {
+		_, _, err = casUpdate[SlidingWindowState](kv, key, l.maxRetries, l.logger,
+			func(state SlidingWindowState, exists bool) (SlidingWindowState, error) {
+				now := time.Now()
+				start := now.Truncate(window)
+
+				if exists && start.Before(state.Start) {
+					// the local clock is behind, count the request against the latest window
+					now, start = state.Start, state.Start
+				}
+
+				next := SlidingWindowState{Start: start}
+				switch {
+				case start.Equal(state.Start):
+					next.Current, next.Previous = state.Current, state.Previous
+				case start.Equal(state.Start.Add(window)):
+					next.Previous = state.Current
+				}
+
+				overlap := 1 - float64(now.Sub(start))/float64(window)
+				available := float64(limit) - (float64(next.Previous)*overlap + float64(next.Current))
+
+				if available < float64(need) {
+					granted = 0
+					retryAfter = next.retryAfter(now, window, limit, need)
+					return state, errRateLimited
+				}
+
+				granted = int64(math.Min(float64(want), math.Floor(available)))
+				next.Current += granted
+				return next, nil
+			})
+		return granted, retryAfter, err
+	}
+
+	return l, nil
+}
+
+// retryAfter estimates how long until n requests fit within the limit.
+func (s SlidingWindowState) retryAfter(now time.Time, window time.Duration, limit int64, n int64) time.Duration {
+	end := s.Start.Add(window)
+
+	headroom := limit - s.Current - n
+	if headroom < 0 || s.Previous == 0 {
+		// requests allowed in the current window must slide out of the previous one
+		return end.Sub(now)
+	}
+
+	// the overlap with the previous window at which the estimate drops to the limit
+	overlap := float64(headroom) / float64(s.Previous)
+	at := s.Start.Add(time.Duration((1 - overlap) * float64(window)))
+	if at.Before(now) {
+		return 0
+	}
+	return at.Sub(now)
+}
+
+// Key returns the key the state of the limiter is stored under.
+func (l *RateLimiter) Key() string {
+	return l.key
+}
+
+// Allow reports whether a single request is allowed.
+func (l *RateLimiter) Allow() (bool, error) {
+	return l.AllowN(1)
+}
+
+// AllowN reports whether n requests are allowed at once, in which case they are counted against the limit.
+func (l *RateLimiter) AllowN(n int64) (bool, error) {
+	_, err := l.reserve(n)
+	if errors.Is(err, errRateLimited) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Wait blocks until a single request is allowed or the context is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n requests are allowed at once or the context is done.
+func (l *RateLimiter) WaitN(ctx context.Context, n int64) error {
+	for {
+		retryAfter, err := l.reserve(n)
+		if !errors.Is(err, errRateLimited) {
+			return err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < retryAfter {
+			return context.DeadlineExceeded
+		}
+
+		// other processes may take the tokens first, in which case we wait again
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes n tokens, from the local reservation if possible. If n tokens are not available errRateLimited is
+// returned along with how long to wait before trying again. ErrRateLimitRequestInvalid is returned unless n is
+// positive.
+//
+// Callers are serialised whilst the shared state is updated so that they can share the resulting batch.
+func (l *RateLimiter) reserve(n int64) (time.Duration, error) {
+	if n < 1 {
+		return 0, errors.Annotatef(ErrRateLimitRequestInvalid, "%d", n)
+	}
+	if n > l.capacity {
+		return 0, ErrRateLimitExceedsCapacity
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Now().After(l.reservedUntil) {
+		l.reserved = 0
+	}
+	if l.reserved >= n {
+		l.reserved -= n
+		return 0, nil
+	}
+
+	need := n - l.reserved
+	want := need
+	if l.batch > want {
+		want = l.batch
+	}
+
+	granted, retryAfter, err := l.take(need, want)
+	if err != nil {
+		if errors.Is(err, errRateLimited) {
+			l.logger.Debug("rate limited", slog.Int64("requested", n), slog.Duration("retryAfter", retryAfter))
+		}
+		return retryAfter, err
+	}
+
+	l.reserved += granted - n
+	if l.reserved > 0 {
+		l.reservedUntil = time.Now().Add(l.batchTTL)
+	}
+	return 0, nil
+}
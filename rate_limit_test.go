@@ -0,0 +1,258 @@
+package natsutil_test
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Invalid(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	bucket := createTestBucket(t, js)
+	tokens := natsutil.NewKeyValue[natsutil.TokenBucketState](bucket, &encoder)
+	windows := natsutil.NewKeyValue[natsutil.SlidingWindowState](bucket, &encoder)
+
+	for _, rate := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		_, err := natsutil.NewTokenBucket(tokens, "limits.api", rate, 5)
+		assert.ErrorIs(t, err, natsutil.ErrRateLimitInvalid, rate)
+	}
+	_, err := natsutil.NewTokenBucket(tokens, "limits.api", 10, 0)
+	assert.ErrorIs(t, err, natsutil.ErrRateLimitInvalid)
+
+	_, err = natsutil.NewSlidingWindow(windows, "limits.api", 0, time.Second)
+	assert.ErrorIs(t, err, natsutil.ErrRateLimitInvalid)
+	_, err = natsutil.NewSlidingWindow(windows, "limits.api", 5, 0)
+	assert.ErrorIs(t, err, natsutil.ErrRateLimitInvalid)
+
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		_, err = natsutil.NewTokenBucket(tokens, "limits.api", 10, 5, natsutil.WithRateLimitBatch(2, ttl))
+		assert.ErrorIs(t, err, natsutil.ErrRateLimitBatchInvalid)
+		_, err = natsutil.NewSlidingWindow(windows, "limits.api", 5, time.Second, natsutil.WithRateLimitBatch(2, ttl))
+		assert.ErrorIs(t, err, natsutil.ErrRateLimitBatchInvalid)
+	}
+	_, err = natsutil.NewTokenBucket(tokens, "limits.api", 10, 5, natsutil.WithRateLimitBatch(1, 0))
+	assert.Nil(t, err)
+
+	// requesting no or a negative number of tokens does not inflate a batch
+	limiter, err := natsutil.NewTokenBucket(tokens, "limits.api", 10, 5, natsutil.WithRateLimitBatch(5, time.Minute))
+	assert.Nil(t, err)
+	for _, n := range []int64{0, -10} {
+		_, err = limiter.AllowN(n)
+		assert.ErrorIs(t, err, natsutil.ErrRateLimitRequestInvalid)
+		assert.ErrorIs(t, limiter.WaitN(context.Background(), n), natsutil.ErrRateLimitRequestInvalid)
+	}
+	allowed, err := limiter.AllowN(5)
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+	allowed, err = limiter.AllowN(1)
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+}
+
+func TestTokenBucket(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.TokenBucketState](createTestBucket(t, js), &encoder)
+
+	limiter, err := natsutil.NewTokenBucket(kv, "limits.api", 10, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "limits.api", limiter.Key())
+
+	// the bucket starts full
+	for i := 0; i < 5; i++ {
+		allowed, err := limiter.Allow()
+		assert.Nil(t, err)
+		assert.True(t, allowed)
+	}
+
+	allowed, err := limiter.Allow()
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+
+	_, err = limiter.AllowN(6)
+	assert.ErrorIs(t, err, natsutil.ErrRateLimitExceedsCapacity)
+
+	// a rejected request does not modify the state
+	entry, err := kv.Get("limits.api")
+	assert.Nil(t, err)
+	revision := entry.Revision()
+
+	allowed, err = limiter.AllowN(5)
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+
+	entry, err = kv.Get("limits.api")
+	assert.Nil(t, err)
+	assert.Equal(t, revision, entry.Revision())
+
+	// tokens are refilled at the configured rate
+	time.Sleep(250 * time.Millisecond)
+	allowed, err = limiter.AllowN(2)
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+
+	// the state is shared with other limiters using the same key
+	other, err := natsutil.NewTokenBucket(kv, "limits.api", 10, 5)
+	assert.Nil(t, err)
+	allowed, err = other.AllowN(2)
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	assert.Nil(t, other.WaitN(ctx, 2))
+	assert.Greater(t, time.Since(start), 50*time.Millisecond)
+
+	short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelShort()
+	assert.ErrorIs(t, other.WaitN(short, 5), context.DeadlineExceeded)
+}
+
+func TestTokenBucket_Contention(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.TokenBucketState](createTestBucket(t, js), &encoder)
+
+	// a negligible refill rate so that only the burst can be allowed
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter, err := natsutil.NewTokenBucket(kv, "limits.api", 0.001, 20)
+			assert.Nil(t, err)
+			for j := 0; j < 5; j++ {
+				ok, err := limiter.Allow()
+				assert.Nil(t, err)
+				if ok {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(20), allowed.Load())
+}
+
+func TestTokenBucket_Batch(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.TokenBucketState](createTestBucket(t, js), &encoder)
+
+	limiter, err := natsutil.NewTokenBucket(kv, "limits.api", 0.001, 10,
+		natsutil.WithRateLimitBatch(4, time.Minute),
+	)
+	assert.Nil(t, err)
+
+	allowed, err := limiter.Allow()
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+
+	// a batch of four tokens was reserved, so the next three requests are allowed without a round trip
+	entry, err := kv.Get("limits.api")
+	assert.Nil(t, err)
+	revision := entry.Revision()
+
+	state, err := entry.UnmarshalValue()
+	assert.Nil(t, err)
+	assert.InDelta(t, 6, state.Tokens, 0.01)
+
+	for i := 0; i < 3; i++ {
+		allowed, err = limiter.Allow()
+		assert.Nil(t, err)
+		assert.True(t, allowed)
+	}
+
+	entry, err = kv.Get("limits.api")
+	assert.Nil(t, err)
+	assert.Equal(t, revision, entry.Revision())
+
+	// a partial batch is granted when fewer tokens remain
+	other, err := natsutil.NewTokenBucket(kv, "limits.api", 0.001, 10,
+		natsutil.WithRateLimitBatch(8, time.Minute),
+	)
+	assert.Nil(t, err)
+	allowed, err = other.Allow()
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = limiter.Allow()
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+
+	for i := 0; i < 5; i++ {
+		allowed, err = other.Allow()
+		assert.Nil(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, err = other.Allow()
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.SlidingWindowState](createTestBucket(t, js), &encoder)
+
+	window := 200 * time.Millisecond
+
+	// start at the beginning of a window
+	time.Sleep(time.Until(time.Now().Truncate(window).Add(window)))
+
+	limiter, err := natsutil.NewSlidingWindow(kv, "limits.api", 4, window)
+	assert.Nil(t, err)
+
+	allowed, err := limiter.AllowN(4)
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = limiter.Allow()
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+
+	// early in the next window most of the previous window still counts
+	time.Sleep(time.Until(time.Now().Truncate(window).Add(window + 20*time.Millisecond)))
+	allowed, err = limiter.AllowN(2)
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, limiter.WaitN(ctx, 2))
+
+	entry, err := kv.Get("limits.api")
+	assert.Nil(t, err)
+	state, err := entry.UnmarshalValue()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), state.Current)
+	assert.Equal(t, int64(4), state.Previous)
+
+	// after two idle windows nothing counts against the limit
+	time.Sleep(2 * window)
+	allowed, err = limiter.AllowN(4)
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+}
@@ -0,0 +1,464 @@
+package natsutil
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	ErrNotRegistered      = errors.ConstError("instance is not registered")
+	ErrRegistryTTLInvalid = errors.ConstError("registry heartbeat must be positive and less than the TTL")
+)
+
+const (
+	// DefaultRegistryPrefix is the subject prefix under which instances are stored if no prefix has been configured.
+	DefaultRegistryPrefix = "services"
+	// DefaultRegistryTTL is how long an instance remains alive without a heartbeat if no TTL has been configured.
+	DefaultRegistryTTL = 15 * time.Second
+)
+
+// ServiceInstance is the value stored for each registered instance of a service.
+type ServiceInstance[T any] struct {
+	// Service is the name of the service the instance belongs to.
+	Service string `json:"service"`
+	// ID uniquely identifies the instance within the service.
+	ID string `json:"id"`
+	// TTL is how long the instance is considered alive after its last heartbeat.
+	TTL time.Duration `json:"ttl"`
+	// Descriptor describes the instance, for example the address it can be reached at.
+	Descriptor T `json:"descriptor"`
+}
+
+// MembershipEventType describes how the membership of a service changed.
+type MembershipEventType int
+
+const (
+	// InstanceJoined indicates a new instance has registered.
+	InstanceJoined MembershipEventType = iota
+	// InstanceUpdated indicates a registered instance has changed its descriptor.
+	InstanceUpdated
+	// InstanceLeft indicates an instance has deregistered or stopped sending heartbeats.
+	InstanceLeft
+)
+
+// MembershipEvent is delivered when the membership of a watched service changes.
+type MembershipEvent[T any] struct {
+	Type     MembershipEventType
+	Instance ServiceInstance[T]
+}
+
+// RegistryOption configures a Registry.
+type RegistryOption func(c *registryConfig)
+
+type registryConfig struct {
+	prefix    []string
+	ttl       time.Duration
+	heartbeat time.Duration
+	logger    *slog.Logger
+}
+
+// WithRegistryPrefix sets the subject prefix under which instances are stored, defaults to DefaultRegistryPrefix.
+func WithRegistryPrefix(prefix *SubjectBuilder) RegistryOption {
+	return func(c *registryConfig) {
+		c.prefix = append([]string(nil), prefix.elements...)
+	}
+}
+
+// WithRegistryTTL sets how long an instance remains alive without a heartbeat, defaults to DefaultRegistryTTL.
+func WithRegistryTTL(ttl time.Duration) RegistryOption {
+	return func(c *registryConfig) {
+		c.ttl = ttl
+	}
+}
+
+// WithRegistryHeartbeat sets how often registered instances send a heartbeat, defaults to a third of the TTL.
+func WithRegistryHeartbeat(interval time.Duration) RegistryOption {
+	return func(c *registryConfig) {
+		c.heartbeat = interval
+	}
+}
+
+// WithRegistryLogger sets the logger which receives registration and heartbeat events.
+func WithRegistryLogger(logger *slog.Logger) RegistryOption {
+	return func(c *registryConfig) {
+		c.logger = logger
+	}
+}
+
+// Registry records which instances of a service are alive.
+//
+// Each instance is stored under '<prefix>.<service>.<id>' and periodically rewritten as a heartbeat. An instance is
+// considered alive until TTL has passed since its last heartbeat, measured using the timestamp assigned by the
+// server, so instances which crash are expired without needing to be explicitly removed.
+type Registry[T any] struct {
+	kv     KeyValue[ServiceInstance[T]]
+	config registryConfig
+}
+
+// Registration is a registered instance which is sending heartbeats.
+type Registration[T any] struct {
+	registry *Registry[T]
+	key      string
+
+	mu       sync.Mutex
+	instance ServiceInstance[T]
+
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewRegistry creates a Registry which stores instances in the provided bucket. Returns ErrRegistryTTLInvalid unless
+// the TTL is positive and the heartbeat interval is positive and less than the TTL, so that instances send a
+// heartbeat before they expire.
+func NewRegistry[T any](kv KeyValue[ServiceInstance[T]], opts ...RegistryOption) (*Registry[T], error) {
+	config := registryConfig{prefix: []string{DefaultRegistryPrefix}, ttl: DefaultRegistryTTL}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.heartbeat == 0 {
+		config.heartbeat = config.ttl / 3
+	}
+	if config.ttl <= 0 || config.heartbeat <= 0 || config.heartbeat >= config.ttl {
+		return nil, errors.Annotatef(ErrRegistryTTLInvalid, "ttl %v, heartbeat %v", config.ttl, config.heartbeat)
+	}
+	if config.logger == nil {
+		config.logger = DiscardLogger()
+	}
+	config.logger = config.logger.With(slog.String(LogKeyBucket, kv.Bucket()))
+	return &Registry[T]{kv: kv, config: config}, nil
+}
+
+// subject returns a builder containing the prefix followed by the provided elements.
+func (r *Registry[T]) subject(elements ...string) (*SubjectBuilder, error) {
+	sb := &SubjectBuilder{}
+	if err := sb.Push(r.config.prefix...); err != nil {
+		return nil, err
+	}
+	if err := sb.Push(elements...); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// filter returns a filter matching every instance of a service, '<prefix>.<service>.*'.
+func (r *Registry[T]) filter(service string) (string, error) {
+	sb, err := r.subject(service)
+	if err != nil {
+		return "", err
+	}
+	if err := sb.Star(); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// Register stores the instance and starts sending heartbeats for it until it is deregistered.
+func (r *Registry[T]) Register(service string, id string, descriptor T) (*Registration[T], error) {
+	sb, err := r.subject(service, id)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid service %q or id %q", service, id)
+	}
+
+	reg := &Registration[T]{
+		registry: r,
+		key:      sb.String(),
+		instance: ServiceInstance[T]{Service: service, ID: id, TTL: r.config.ttl, Descriptor: descriptor},
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	if _, err := r.kv.Put(reg.key, reg.instance); err != nil {
+		return nil, err
+	}
+	r.config.logger.Info("instance registered", slog.String(LogKeyKey, reg.key))
+
+	go reg.heartbeat()
+
+	return reg, nil
+}
+
+// Discover returns the instances of a service which are currently alive.
+func (r *Registry[T]) Discover(service string) ([]ServiceInstance[T], error) {
+	filter, err := r.filter(service)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid service %q", service)
+	}
+
+	watcher, err := r.kv.Watch(filter, nats.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = watcher.Stop() }()
+
+	var instances []ServiceInstance[T]
+	for entry := range watcher.UpdatesUnmarshalled() {
+		if entry == nil {
+			break
+		}
+		instance, err := entry.UnmarshalValue()
+		if err != nil {
+			// already logged when decoding
+			continue
+		}
+		if time.Now().Before(entry.Created().Add(instance.TTL)) {
+			instances = append(instances, instance)
+		}
+	}
+
+	return instances, nil
+}
+
+// member is the last known state of a watched instance.
+type member[T any] struct {
+	instance ServiceInstance[T]
+	value    []byte
+	expires  time.Time
+}
+
+// Watch returns a channel which receives membership changes for a service, starting with an InstanceJoined event for
+// each instance which is currently alive. Instances which stop sending heartbeats are reported as having left once
+// their TTL has passed. The channel is closed once the context is done.
+func (r *Registry[T]) Watch(ctx context.Context, service string) (<-chan MembershipEvent[T], error) {
+	filter, err := r.filter(service)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid service %q", service)
+	}
+
+	watcher, err := r.kv.Watch(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan MembershipEvent[T], 64)
+
+	go func() {
+		defer close(ch)
+		defer func() { _ = watcher.Stop() }()
+
+		updates := watcher.UpdatesUnmarshalled()
+		members := make(map[string]*member[T])
+
+		timer := time.NewTimer(time.Hour)
+		defer timer.Stop()
+
+		send := func(eventType MembershipEventType, instance ServiceInstance[T]) bool {
+			select {
+			case ch <- MembershipEvent[T]{Type: eventType, Instance: instance}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			// schedule a wake up for the next instance to expire
+			var next time.Time
+			for _, m := range members {
+				if next.IsZero() || m.expires.Before(next) {
+					next = m.expires
+				}
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			if !next.IsZero() {
+				timer.Reset(time.Until(next))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-timer.C:
+				now := time.Now()
+				for key, m := range members {
+					if now.Before(m.expires) {
+						continue
+					}
+					delete(members, key)
+					r.config.logger.Debug("instance expired", slog.String(LogKeyKey, key))
+					if !send(InstanceLeft, m.instance) {
+						return
+					}
+				}
+
+			case entry, ok := <-updates:
+				if !ok {
+					return
+				}
+				if entry == nil {
+					continue
+				}
+
+				existing := members[entry.Key()]
+
+				if entry.Operation() != nats.KeyValuePut {
+					if existing != nil {
+						delete(members, entry.Key())
+						if !send(InstanceLeft, existing.instance) {
+							return
+						}
+					}
+					continue
+				}
+
+				instance, err := entry.UnmarshalValue()
+				if err != nil {
+					// already logged when decoding
+					continue
+				}
+
+				expires := entry.Created().Add(instance.TTL)
+				if !time.Now().Before(expires) {
+					// a stale instance delivered as part of the initial values, the timer handles existing members
+					continue
+				}
+
+				members[entry.Key()] = &member[T]{instance: instance, value: entry.Value(), expires: expires}
+
+				switch {
+				case existing == nil:
+					if !send(InstanceJoined, instance) {
+						return
+					}
+				case !bytes.Equal(existing.value, entry.Value()):
+					// heartbeats rewrite the same value so are not reported
+					if !send(InstanceUpdated, instance) {
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// Prune deletes instances whose TTL has passed since their last heartbeat. Deletes are guarded by revision so an
+// instance which sends a heartbeat concurrently is left intact. The number of instances removed is returned.
+func (r *Registry[T]) Prune(service string) (int, error) {
+	filter, err := r.filter(service)
+	if err != nil {
+		return 0, errors.Annotatef(err, "invalid service %q", service)
+	}
+
+	watcher, err := r.kv.Watch(filter, nats.IgnoreDeletes())
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = watcher.Stop() }()
+
+	pruned := 0
+	for entry := range watcher.UpdatesUnmarshalled() {
+		if entry == nil {
+			break
+		}
+		instance, err := entry.UnmarshalValue()
+		if err != nil || time.Now().Before(entry.Created().Add(instance.TTL)) {
+			continue
+		}
+
+		err = r.kv.Delete(entry.Key(), nats.LastRevision(entry.Revision()))
+		if IsWrongRevision(err) {
+			// a heartbeat arrived in the meantime
+			continue
+		} else if err != nil {
+			return pruned, err
+		}
+
+		r.config.logger.Info("pruned expired instance", slog.String(LogKeyKey, entry.Key()))
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// Key returns the key under which the instance is stored.
+func (reg *Registration[T]) Key() string {
+	return reg.key
+}
+
+// Instance returns the instance as it was last written.
+func (reg *Registration[T]) Instance() ServiceInstance[T] {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.instance
+}
+
+// Update replaces the descriptor of the registered instance.
+func (reg *Registration[T]) Update(descriptor T) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	select {
+	case <-reg.stop:
+		return ErrNotRegistered
+	default:
+	}
+
+	instance := reg.instance
+	instance.Descriptor = descriptor
+	if _, err := reg.registry.kv.Put(reg.key, instance); err != nil {
+		return err
+	}
+	reg.instance = instance
+	return nil
+}
+
+// heartbeat periodically rewrites the instance until deregistered.
+func (reg *Registration[T]) heartbeat() {
+	defer close(reg.stopped)
+
+	logger := reg.registry.config.logger.With(slog.String(LogKeyKey, reg.key))
+
+	ticker := time.NewTicker(reg.registry.config.heartbeat)
+	defer ticker.Stop()
+
+	attempt := 0
+	for {
+		select {
+		case <-reg.stop:
+			return
+		case <-ticker.C:
+		}
+
+		reg.mu.Lock()
+		_, err := reg.registry.kv.Put(reg.key, reg.instance)
+		reg.mu.Unlock()
+
+		if err == nil {
+			attempt = 0
+			continue
+		}
+
+		attempt++
+		logger.Warn("failed to send heartbeat, retrying", slog.Int(LogKeyAttempt, attempt), slog.Any("error", err))
+	}
+}
+
+// Deregister stops sending heartbeats and removes the instance.
+func (reg *Registration[T]) Deregister() error {
+	err := error(ErrNotRegistered)
+	reg.once.Do(func() {
+		reg.mu.Lock()
+		close(reg.stop)
+		reg.mu.Unlock()
+		<-reg.stopped
+
+		err = reg.registry.kv.Delete(reg.key)
+		if err == nil {
+			reg.registry.config.logger.Info("instance deregistered", slog.String(LogKeyKey, reg.key))
+		}
+	})
+	return err
+}
@@ -0,0 +1,228 @@
+package natsutil_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testDescriptor struct {
+	Address string `json:"address"`
+}
+
+func newRegistry(
+	t *testing.T,
+	kv natsutil.KeyValue[natsutil.ServiceInstance[testDescriptor]],
+	opts ...natsutil.RegistryOption,
+) *natsutil.Registry[testDescriptor] {
+	registry, err := natsutil.NewRegistry[testDescriptor](kv, opts...)
+	assert.Nil(t, err)
+	return registry
+}
+
+func nextEvent[T any](t *testing.T, ch <-chan natsutil.MembershipEvent[T]) natsutil.MembershipEvent[T] {
+	t.Helper()
+	select {
+	case event, ok := <-ch:
+		assert.True(t, ok)
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for membership event")
+		return natsutil.MembershipEvent[T]{}
+	}
+}
+
+func TestNewRegistry_InvalidTTL(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.ServiceInstance[testDescriptor]](createTestBucket(t, js), &encoder)
+
+	for _, opts := range [][]natsutil.RegistryOption{
+		{natsutil.WithRegistryTTL(0)},
+		{natsutil.WithRegistryTTL(2 * time.Nanosecond)},
+		{natsutil.WithRegistryTTL(-time.Second)},
+		{natsutil.WithRegistryHeartbeat(-time.Second)},
+		{natsutil.WithRegistryTTL(time.Second), natsutil.WithRegistryHeartbeat(time.Second)},
+		{natsutil.WithRegistryTTL(time.Second), natsutil.WithRegistryHeartbeat(2 * time.Second)},
+	} {
+		_, err := natsutil.NewRegistry[testDescriptor](kv, opts...)
+		assert.ErrorIs(t, err, natsutil.ErrRegistryTTLInvalid)
+	}
+
+	_, err := natsutil.NewRegistry[testDescriptor](kv, natsutil.WithRegistryTTL(time.Second))
+	assert.Nil(t, err)
+}
+
+func TestRegistry_RegisterDiscover(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.ServiceInstance[testDescriptor]](createTestBucket(t, js), &encoder)
+
+	prefix := natsutil.SubjectBuilder{}
+	prefix.MustPush("registry")
+
+	registry := newRegistry(t, kv, natsutil.WithRegistryPrefix(&prefix))
+
+	instances, err := registry.Discover("orders")
+	assert.Nil(t, err)
+	assert.Empty(t, instances)
+
+	a, err := registry.Register("orders", "a", testDescriptor{"10.0.0.1:80"})
+	assert.Nil(t, err)
+	assert.Equal(t, "registry.orders.a", a.Key())
+
+	b, err := registry.Register("orders", "b", testDescriptor{"10.0.0.2:80"})
+	assert.Nil(t, err)
+
+	_, err = registry.Register("payments", "c", testDescriptor{"10.0.0.3:80"})
+	assert.Nil(t, err)
+
+	_, err = registry.Register("invalid service", "d", testDescriptor{})
+	assert.NotNil(t, err)
+
+	instances, err = registry.Discover("orders")
+	assert.Nil(t, err)
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	assert.Equal(t, []natsutil.ServiceInstance[testDescriptor]{
+		{Service: "orders", ID: "a", TTL: natsutil.DefaultRegistryTTL, Descriptor: testDescriptor{"10.0.0.1:80"}},
+		{Service: "orders", ID: "b", TTL: natsutil.DefaultRegistryTTL, Descriptor: testDescriptor{"10.0.0.2:80"}},
+	}, instances)
+
+	assert.Nil(t, b.Update(testDescriptor{"10.0.0.4:80"}))
+	assert.Equal(t, testDescriptor{"10.0.0.4:80"}, b.Instance().Descriptor)
+
+	assert.Nil(t, a.Deregister())
+	assert.ErrorIs(t, a.Deregister(), natsutil.ErrNotRegistered)
+	assert.ErrorIs(t, a.Update(testDescriptor{}), natsutil.ErrNotRegistered)
+
+	instances, err = registry.Discover("orders")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, "b", instances[0].ID)
+	assert.Equal(t, testDescriptor{"10.0.0.4:80"}, instances[0].Descriptor)
+
+	assert.Nil(t, b.Deregister())
+}
+
+func TestRegistry_Heartbeats(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.ServiceInstance[testDescriptor]](createTestBucket(t, js), &encoder)
+
+	registry := newRegistry(t, kv,
+		natsutil.WithRegistryTTL(300*time.Millisecond),
+		natsutil.WithRegistryHeartbeat(50*time.Millisecond),
+	)
+
+	reg, err := registry.Register("orders", "alive", testDescriptor{"10.0.0.1:80"})
+	assert.Nil(t, err)
+
+	// simulate an instance which crashed without deregistering
+	_, err = kv.Put("services.orders.crashed", natsutil.ServiceInstance[testDescriptor]{
+		Service: "orders", ID: "crashed", TTL: 300 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	time.Sleep(600 * time.Millisecond)
+
+	// heartbeats keep the registered instance alive well beyond its ttl
+	instances, err := registry.Discover("orders")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, "alive", instances[0].ID)
+
+	pruned, err := registry.Prune("orders")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, pruned)
+
+	_, err = kv.Get("services.orders.crashed")
+	assert.NotNil(t, err)
+
+	pruned, err = registry.Prune("orders")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, pruned)
+
+	assert.Nil(t, reg.Deregister())
+}
+
+func TestRegistry_Watch(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.ServiceInstance[testDescriptor]](createTestBucket(t, js), &encoder)
+
+	registry := newRegistry(t, kv,
+		natsutil.WithRegistryTTL(500*time.Millisecond),
+		natsutil.WithRegistryHeartbeat(50*time.Millisecond),
+	)
+
+	existing, err := registry.Register("orders", "existing", testDescriptor{"10.0.0.1:80"})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := registry.Watch(ctx, "orders")
+	assert.Nil(t, err)
+
+	event := nextEvent(t, ch)
+	assert.Equal(t, natsutil.InstanceJoined, event.Type)
+	assert.Equal(t, "existing", event.Instance.ID)
+
+	joined, err := registry.Register("orders", "joined", testDescriptor{"10.0.0.2:80"})
+	assert.Nil(t, err)
+
+	event = nextEvent(t, ch)
+	assert.Equal(t, natsutil.InstanceJoined, event.Type)
+	assert.Equal(t, "joined", event.Instance.ID)
+
+	// heartbeats are not reported, only changes to the descriptor
+	time.Sleep(200 * time.Millisecond)
+	assert.Nil(t, joined.Update(testDescriptor{"10.0.0.3:80"}))
+
+	event = nextEvent(t, ch)
+	assert.Equal(t, natsutil.InstanceUpdated, event.Type)
+	assert.Equal(t, testDescriptor{"10.0.0.3:80"}, event.Instance.Descriptor)
+
+	assert.Nil(t, joined.Deregister())
+
+	event = nextEvent(t, ch)
+	assert.Equal(t, natsutil.InstanceLeft, event.Type)
+	assert.Equal(t, "joined", event.Instance.ID)
+
+	// an instance which stops sending heartbeats is expired
+	_, err = kv.Put("services.orders.crashed", natsutil.ServiceInstance[testDescriptor]{
+		Service: "orders", ID: "crashed", TTL: 200 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	event = nextEvent(t, ch)
+	assert.Equal(t, natsutil.InstanceJoined, event.Type)
+	assert.Equal(t, "crashed", event.Instance.ID)
+
+	event = nextEvent(t, ch)
+	assert.Equal(t, natsutil.InstanceLeft, event.Type)
+	assert.Equal(t, "crashed", event.Instance.ID)
+
+	assert.Nil(t, existing.Deregister())
+
+	event = nextEvent(t, ch)
+	assert.Equal(t, natsutil.InstanceLeft, event.Type)
+	assert.Equal(t, "existing", event.Instance.ID)
+
+	cancel()
+	for range ch {
+	}
+}
@@ -0,0 +1,164 @@
+package natsutil
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	ErrNoPermitsAvailable = errors.ConstError("no permits are available")
+	ErrSemaphorePermits   = errors.ConstError("semaphore must have at least one permit")
+)
+
+// SemaphoreOption configures a Semaphore.
+type SemaphoreOption func(s *Semaphore)
+
+// WithSemaphoreLockOptions configures the Lock used to hold each permit, for example to change the lease TTL.
+func WithSemaphoreLockOptions(opts ...LockOption) SemaphoreOption {
+	return func(s *Semaphore) {
+		s.lockOpts = append(s.lockOpts, opts...)
+	}
+}
+
+// WithSemaphoreLogger sets the logger which receives acquisition events, it is also passed to each permit's Lock.
+func WithSemaphoreLogger(logger *slog.Logger) SemaphoreOption {
+	return func(s *Semaphore) {
+		s.logger = logger
+	}
+}
+
+// Semaphore bounds the number of permits which can be held at once across many processes.
+//
+// Each permit is a Lock stored under '<name>.<index>'. A permit is acquired by acquiring any one of these locks,
+// so holders which crash without releasing have their permit reclaimed once its lease expires. Whilst waiting for
+// a permit the permit keys are watched so that a release wakes waiters immediately rather than them polling.
+type Semaphore struct {
+	kv       KeyValue[LockLease]
+	name     string
+	permits  int
+	lockOpts []LockOption
+	logger   *slog.Logger
+}
+
+// Permit is a single permit acquired from a Semaphore.
+type Permit struct {
+	lock  *Lock
+	token uint64
+}
+
+// NewSemaphore creates a Semaphore with the given number of permits, stored under name. Returns
+// ErrSemaphorePermits if permits is less than one, and ErrLockTTLInvalid if the lock options are invalid.
+func NewSemaphore(kv KeyValue[LockLease], name string, permits int, opts ...SemaphoreOption) (*Semaphore, error) {
+	if permits < 1 {
+		return nil, errors.Annotatef(ErrSemaphorePermits, "%d", permits)
+	}
+	s := &Semaphore{kv: kv, name: name, permits: permits}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if _, err := NewLock(kv, s.permitKey(0), s.lockOpts...); err != nil {
+		return nil, err
+	}
+	if s.logger == nil {
+		s.logger = DiscardLogger()
+	} else {
+		// the semaphore logger applies to each lock unless a lock logger has been explicitly configured
+		s.lockOpts = append([]LockOption{WithLockLogger(s.logger)}, s.lockOpts...)
+	}
+	s.logger = s.logger.With(slog.String(LogKeyBucket, kv.Bucket()), slog.String(LogKeyKey, name))
+	return s, nil
+}
+
+// Permits returns the maximum number of permits which can be held at once.
+func (s *Semaphore) Permits() int {
+	return s.permits
+}
+
+func (s *Semaphore) permitKey(idx int) string {
+	return s.name + SubjectSeparator + strconv.Itoa(idx)
+}
+
+// TryAcquire makes a single attempt to acquire a permit, returning ErrNoPermitsAvailable if they are all held.
+func (s *Semaphore) TryAcquire() (*Permit, error) {
+	permit, _, err := s.tryAcquire()
+	return permit, err
+}
+
+// Acquire blocks until a permit has been acquired or the context is done.
+func (s *Semaphore) Acquire(ctx context.Context) (*Permit, error) {
+	var updates <-chan nats.KeyValueEntry
+
+	for attempt := 1; ; attempt++ {
+		permit, expires, err := s.tryAcquire()
+		if !errors.Is(err, ErrNoPermitsAvailable) {
+			return permit, err
+		}
+
+		if updates == nil {
+			watcher, err := s.kv.Watch(s.name+SubjectSeparator+SubjectStar, nats.MetaOnly())
+			if err != nil {
+				return nil, err
+			}
+			defer func() { _ = watcher.Stop() }()
+			updates = watcher.Updates()
+		}
+
+		s.logger.Debug("waiting for permit", slog.Int(LogKeyAttempt, attempt), slog.Time("expires", expires))
+		if err := waitForRelease(ctx, updates, expires); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// tryAcquire attempts each permit in a random order to spread contention. When none are available the time at
+// which the earliest lease expires is returned alongside ErrNoPermitsAvailable.
+func (s *Semaphore) tryAcquire() (*Permit, time.Time, error) {
+	var earliest time.Time
+
+	for _, idx := range rand.Perm(s.permits) {
+		lock, err := NewLock(s.kv, s.permitKey(idx), s.lockOpts...)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		token, expires, err := lock.tryAcquire()
+		if err == nil {
+			s.logger.Debug("permit acquired", slog.String("permit", lock.Key()))
+			return &Permit{lock: lock, token: token}, time.Time{}, nil
+		} else if !errors.Is(err, ErrLockHeld) {
+			return nil, time.Time{}, err
+		}
+
+		if earliest.IsZero() || expires.Before(earliest) {
+			earliest = expires
+		}
+	}
+
+	return nil, earliest, ErrNoPermitsAvailable
+}
+
+// Key returns the key under which the permit's lease is stored.
+func (p *Permit) Key() string {
+	return p.lock.Key()
+}
+
+// Token returns the fencing token of the permit's lease.
+func (p *Permit) Token() uint64 {
+	return p.token
+}
+
+// Done returns a channel which is closed when the permit is released or its lease is lost.
+func (p *Permit) Done() <-chan struct{} {
+	return p.lock.Done()
+}
+
+// Release returns the permit to the semaphore. ErrLockNotHeld is returned if the lease was already lost.
+func (p *Permit) Release() error {
+	return p.lock.Release()
+}
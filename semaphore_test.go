@@ -0,0 +1,165 @@
+package natsutil_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSemaphore_Invalid(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](createTestBucket(t, js), &encoder)
+
+	for _, permits := range []int{0, -1} {
+		_, err := natsutil.NewSemaphore(kv, "api", permits)
+		assert.ErrorIs(t, err, natsutil.ErrSemaphorePermits)
+	}
+
+	_, err := natsutil.NewSemaphore(kv, "api", 1,
+		natsutil.WithSemaphoreLockOptions(natsutil.WithLockTTL(0)))
+	assert.ErrorIs(t, err, natsutil.ErrLockTTLInvalid)
+}
+
+func TestSemaphore_AcquireRelease(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](createTestBucket(t, js), &encoder)
+
+	sem, err := natsutil.NewSemaphore(kv, "api", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, sem.Permits())
+
+	a, err := sem.TryAcquire()
+	assert.Nil(t, err)
+	b, err := sem.TryAcquire()
+	assert.Nil(t, err)
+	assert.NotEqual(t, a.Key(), b.Key())
+	assert.NotEqual(t, a.Token(), b.Token())
+
+	_, err = sem.TryAcquire()
+	assert.ErrorIs(t, err, natsutil.ErrNoPermitsAvailable)
+
+	// a waiter is woken by a release
+	acquired := make(chan *natsutil.Permit)
+	go func() {
+		permit, err := sem.Acquire(context.Background())
+		assert.Nil(t, err)
+		acquired <- permit
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("permit acquired whilst none available")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	done := a.Done()
+	assert.Nil(t, a.Release())
+	<-done
+
+	var c *natsutil.Permit
+	select {
+	case c = <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("permit not acquired after release")
+	}
+	assert.Equal(t, a.Key(), c.Key())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = sem.Acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.Nil(t, b.Release())
+	assert.Nil(t, c.Release())
+	assert.ErrorIs(t, c.Release(), natsutil.ErrLockNotHeld)
+}
+
+func TestSemaphore_CrashedHolders(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[natsutil.LockLease](createTestBucket(t, js), &encoder)
+
+	// both permits are held by holders which crashed without releasing
+	for _, key := range []string{"api.0", "api.1"} {
+		_, err := kv.Create(key, natsutil.LockLease{Owner: "crashed", TTL: 200 * time.Millisecond})
+		assert.Nil(t, err)
+	}
+
+	sem, err := natsutil.NewSemaphore(kv, "api", 2)
+	assert.Nil(t, err)
+
+	_, err = sem.TryAcquire()
+	assert.ErrorIs(t, err, natsutil.ErrNoPermitsAvailable)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	permit, err := sem.Acquire(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, permit.Release())
+}
+
+func TestSemaphore_Contention(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	createTestBucket(t, js)
+
+	const permits = 3
+	const clients = 6
+	const iterations = 5
+
+	var inside, maxInside atomic.Int32
+	var wg sync.WaitGroup
+
+	for i := 0; i < clients; i++ {
+		_, js := jsClient(t, s)
+		bucket, err := js.KeyValue("TestBucket")
+		assert.Nil(t, err)
+		sem, err := natsutil.NewSemaphore(natsutil.NewKeyValue[natsutil.LockLease](bucket, &encoder), "api", permits)
+		assert.Nil(t, err)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				permit, err := sem.Acquire(ctx)
+				cancel()
+				if !assert.Nil(t, err) {
+					return
+				}
+
+				current := inside.Add(1)
+				for {
+					highest := maxInside.Load()
+					if current <= highest || maxInside.CompareAndSwap(highest, current) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				inside.Add(-1)
+
+				assert.Nil(t, permit.Release())
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.LessOrEqual(t, maxInside.Load(), int32(permits))
+}
@@ -0,0 +1,202 @@
+package natsutil
+
+import (
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+)
+
+// Set is a set of elements stored in a bucket whose operations are atomic with respect to other writers.
+type Set[E comparable] interface {
+	// Add adds the element to the set, reporting whether it was not already present.
+	Add(element E) (bool, error)
+	// Remove removes the element from the set, reporting whether it was present.
+	Remove(element E) (bool, error)
+	// Contains reports whether the element is present.
+	Contains(element E) (bool, error)
+	// Range calls fn with each element of a consistent snapshot of the set until fn returns false.
+	Range(fn func(element E) bool) error
+	// Len returns the number of elements in the set.
+	Len() (int, error)
+}
+
+// valueSet stores every element in a single value, which is modified with compare-and-swap updates.
+type valueSet[E comparable] struct {
+	kv     KeyValue[[]E]
+	key    string
+	config collectionConfig
+}
+
+// NewSet creates a Set stored as a single value under key. Every modification rewrites the whole set, so this suits
+// small sets. Elements are kept in the order they were added.
+func NewSet[E comparable](kv KeyValue[[]E], key string, opts ...CollectionOption) Set[E] {
+	return &valueSet[E]{kv: kv, key: key, config: newCollectionConfig(kv.Bucket(), key, opts)}
+}
+
+func (s *valueSet[E]) Add(element E) (bool, error) {
+	_, _, err := casUpdate[[]E](s.kv, s.key, s.config.maxRetries, s.config.logger,
+		func(elements []E, _ bool) ([]E, error) {
+			for _, e := range elements {
+				if e == element {
+					return nil, errUnchanged
+				}
+			}
+			return append(elements, element), nil
+		})
+	if errors.Is(err, errUnchanged) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *valueSet[E]) Remove(element E) (bool, error) {
+	_, _, err := casUpdate[[]E](s.kv, s.key, s.config.maxRetries, s.config.logger,
+		func(elements []E, _ bool) ([]E, error) {
+			for idx, e := range elements {
+				if e == element {
+					return append(elements[:idx:idx], elements[idx+1:]...), nil
+				}
+			}
+			return nil, errUnchanged
+		})
+	if errors.Is(err, errUnchanged) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *valueSet[E]) elements() ([]E, error) {
+	entry, err := s.kv.Get(s.key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	elements, err := entry.UnmarshalValue()
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to decode value for key %q", s.key)
+	}
+	return elements, nil
+}
+
+func (s *valueSet[E]) Contains(element E) (bool, error) {
+	elements, err := s.elements()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range elements {
+		if e == element {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *valueSet[E]) Range(fn func(element E) bool) error {
+	elements, err := s.elements()
+	if err != nil {
+		return err
+	}
+	for _, e := range elements {
+		if !fn(e) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *valueSet[E]) Len() (int, error) {
+	elements, err := s.elements()
+	return len(elements), err
+}
+
+// keyedSet stores each element under its own key.
+type keyedSet[E comparable] struct {
+	kv     KeyValue[E]
+	prefix string
+	token  func(element E) string
+	config collectionConfig
+}
+
+// NewKeyedSet creates a Set which stores each element under '<prefix>.<token>', where token maps an element to a
+// single, valid subject token. Elements are modified independently, so large sets and many concurrent writers are
+// supported, and Range uses the bucket revision to provide a consistent snapshot.
+func NewKeyedSet[E comparable](
+	kv KeyValue[E],
+	prefix string,
+	token func(element E) string,
+	opts ...CollectionOption,
+) Set[E] {
+	return &keyedSet[E]{kv: kv, prefix: prefix, token: token, config: newCollectionConfig(kv.Bucket(), prefix, opts)}
+}
+
+func (s *keyedSet[E]) Add(element E) (bool, error) {
+	key, err := elementKey(s.prefix, s.token(element))
+	if err != nil {
+		return false, err
+	}
+	_, err = s.kv.Create(key, element)
+	if IsWrongRevision(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *keyedSet[E]) Remove(element E) (bool, error) {
+	key, err := elementKey(s.prefix, s.token(element))
+	if err != nil {
+		return false, err
+	}
+	return removeKey(s.kv, key, s.config)
+}
+
+func (s *keyedSet[E]) Contains(element E) (bool, error) {
+	key, err := elementKey(s.prefix, s.token(element))
+	if err != nil {
+		return false, err
+	}
+	_, err = s.kv.Get(key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *keyedSet[E]) Range(fn func(element E) bool) error {
+	return rangeSnapshot[E](s.kv, s.prefix, func(entry KeyValueEntry[E]) (bool, error) {
+		element, err := entry.UnmarshalValue()
+		if err != nil {
+			return false, errors.Annotatef(err, "failed to decode value for key %q", entry.Key())
+		}
+		return fn(element), nil
+	})
+}
+
+func (s *keyedSet[E]) Len() (int, error) {
+	count := 0
+	err := rangeSnapshot[E](s.kv, s.prefix, func(KeyValueEntry[E]) (bool, error) {
+		count++
+		return true, nil
+	})
+	return count, err
+}
+
+// removeKey deletes key if it exists, reporting whether it did. The delete is made against the revision which was
+// read, so that it is retried if the key is concurrently modified.
+func removeKey[T any](kv KeyValue[T], key string, config collectionConfig) (bool, error) {
+	for attempt := 1; attempt <= config.maxRetries; attempt++ {
+		entry, err := kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		err = kv.Delete(key, nats.LastRevision(entry.Revision()))
+		if err == nil {
+			return true, nil
+		} else if !IsWrongRevision(err) {
+			return false, err
+		}
+	}
+	return false, ErrTooManyRetries
+}
@@ -0,0 +1,165 @@
+package natsutil_test
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func identity(element string) string {
+	return element
+}
+
+func collect[E comparable](t *testing.T, set natsutil.Set[E]) []E {
+	t.Helper()
+	var elements []E
+	assert.Nil(t, set.Range(func(element E) bool {
+		elements = append(elements, element)
+		return true
+	}))
+	return elements
+}
+
+func testSet(t *testing.T, set natsutil.Set[string]) {
+	added, err := set.Add("a")
+	assert.Nil(t, err)
+	assert.True(t, added)
+
+	added, err = set.Add("a")
+	assert.Nil(t, err)
+	assert.False(t, added)
+
+	added, err = set.Add("b")
+	assert.Nil(t, err)
+	assert.True(t, added)
+
+	contains, err := set.Contains("a")
+	assert.Nil(t, err)
+	assert.True(t, contains)
+
+	contains, err = set.Contains("c")
+	assert.Nil(t, err)
+	assert.False(t, contains)
+
+	length, err := set.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, length)
+
+	elements := collect(t, set)
+	sort.Strings(elements)
+	assert.Equal(t, []string{"a", "b"}, elements)
+
+	removed, err := set.Remove("a")
+	assert.Nil(t, err)
+	assert.True(t, removed)
+
+	removed, err = set.Remove("a")
+	assert.Nil(t, err)
+	assert.False(t, removed)
+
+	// an element can be added again once removed
+	added, err = set.Add("a")
+	assert.Nil(t, err)
+	assert.True(t, added)
+
+	// concurrent additions are not lost
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			added, err := set.Add("e" + strconv.Itoa(i))
+			assert.Nil(t, err)
+			assert.True(t, added)
+		}(i)
+	}
+	wg.Wait()
+
+	length, err = set.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 12, length)
+
+	// iteration stops early
+	count := 0
+	assert.Nil(t, set.Range(func(string) bool {
+		count++
+		return count < 3
+	}))
+	assert.Equal(t, 3, count)
+}
+
+func TestSet(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[[]string](createTestBucket(t, js), &encoder)
+
+	set := natsutil.NewSet[string](kv, "sets.tags")
+	testSet(t, set)
+
+	// elements are kept in the order they were added
+	ordered := natsutil.NewSet[string](kv, "sets.ordered")
+	for _, element := range []string{"c", "a", "b"} {
+		_, err := ordered.Add(element)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, []string{"c", "a", "b"}, collect(t, ordered))
+}
+
+func TestKeyedSet(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[string](createTestBucket(t, js), &encoder)
+
+	set := natsutil.NewKeyedSet[string](kv, "sets.tags", identity)
+	testSet(t, set)
+
+	_, err := kv.Get("sets.tags.a")
+	assert.Nil(t, err)
+
+	_, err = set.Add("invalid element")
+	assert.NotNil(t, err)
+}
+
+func TestKeyedSet_ConsistentRange(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv := natsutil.NewKeyValue[string](createTestBucket(t, js), &encoder)
+
+	set := natsutil.NewKeyedSet[string](kv, "sets.tags", identity)
+	for _, element := range []string{"a", "b", "c"} {
+		_, err := set.Add(element)
+		assert.Nil(t, err)
+	}
+
+	// modifications made whilst iterating are not observed
+	var elements []string
+	assert.Nil(t, set.Range(func(element string) bool {
+		if len(elements) == 0 {
+			_, err := set.Remove("b")
+			assert.Nil(t, err)
+			_, err = set.Remove("c")
+			assert.Nil(t, err)
+			_, err = set.Add("d")
+			assert.Nil(t, err)
+		}
+		elements = append(elements, element)
+		return true
+	}))
+	sort.Strings(elements)
+	assert.Equal(t, []string{"a", "b", "c"}, elements)
+
+	elements = collect(t, set)
+	sort.Strings(elements)
+	assert.Equal(t, []string{"a", "d"}, elements)
+}
@@ -0,0 +1,211 @@
+package natsutil
+
+import (
+	"hash/fnv"
+	"strings"
+)
+
+// Subject is an immutable subject name. Unlike a SubjectBuilder, operations return new values rather than modifying
+// the subject, so a Subject can be shared between goroutines and used as a base for others without aliasing.
+//
+// Subjects are comparable with == and can be used as map keys. The profile is part of the value, so subjects with the
+// same tokens but different profiles are not equal. The zero value is the empty subject with SubjectProfileStrict.
+type Subject struct {
+	value   string
+	profile SubjectProfile
+}
+
+// NewSubject creates a subject from the tokens, validating them against SubjectProfileStrict.
+func NewSubject(tokens ...string) (Subject, error) {
+	return Subject{}.Append(tokens...)
+}
+
+// MustNewSubject is a variant of NewSubject which panics if an error is returned.
+func MustNewSubject(tokens ...string) Subject {
+	return Subject{}.MustAppend(tokens...)
+}
+
+// Subject returns an immutable copy of the subject under construction.
+func (b *SubjectBuilder) Subject() Subject {
+	return Subject{value: b.String(), profile: b.profile}
+}
+
+// Builder returns a SubjectBuilder holding a copy of the subject's tokens, for making many modifications at once.
+func (s Subject) Builder() *SubjectBuilder {
+	return &SubjectBuilder{elements: s.Tokens(), profile: s.profile}
+}
+
+// String returns the subject name.
+func (s Subject) String() string {
+	return s.value
+}
+
+// Profile returns the profile tokens are validated against.
+func (s Subject) Profile() SubjectProfile {
+	return s.profile
+}
+
+// IsEmpty reports whether the subject has no tokens.
+func (s Subject) IsEmpty() bool {
+	return s.value == ""
+}
+
+// Len returns the number of tokens in the subject.
+func (s Subject) Len() int {
+	if s.value == "" {
+		return 0
+	}
+	return strings.Count(s.value, SubjectSeparator) + 1
+}
+
+// Tokens returns the tokens of the subject.
+func (s Subject) Tokens() []string {
+	if s.value == "" {
+		return nil
+	}
+	return TokenizeSubject(s.value)
+}
+
+// Token returns the token at index, which must be less than Len.
+func (s Subject) Token(index int) string {
+	return s.Tokens()[index]
+}
+
+// Last returns the final token of the subject, or an empty string if it has none.
+func (s Subject) Last() string {
+	return s.value[strings.LastIndex(s.value, SubjectSeparator)+1:]
+}
+
+// IsWildcard reports whether the subject contains a '*' or '>' wildcard.
+func (s Subject) IsWildcard() bool {
+	for _, token := range s.Tokens() {
+		if token == SubjectStar || token == SubjectChevron {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLiteral reports whether the subject contains no wildcards, and so can be published to.
+func (s Subject) IsLiteral() bool {
+	return !s.IsWildcard()
+}
+
+// Append returns a new subject with the tokens appended. Wildcards cannot be appended, use Star and Chevron instead.
+// If any token is invalid a *SubjectTokenError is returned.
+func (s Subject) Append(tokens ...string) (Subject, error) {
+	if len(tokens) == 0 {
+		return s, nil
+	}
+	if err := s.checkNotTerminated(tokens[0]); err != nil {
+		return s, err
+	}
+	for _, token := range tokens {
+		if err := ValidateSubjectToken(token, s.profile); err != nil {
+			return s, err
+		}
+	}
+	return s.with(strings.Join(tokens, SubjectSeparator)), nil
+}
+
+// MustAppend is a variant of Append which panics if an error is returned.
+func (s Subject) MustAppend(tokens ...string) Subject {
+	subject, err := s.Append(tokens...)
+	if err != nil {
+		panic(err)
+	}
+	return subject
+}
+
+// Child returns a new subject with a single token appended.
+func (s Subject) Child(token string) (Subject, error) {
+	return s.Append(token)
+}
+
+// Parent returns a new subject without the final token. The parent of the empty subject is the empty subject.
+func (s Subject) Parent() Subject {
+	idx := strings.LastIndex(s.value, SubjectSeparator)
+	if idx < 0 {
+		return Subject{profile: s.profile}
+	}
+	return Subject{value: s.value[:idx], profile: s.profile}
+}
+
+// Star returns a new subject with a '*' wildcard appended. Returns a *SubjectTokenError wrapping
+// ErrSubjectAfterChevron if the subject already ends with '>'.
+func (s Subject) Star() (Subject, error) {
+	if err := s.checkNotTerminated(SubjectStar); err != nil {
+		return s, err
+	}
+	return s.with(SubjectStar), nil
+}
+
+// MustStar is a variant of Star which panics if an error is returned.
+func (s Subject) MustStar() Subject {
+	subject, err := s.Star()
+	if err != nil {
+		panic(err)
+	}
+	return subject
+}
+
+// Chevron returns a new subject with a '>' wildcard appended. Returns a *SubjectTokenError wrapping
+// ErrSubjectAfterChevron if the subject already ends with '>'.
+func (s Subject) Chevron() (Subject, error) {
+	if err := s.checkNotTerminated(SubjectChevron); err != nil {
+		return s, err
+	}
+	return s.with(SubjectChevron), nil
+}
+
+// MustChevron is a variant of Chevron which panics if an error is returned.
+func (s Subject) MustChevron() Subject {
+	subject, err := s.Chevron()
+	if err != nil {
+		panic(err)
+	}
+	return subject
+}
+
+// checkNotTerminated returns an error if the subject ends with '>', since no token can follow it.
+func (s Subject) checkNotTerminated(token string) error {
+	if s.Last() == SubjectChevron {
+		return &SubjectTokenError{Token: token, Position: -1, Profile: s.profile, Reason: ErrSubjectAfterChevron}
+	}
+	return nil
+}
+
+func (s Subject) with(suffix string) Subject {
+	if s.value == "" {
+		return Subject{value: suffix, profile: s.profile}
+	}
+	return Subject{value: s.value + SubjectSeparator + suffix, profile: s.profile}
+}
+
+// HasPrefix reports whether the leading tokens of the subject are those of prefix.
+func (s Subject) HasPrefix(prefix Subject) bool {
+	return prefix.value == "" || s.value == prefix.value ||
+		strings.HasPrefix(s.value, prefix.value+SubjectSeparator)
+}
+
+// Match reports whether the subject matches pattern, see MatchSubject.
+func (s Subject) Match(pattern Subject) bool {
+	return MatchSubject(s.value, pattern.value)
+}
+
+// Equal reports whether the subjects have the same tokens, regardless of their profiles.
+func (s Subject) Equal(other Subject) bool {
+	return s.value == other.value
+}
+
+// Compare orders subjects by their names, returning -1, 0 or +1.
+func (s Subject) Compare(other Subject) int {
+	return strings.Compare(s.value, other.value)
+}
+
+// Hash returns the 64-bit FNV-1a hash of the subject name, which is stable across processes.
+func (s Subject) Hash() uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s.value))
+	return h.Sum64()
+}
@@ -1,7 +1,6 @@
 package natsutil
 
 import (
-	"regexp"
 	"strings"
 
 	"github.com/juju/errors"
@@ -13,27 +12,40 @@ const (
 	SubjectChevron   = ">"
 )
 
-const (
-	ErrSubjectInvalidCharacters = errors.ConstError("subject component must only contain [a-zA-Z0-9_]")
-	ErrPopInsufficientElements  = errors.ConstError("cannot pop more elements than have already been pushed")
-)
-
-// no fancy characters allowed, just simple alphanumeric
-var elementRegex = regexp.MustCompile("\\w+")
+const ErrPopInsufficientElements = errors.ConstError("cannot pop more elements than have already been pushed")
 
 // SubjectBuilder helps with constructing valid nats subject names.
+//
+// Tokens are validated against a SubjectProfile, the zero value uses SubjectProfileStrict.
 type SubjectBuilder struct {
 	elements []string
+	profile  SubjectProfile
+}
+
+// NewSubjectBuilder creates a SubjectBuilder which validates tokens against the given profile.
+func NewSubjectBuilder(profile SubjectProfile) *SubjectBuilder {
+	return &SubjectBuilder{profile: profile}
 }
 
-// Push takes the provided component and appends them to the subject under construction
+// Profile returns the profile tokens are validated against.
+func (b *SubjectBuilder) Profile() SubjectProfile {
+	return b.profile
+}
+
+// Push takes the provided component and appends them to the subject under construction. Wildcards cannot be pushed,
+// use Star and Chevron instead. If any element is invalid a *SubjectTokenError is returned and none are appended.
 func (b *SubjectBuilder) Push(elements ...string) error {
+	if len(elements) > 0 {
+		if err := b.checkNotTerminated(elements[0]); err != nil {
+			return err
+		}
+	}
 	for _, elem := range elements {
-		if !elementRegex.MatchString(elem) {
-			return ErrSubjectInvalidCharacters
+		if err := ValidateSubjectToken(elem, b.profile); err != nil {
+			return err
 		}
-		b.elements = append(b.elements, elem)
 	}
+	b.elements = append(b.elements, elements...)
 	return nil
 }
 
@@ -63,14 +75,32 @@ func (b *SubjectBuilder) MustPop(count int) *SubjectBuilder {
 	return b
 }
 
-// Star appends a '*' wildcard to the subject under construction, prepending a separator as needed.
-func (b *SubjectBuilder) Star() {
+// Star appends a '*' wildcard to the subject under construction, prepending a separator as needed. Returns a
+// *SubjectTokenError wrapping ErrSubjectAfterChevron and leaves the subject unchanged if it already ends with '>'.
+func (b *SubjectBuilder) Star() error {
+	if err := b.checkNotTerminated(SubjectStar); err != nil {
+		return err
+	}
 	b.elements = append(b.elements, SubjectStar)
+	return nil
 }
 
-// Chevron appends a '>' wildcard to the subject under construction, prepending a separator as needed.
-func (b *SubjectBuilder) Chevron() {
+// Chevron appends a '>' wildcard to the subject under construction, prepending a separator as needed. Returns a
+// *SubjectTokenError wrapping ErrSubjectAfterChevron and leaves the subject unchanged if it already ends with '>'.
+func (b *SubjectBuilder) Chevron() error {
+	if err := b.checkNotTerminated(SubjectChevron); err != nil {
+		return err
+	}
 	b.elements = append(b.elements, SubjectChevron)
+	return nil
+}
+
+// checkNotTerminated returns an error if the subject ends with '>', since no token can follow it.
+func (b *SubjectBuilder) checkNotTerminated(token string) error {
+	if len(b.elements) > 0 && b.elements[len(b.elements)-1] == SubjectChevron {
+		return &SubjectTokenError{Token: token, Position: -1, Profile: b.profile, Reason: ErrSubjectAfterChevron}
+	}
+	return nil
 }
 
 // String outputs the subject that has been constructed so far.
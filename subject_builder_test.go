@@ -1,6 +1,7 @@
 package natsutil_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/41north/natsutil.go"
@@ -44,16 +45,83 @@ func TestSubjectBuilder_InvalidCharacters(t *testing.T) {
 	assert.Nil(t, sb.Push("foo"))
 	assert.Nil(t, sb.Push("BAR"))
 	assert.Nil(t, sb.Push("hell0_wor1d"))
+	assert.Nil(t, sb.Push("us-east-1", "a=b", "c/d"))
+
+	assert.Equal(t, "foo.BAR.hell0_wor1d.us-east-1.a=b.c/d", sb.String())
+	assert.Equal(t, natsutil.SubjectProfileStrict, sb.Profile())
+
+	for token, reason := range map[string]error{
+		"":     natsutil.ErrSubjectTokenEmpty,
+		"%":    natsutil.ErrSubjectInvalidCharacters,
+		"foo%": natsutil.ErrSubjectInvalidCharacters,
+		"{":    natsutil.ErrSubjectInvalidCharacters,
+		"}":    natsutil.ErrSubjectInvalidCharacters,
+		"(":    natsutil.ErrSubjectInvalidCharacters,
+		")":    natsutil.ErrSubjectInvalidCharacters,
+		"+":    natsutil.ErrSubjectInvalidCharacters,
+		"café": natsutil.ErrSubjectInvalidCharacters,
+		"a b":  natsutil.ErrSubjectTokenWhitespace,
+		"a\tb": natsutil.ErrSubjectTokenWhitespace,
+		"a.b":  natsutil.ErrSubjectTokenSeparator,
+		"*":    natsutil.ErrSubjectTokenWildcard,
+		">":    natsutil.ErrSubjectTokenWildcard,
+		"foo*": natsutil.ErrSubjectTokenWildcard,
+		"foo>": natsutil.ErrSubjectTokenWildcard,
+	} {
+		assert.ErrorIs(t, sb.Push(token), reason, "token %q", token)
+	}
+
+	// nothing is pushed if any element is invalid
+	assert.NotNil(t, sb.Push("valid", "in valid"))
+	assert.Equal(t, "foo.BAR.hell0_wor1d.us-east-1.a=b.c/d", sb.String())
+}
+
+func TestSubjectBuilder_PermissiveProfile(t *testing.T) {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfilePermissive)
+
+	assert.Nil(t, sb.Push("foo%", "café", "{id}", "a+b"))
+	assert.Equal(t, "foo%.café.{id}.a+b", sb.String())
+
+	for token, reason := range map[string]error{
+		"":      natsutil.ErrSubjectTokenEmpty,
+		"a b":   natsutil.ErrSubjectTokenWhitespace,
+		"a\nb":  natsutil.ErrSubjectTokenWhitespace,
+		"a\x00": natsutil.ErrSubjectInvalidCharacters,
+		"a.b":   natsutil.ErrSubjectTokenSeparator,
+		"*":     natsutil.ErrSubjectTokenWildcard,
+		"a>":    natsutil.ErrSubjectTokenWildcard,
+	} {
+		assert.ErrorIs(t, sb.Push(token), reason, "token %q", token)
+	}
+}
+
+func TestSubjectBuilder_Chevron(t *testing.T) {
+	sb := natsutil.SubjectBuilder{}
+	sb.MustPush("foo")
+	assert.Nil(t, sb.Chevron())
+
+	// nothing can follow a chevron, including more wildcards
+	assert.ErrorIs(t, sb.Push("bar"), natsutil.ErrSubjectAfterChevron)
+	assert.ErrorIs(t, sb.Star(), natsutil.ErrSubjectAfterChevron)
+	assert.ErrorIs(t, sb.Chevron(), natsutil.ErrSubjectAfterChevron)
+	assert.Equal(t, "foo.>", sb.String())
+}
+
+func TestSubjectTokenError(t *testing.T) {
+	err := natsutil.ValidateSubjectToken("prod env", natsutil.SubjectProfileStrict)
+
+	var tokenErr *natsutil.SubjectTokenError
+	assert.True(t, errors.As(err, &tokenErr))
+	assert.Equal(t, "prod env", tokenErr.Token)
+	assert.Equal(t, 4, tokenErr.Position)
+	assert.Equal(t, natsutil.ErrSubjectTokenWhitespace, tokenErr.Reason)
+	assert.Equal(t,
+		`invalid subject token "prod env": subject token contains whitespace: ' ' at position 4 (strict profile)`,
+		err.Error(),
+	)
+
+	err = natsutil.ValidateSubjectToken("", natsutil.SubjectProfileStrict)
+	assert.Equal(t, `invalid subject token "": subject token is empty`, err.Error())
 
-	assert.Equal(t, "foo.BAR.hell0_wor1d", sb.String())
-
-	assert.Error(t, natsutil.ErrSubjectInvalidCharacters, sb.Push("%"))
-	assert.Error(t, natsutil.ErrSubjectInvalidCharacters, sb.Push("-"))
-	assert.Error(t, natsutil.ErrSubjectInvalidCharacters, sb.Push("*"))
-	assert.Error(t, natsutil.ErrSubjectInvalidCharacters, sb.Push(">"))
-	assert.Error(t, natsutil.ErrSubjectInvalidCharacters, sb.Push("{"))
-	assert.Error(t, natsutil.ErrSubjectInvalidCharacters, sb.Push("}"))
-	assert.Error(t, natsutil.ErrSubjectInvalidCharacters, sb.Push("("))
-	assert.Error(t, natsutil.ErrSubjectInvalidCharacters, sb.Push(")"))
-	assert.Error(t, natsutil.ErrSubjectInvalidCharacters, sb.Push("+"))
+	assert.Nil(t, natsutil.ValidateSubjectToken("us-east-1", natsutil.SubjectProfileStrict))
 }
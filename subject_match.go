@@ -0,0 +1,89 @@
+package natsutil
+
+// MatchSubject reports whether subject matches pattern, where '*' in the pattern matches any single token and '>'
+// matches one or more trailing tokens. The subject is expected to be literal, any wildcards within it are compared as
+// ordinary tokens.
+func MatchSubject(subject, pattern string) bool {
+	return matchTokens(TokenizeSubject(subject), TokenizeSubject(pattern))
+}
+
+// SubjectsOverlap reports whether there is a literal subject which matches both a and b, for example when checking
+// whether two subscriptions can receive the same message.
+func SubjectsOverlap(a, b string) bool {
+	return overlapTokens(TokenizeSubject(a), TokenizeSubject(b))
+}
+
+// IsSubjectSubsetOf reports whether every literal subject which matches a also matches b, for example when checking
+// that a watch filter is permitted by a broader filter.
+func IsSubjectSubsetOf(a, b string) bool {
+	return subsetTokens(TokenizeSubject(a), TokenizeSubject(b))
+}
+
+// Match reports whether the subject under construction matches pattern, see MatchSubject.
+func (b *SubjectBuilder) Match(pattern *SubjectBuilder) bool {
+	return matchTokens(b.elements, pattern.elements)
+}
+
+// Overlaps reports whether there is a literal subject which matches both the subject under construction and other,
+// see SubjectsOverlap.
+func (b *SubjectBuilder) Overlaps(other *SubjectBuilder) bool {
+	return overlapTokens(b.elements, other.elements)
+}
+
+// IsSubsetOf reports whether every literal subject which matches the subject under construction also matches other,
+// see IsSubjectSubsetOf.
+func (b *SubjectBuilder) IsSubsetOf(other *SubjectBuilder) bool {
+	return subsetTokens(b.elements, other.elements)
+}
+
+func matchTokens(subject, pattern []string) bool {
+	for i, p := range pattern {
+		if i >= len(subject) {
+			return false
+		}
+		switch p {
+		case SubjectChevron:
+			return true
+		case SubjectStar:
+		default:
+			if subject[i] != p {
+				return false
+			}
+		}
+	}
+	return len(subject) == len(pattern)
+}
+
+func overlapTokens(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		switch {
+		case a[i] == SubjectChevron || b[i] == SubjectChevron:
+			// both have at least one more token which the chevron can consume along with the remainder
+			return true
+		case a[i] == SubjectStar || b[i] == SubjectStar:
+		case a[i] != b[i]:
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+func subsetTokens(a, b []string) bool {
+	for i, t := range b {
+		if i >= len(a) {
+			return false
+		}
+		switch {
+		case t == SubjectChevron:
+			return true
+		case a[i] == SubjectChevron:
+			// only a chevron covers a chevron
+			return false
+		case t == SubjectStar:
+		case a[i] != t:
+			// also covers a[i] being a star where b is literal
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
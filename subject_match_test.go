@@ -0,0 +1,207 @@
+package natsutil_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+	"github.com/nats-io/nats-server/v2/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		pattern string
+		match   bool
+	}{
+		{"orders.eu.created", "orders.eu.created", true},
+		{"orders.eu.created", "orders.*.created", true},
+		{"orders.eu.created", "orders.>", true},
+		{"orders.eu.created", ">", true},
+		{"orders.eu.created", "*.*.*", true},
+		{"orders.eu.created", "orders.*", false},
+		{"orders.eu.created", "orders.us.created", false},
+		{"orders.eu.created", "orders.eu.created.>", false},
+		{"orders", "orders.>", false},
+		{"orders", "orders.*", false},
+		{"orders.eu", "orders", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.match, natsutil.MatchSubject(test.subject, test.pattern), "%s ~ %s", test.subject, test.pattern)
+
+		subject := natsutil.MustParseSubject(test.subject, natsutil.SubjectProfileStrict)
+		pattern := natsutil.MustParseSubject(test.pattern, natsutil.SubjectProfileStrict)
+		assert.Equal(t, test.match, subject.Match(pattern), "%s ~ %s", test.subject, test.pattern)
+	}
+}
+
+func TestSubjectsOverlap(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		overlap bool
+	}{
+		{"orders.eu.created", "orders.eu.created", true},
+		{"orders.*.created", "orders.eu.*", true},
+		{"orders.>", "*.eu.created", true},
+		{"orders.>", ">", true},
+		{"orders.*", "*.>", true},
+		{"orders.*", "orders.*.*", false},
+		{"orders.>", "orders", false},
+		{"orders.*.created", "orders.*.deleted", false},
+		{"orders.eu.>", "orders.us.>", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.overlap, natsutil.SubjectsOverlap(test.a, test.b), "%s ~ %s", test.a, test.b)
+		assert.Equal(t, test.overlap, natsutil.SubjectsOverlap(test.b, test.a), "%s ~ %s", test.b, test.a)
+
+		a := natsutil.MustParseSubject(test.a, natsutil.SubjectProfileStrict)
+		b := natsutil.MustParseSubject(test.b, natsutil.SubjectProfileStrict)
+		assert.Equal(t, test.overlap, a.Overlaps(b), "%s ~ %s", test.a, test.b)
+	}
+}
+
+func TestIsSubjectSubsetOf(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		subset bool
+	}{
+		{"orders.eu.created", "orders.eu.created", true},
+		{"orders.eu.created", "orders.*.created", true},
+		{"orders.*.created", "orders.*.created", true},
+		{"orders.*.created", "orders.>", true},
+		{"orders.eu.>", "orders.>", true},
+		{"orders.>", "orders.>", true},
+		{"orders.>", ">", true},
+		{"orders.*", "orders.>", true},
+		{"orders.>", "orders.*", false},
+		{"orders.*.created", "orders.eu.created", false},
+		{"orders.>", "orders.eu.>", false},
+		{">", "orders.>", false},
+		{"orders", "orders.>", false},
+		{"orders.eu", "orders", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.subset, natsutil.IsSubjectSubsetOf(test.a, test.b), "%s ⊆ %s", test.a, test.b)
+
+		a := natsutil.MustParseSubject(test.a, natsutil.SubjectProfileStrict)
+		b := natsutil.MustParseSubject(test.b, natsutil.SubjectProfileStrict)
+		assert.Equal(t, test.subset, a.IsSubsetOf(b), "%s ⊆ %s", test.a, test.b)
+	}
+}
+
+func addSubjectSeeds(f *testing.F) {
+	seeds := []string{"foo", "foo.bar", "foo.*", "*.bar", "foo.>", ">", "*", "*.*", "foo.*.baz", "foo.bar.>", "bar.*.>"}
+	for _, a := range seeds {
+		for _, b := range seeds {
+			f.Add(a, b)
+		}
+	}
+}
+
+// collidable reports whether the subjects are valid and can be checked against server.SubjectsCollide, which treats
+// any token starting with a wildcard character as a wildcard rather than only whole token wildcards.
+func collidable(subjects ...string) bool {
+	for _, subject := range subjects {
+		if !server.IsValidSubject(subject) {
+			return false
+		}
+		for _, token := range natsutil.TokenizeSubject(subject) {
+			if len(token) > 1 && strings.ContainsAny(token[:1], natsutil.SubjectStar+natsutil.SubjectChevron) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func FuzzMatchSubject(f *testing.F) {
+	addSubjectSeeds(f)
+	f.Fuzz(func(t *testing.T, subject, pattern string) {
+		if !server.IsValidLiteralSubject(subject) || !collidable(subject, pattern) {
+			t.Skip()
+		}
+		// a literal subject collides with a pattern iff the pattern matches it
+		expected := server.SubjectsCollide(subject, pattern)
+		assert.Equal(t, expected, natsutil.MatchSubject(subject, pattern), "%s ~ %s", subject, pattern)
+	})
+}
+
+func FuzzSubjectsOverlap(f *testing.F) {
+	addSubjectSeeds(f)
+	f.Fuzz(func(t *testing.T, a, b string) {
+		if !collidable(a, b) {
+			t.Skip()
+		}
+		expected := server.SubjectsCollide(a, b)
+		assert.Equal(t, expected, natsutil.SubjectsOverlap(a, b), "%s ~ %s", a, b)
+	})
+}
+
+func FuzzIsSubjectSubsetOf(f *testing.F) {
+	addSubjectSeeds(f)
+	f.Fuzz(func(t *testing.T, a, b string) {
+		if !collidable(a, b) {
+			t.Skip()
+		}
+		tokensA, tokensB := natsutil.TokenizeSubject(a), natsutil.TokenizeSubject(b)
+		if len(tokensA) > 3 || len(tokensB) > 3 {
+			// keeps the number of witnesses small
+			t.Skip()
+		}
+
+		// Whether a literal subject matches a or b depends only on the length of the subject and which of their tokens
+		// it is equal to, so if a is not a subset of b there is a witness no longer than either plus one, made up of
+		// their literal tokens and a token which is in neither.
+		alphabet := map[string]struct{}{}
+		for _, token := range append(tokensA, tokensB...) {
+			if token != natsutil.SubjectStar && token != natsutil.SubjectChevron {
+				alphabet[token] = struct{}{}
+			}
+		}
+		fresh := "fresh"
+		for _, ok := alphabet[fresh]; ok; _, ok = alphabet[fresh] {
+			fresh += "_"
+		}
+		alphabet[fresh] = struct{}{}
+
+		expected := true
+		forEachLiteral(alphabet, max(len(tokensA), len(tokensB))+1, func(subject string) bool {
+			if server.SubjectsCollide(subject, a) && !server.SubjectsCollide(subject, b) {
+				expected = false
+			}
+			return expected
+		})
+
+		assert.Equal(t, expected, natsutil.IsSubjectSubsetOf(a, b), "%s ⊆ %s", a, b)
+	})
+}
+
+// forEachLiteral calls fn with every subject of up to maxLen tokens drawn from alphabet until fn returns false.
+func forEachLiteral(alphabet map[string]struct{}, maxLen int, fn func(subject string) bool) {
+	var tokens []string
+	for token := range alphabet {
+		tokens = append(tokens, token)
+	}
+
+	var visit func(prefix []string) bool
+	visit = func(prefix []string) bool {
+		if len(prefix) > 0 && !fn(strings.Join(prefix, natsutil.SubjectSeparator)) {
+			return false
+		}
+		if len(prefix) == maxLen {
+			return true
+		}
+		for _, token := range tokens {
+			if !visit(append(prefix, token)) {
+				return false
+			}
+		}
+		return true
+	}
+	visit(make([]string, 0, maxLen))
+}
@@ -0,0 +1,108 @@
+package natsutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SubjectParseError describes which token of a subject caused it to be rejected by ParseSubject.
+type SubjectParseError struct {
+	// Subject is the subject being parsed.
+	Subject string
+	// Index is the position of the offending token within the subject, starting from zero.
+	Index int
+	// Offset is the byte offset of the offending token within the subject.
+	Offset int
+	// Err is the *SubjectTokenError describing why the token is invalid.
+	Err error
+}
+
+func (e *SubjectParseError) Error() string {
+	return fmt.Sprintf("failed to parse subject %q: token %d at offset %d: %v", e.Subject, e.Index, e.Offset, e.Err)
+}
+
+// Unwrap returns the token error, so that errors.Is can be used to check why the subject is invalid.
+func (e *SubjectParseError) Unwrap() error {
+	return e.Err
+}
+
+// TokenizeSubject splits a subject into its tokens without validating them.
+func TokenizeSubject(subject string) []string {
+	return strings.Split(subject, SubjectSeparator)
+}
+
+// ParseSubject is the inverse of SubjectBuilder.String, returning a builder holding the tokens of subject so that
+// they can be inspected or modified. Literal tokens are validated against the profile, '*' and '>' are accepted as
+// wildcards provided '>' is the last token. If the subject is invalid a *SubjectParseError is returned.
+func ParseSubject(subject string, profile SubjectProfile) (*SubjectBuilder, error) {
+	tokens := TokenizeSubject(subject)
+
+	offset := 0
+	for idx, token := range tokens {
+		var err error
+		switch {
+		case token == SubjectChevron && idx < len(tokens)-1:
+			err = &SubjectTokenError{Token: token, Position: -1, Profile: profile, Reason: ErrSubjectAfterChevron}
+		case token == SubjectStar || token == SubjectChevron:
+		default:
+			err = ValidateSubjectToken(token, profile)
+		}
+		if err != nil {
+			return nil, &SubjectParseError{Subject: subject, Index: idx, Offset: offset, Err: err}
+		}
+		offset += len(token) + len(SubjectSeparator)
+	}
+
+	return &SubjectBuilder{elements: tokens, profile: profile}, nil
+}
+
+// MustParseSubject is a variant of ParseSubject which panics if an error is returned.
+func MustParseSubject(subject string, profile SubjectProfile) *SubjectBuilder {
+	b, err := ParseSubject(subject, profile)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Len returns the number of tokens in the subject under construction.
+func (b *SubjectBuilder) Len() int {
+	return len(b.elements)
+}
+
+// Token returns the token at index, which must be less than Len.
+func (b *SubjectBuilder) Token(index int) string {
+	return b.elements[index]
+}
+
+// Tokens returns a copy of the tokens in the subject under construction.
+func (b *SubjectBuilder) Tokens() []string {
+	return append([]string(nil), b.elements...)
+}
+
+// IsWildcard reports whether the subject contains a '*' or '>' wildcard.
+func (b *SubjectBuilder) IsWildcard() bool {
+	for _, elem := range b.elements {
+		if elem == SubjectStar || elem == SubjectChevron {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLiteral reports whether the subject contains no wildcards, and so can be published to.
+func (b *SubjectBuilder) IsLiteral() bool {
+	return !b.IsWildcard()
+}
+
+// Prefix returns a new builder with the same profile holding the first n tokens, or all of them if n exceeds Len.
+func (b *SubjectBuilder) Prefix(n int) *SubjectBuilder {
+	n = min(max(n, 0), len(b.elements))
+	return &SubjectBuilder{elements: append([]string(nil), b.elements[:n]...), profile: b.profile}
+}
+
+// Suffix returns a new builder with the same profile holding the last n tokens, or all of them if n exceeds Len.
+func (b *SubjectBuilder) Suffix(n int) *SubjectBuilder {
+	n = min(max(n, 0), len(b.elements))
+	return &SubjectBuilder{elements: append([]string(nil), b.elements[len(b.elements)-n:]...), profile: b.profile}
+}
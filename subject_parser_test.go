@@ -0,0 +1,100 @@
+package natsutil_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenizeSubject(t *testing.T) {
+	assert.Equal(t, []string{"orders", "eu", "*", "created"}, natsutil.TokenizeSubject("orders.eu.*.created"))
+	assert.Equal(t, []string{"a", "", "b"}, natsutil.TokenizeSubject("a..b"))
+	assert.Equal(t, []string{""}, natsutil.TokenizeSubject(""))
+}
+
+func TestParseSubject(t *testing.T) {
+	sb, err := natsutil.ParseSubject("orders.eu.*.created", natsutil.SubjectProfileStrict)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "orders.eu.*.created", sb.String())
+	assert.Equal(t, 4, sb.Len())
+	assert.Equal(t, "eu", sb.Token(1))
+	assert.Equal(t, []string{"orders", "eu", "*", "created"}, sb.Tokens())
+	assert.True(t, sb.IsWildcard())
+	assert.False(t, sb.IsLiteral())
+
+	assert.Equal(t, "orders.eu", sb.Prefix(2).String())
+	assert.Equal(t, "*.created", sb.Suffix(2).String())
+	assert.Equal(t, "orders.eu.*.created", sb.Prefix(10).String())
+	assert.Equal(t, "", sb.Suffix(0).String())
+
+	// the parsed subject can be modified
+	assert.Nil(t, sb.Pop(1))
+	assert.Nil(t, sb.Push("shipped"))
+	assert.Equal(t, "orders.eu.*.shipped", sb.String())
+
+	// modifying the tokens or a prefix does not affect the original
+	sb.Tokens()[0] = "changed"
+	assert.Nil(t, sb.Prefix(1).Push("changed"))
+	assert.Equal(t, "orders.eu.*.shipped", sb.String())
+
+	literal := natsutil.MustParseSubject("orders.eu.42.>", natsutil.SubjectProfileStrict)
+	assert.True(t, literal.IsWildcard())
+	assert.ErrorIs(t, literal.Push("more"), natsutil.ErrSubjectAfterChevron)
+
+	literal = natsutil.MustParseSubject("orders.eu.42", natsutil.SubjectProfileStrict)
+	assert.True(t, literal.IsLiteral())
+	assert.Equal(t, natsutil.SubjectProfileStrict, literal.Profile())
+}
+
+func TestParseSubject_Profiles(t *testing.T) {
+	_, err := natsutil.ParseSubject("orders.café", natsutil.SubjectProfileStrict)
+	assert.ErrorIs(t, err, natsutil.ErrSubjectInvalidCharacters)
+
+	sb, err := natsutil.ParseSubject("orders.café", natsutil.SubjectProfilePermissive)
+	assert.Nil(t, err)
+	assert.Equal(t, natsutil.SubjectProfilePermissive, sb.Profile())
+	assert.Nil(t, sb.Push("{id}"))
+}
+
+func TestParseSubject_Invalid(t *testing.T) {
+	tests := []struct {
+		subject string
+		index   int
+		offset  int
+		reason  error
+	}{
+		{"", 0, 0, natsutil.ErrSubjectTokenEmpty},
+		{"orders..created", 1, 7, natsutil.ErrSubjectTokenEmpty},
+		{"orders.", 1, 7, natsutil.ErrSubjectTokenEmpty},
+		{".orders", 0, 0, natsutil.ErrSubjectTokenEmpty},
+		{"orders.>.created", 1, 7, natsutil.ErrSubjectAfterChevron},
+		{"orders.eu*.created", 1, 7, natsutil.ErrSubjectTokenWildcard},
+		{"orders.eu.new order", 2, 10, natsutil.ErrSubjectTokenWhitespace},
+		{"orders.eu.%", 2, 10, natsutil.ErrSubjectInvalidCharacters},
+	}
+
+	for _, test := range tests {
+		_, err := natsutil.ParseSubject(test.subject, natsutil.SubjectProfileStrict)
+
+		var parseErr *natsutil.SubjectParseError
+		if assert.True(t, errors.As(err, &parseErr), test.subject) {
+			assert.Equal(t, test.subject, parseErr.Subject)
+			assert.Equal(t, test.index, parseErr.Index, test.subject)
+			assert.Equal(t, test.offset, parseErr.Offset, test.subject)
+		}
+		assert.ErrorIs(t, err, test.reason, test.subject)
+	}
+
+	_, err := natsutil.ParseSubject("orders.new order", natsutil.SubjectProfileStrict)
+	assert.Equal(t,
+		`failed to parse subject "orders.new order": token 1 at offset 7: invalid subject token "new order": `+
+			`subject token contains whitespace: ' ' at position 3 (strict profile)`,
+		err.Error(),
+	)
+
+	assert.Panics(t, func() { natsutil.MustParseSubject("orders..created", natsutil.SubjectProfileStrict) })
+}
@@ -0,0 +1,181 @@
+package natsutil
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+const (
+	ErrTemplatePlaceholder      = errors.ConstError("placeholders must be whole tokens of the form {name}")
+	ErrTemplateDuplicate        = errors.ConstError("placeholder appears more than once")
+	ErrTemplateMissingParameter = errors.ConstError("no value for placeholder")
+	ErrTemplateUnknownParameter = errors.ConstError("parameter does not match a placeholder")
+	ErrTemplateNoMatch          = errors.ConstError("subject does not match template")
+)
+
+var placeholderName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// SubjectTemplate describes a family of subjects such as 'orders.{region}.{id}.events', where each placeholder is a
+// whole token which is replaced by a parameter value.
+type SubjectTemplate struct {
+	template     string
+	tokens       []string
+	placeholders map[int]string
+	profile      SubjectProfile
+}
+
+// ParseSubjectTemplate parses a template, validating its literal tokens and the values of its placeholders against
+// the profile. Wildcards cannot be used within a template, see Filter.
+func ParseSubjectTemplate(template string, profile SubjectProfile) (*SubjectTemplate, error) {
+	t := &SubjectTemplate{
+		template:     template,
+		tokens:       TokenizeSubject(template),
+		placeholders: make(map[int]string),
+		profile:      profile,
+	}
+
+	seen := make(map[string]struct{})
+	for idx, token := range t.tokens {
+		if !strings.ContainsAny(token, "{}") {
+			if err := ValidateSubjectToken(token, profile); err != nil {
+				return nil, errors.Annotatef(err, "invalid template %q", template)
+			}
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(token, "{"), "}")
+		if len(name) != len(token)-2 || !placeholderName.MatchString(name) {
+			return nil, errors.Annotatef(ErrTemplatePlaceholder, "invalid template %q: token %q", template, token)
+		}
+		if _, ok := seen[name]; ok {
+			return nil, errors.Annotatef(ErrTemplateDuplicate, "invalid template %q: %q", template, name)
+		}
+		seen[name] = struct{}{}
+		t.placeholders[idx] = name
+	}
+
+	return t, nil
+}
+
+// MustParseSubjectTemplate is a variant of ParseSubjectTemplate which panics if an error is returned.
+func MustParseSubjectTemplate(template string, profile SubjectProfile) *SubjectTemplate {
+	t, err := ParseSubjectTemplate(template, profile)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// String returns the template as it was parsed.
+func (t *SubjectTemplate) String() string {
+	return t.template
+}
+
+// Placeholders returns the names of the placeholders in the order they appear.
+func (t *SubjectTemplate) Placeholders() []string {
+	var names []string
+	for idx := range t.tokens {
+		if name, ok := t.placeholders[idx]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Render returns the subject with each placeholder replaced by its parameter value. Every placeholder must have a
+// value which is a valid token under the template's profile.
+func (t *SubjectTemplate) Render(params map[string]string) (string, error) {
+	sb, err := t.build(params, false)
+	if err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// MustRender is a variant of Render which panics if an error is returned.
+func (t *SubjectTemplate) MustRender(params map[string]string) string {
+	subject, err := t.Render(params)
+	if err != nil {
+		panic(err)
+	}
+	return subject
+}
+
+// Filter returns a subject matching every subject the template can render, with each placeholder replaced by its
+// parameter value if one is given or a '*' wildcard otherwise. A nil map matches all subjects of the template.
+func (t *SubjectTemplate) Filter(params map[string]string) (string, error) {
+	sb, err := t.build(params, true)
+	if err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func (t *SubjectTemplate) build(params map[string]string, wildcards bool) (*SubjectBuilder, error) {
+	used := 0
+	sb := NewSubjectBuilder(t.profile)
+	for idx, token := range t.tokens {
+		name, ok := t.placeholders[idx]
+		if !ok {
+			sb.elements = append(sb.elements, token)
+			continue
+		}
+
+		value, ok := params[name]
+		switch {
+		case ok:
+			used++
+			if err := sb.Push(value); err != nil {
+				return nil, errors.Annotatef(err, "invalid value for placeholder %q", name)
+			}
+		case wildcards:
+			if err := sb.Star(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.Annotatef(ErrTemplateMissingParameter, "%q", name)
+		}
+	}
+
+	if used != len(params) {
+		for name := range params {
+			if !t.hasPlaceholder(name) {
+				return nil, errors.Annotatef(ErrTemplateUnknownParameter, "%q", name)
+			}
+		}
+	}
+
+	return sb, nil
+}
+
+func (t *SubjectTemplate) hasPlaceholder(name string) bool {
+	for _, placeholder := range t.placeholders {
+		if placeholder == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract returns the placeholder values of a subject rendered by the template, or ErrTemplateNoMatch if the subject
+// does not match it.
+func (t *SubjectTemplate) Extract(subject string) (map[string]string, error) {
+	tokens := TokenizeSubject(subject)
+	if len(tokens) != len(t.tokens) {
+		return nil, errors.Annotatef(ErrTemplateNoMatch, "%q", subject)
+	}
+
+	params := make(map[string]string, len(t.placeholders))
+	for idx, token := range tokens {
+		name, ok := t.placeholders[idx]
+		switch {
+		case ok && token != "":
+			params[name] = token
+		case ok || token != t.tokens[idx]:
+			return nil, errors.Annotatef(ErrTemplateNoMatch, "%q", subject)
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,111 @@
+package natsutil_test
+
+import (
+	"testing"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubjectTemplate(t *testing.T) {
+	tmpl, err := natsutil.ParseSubjectTemplate("orders.{region}.{id}.events", natsutil.SubjectProfileStrict)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "orders.{region}.{id}.events", tmpl.String())
+	assert.Equal(t, []string{"region", "id"}, tmpl.Placeholders())
+
+	subject, err := tmpl.Render(map[string]string{"region": "eu", "id": "42"})
+	assert.Nil(t, err)
+	assert.Equal(t, "orders.eu.42.events", subject)
+
+	filter, err := tmpl.Filter(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "orders.*.*.events", filter)
+
+	filter, err = tmpl.Filter(map[string]string{"region": "eu"})
+	assert.Nil(t, err)
+	assert.Equal(t, "orders.eu.*.events", filter)
+	assert.True(t, natsutil.MatchSubject(subject, filter))
+
+	params, err := tmpl.Extract(subject)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"region": "eu", "id": "42"}, params)
+
+	// rendering the extracted values reproduces the subject
+	assert.Equal(t, subject, tmpl.MustRender(params))
+}
+
+func TestSubjectTemplate_RenderErrors(t *testing.T) {
+	tmpl := natsutil.MustParseSubjectTemplate("orders.{region}.{id}.events", natsutil.SubjectProfileStrict)
+
+	_, err := tmpl.Render(map[string]string{"region": "eu"})
+	assert.ErrorIs(t, err, natsutil.ErrTemplateMissingParameter)
+	assert.ErrorContains(t, err, `"id"`)
+
+	_, err = tmpl.Render(map[string]string{"region": "eu", "id": "42", "extra": "x"})
+	assert.ErrorIs(t, err, natsutil.ErrTemplateUnknownParameter)
+
+	_, err = tmpl.Filter(map[string]string{"zone": "a"})
+	assert.ErrorIs(t, err, natsutil.ErrTemplateUnknownParameter)
+
+	_, err = tmpl.Render(map[string]string{"region": "eu.west", "id": "42"})
+	assert.ErrorIs(t, err, natsutil.ErrSubjectTokenSeparator)
+
+	_, err = tmpl.Render(map[string]string{"region": "*", "id": "42"})
+	assert.ErrorIs(t, err, natsutil.ErrSubjectTokenWildcard)
+
+	_, err = tmpl.Render(map[string]string{"region": "eu", "id": ""})
+	assert.ErrorIs(t, err, natsutil.ErrSubjectTokenEmpty)
+
+	_, err = tmpl.Render(map[string]string{"region": "eu", "id": "café"})
+	assert.ErrorIs(t, err, natsutil.ErrSubjectInvalidCharacters)
+
+	permissive := natsutil.MustParseSubjectTemplate("orders.{region}.{id}.events", natsutil.SubjectProfilePermissive)
+	subject, err := permissive.Render(map[string]string{"region": "eu", "id": "café"})
+	assert.Nil(t, err)
+	assert.Equal(t, "orders.eu.café.events", subject)
+
+	assert.Panics(t, func() { tmpl.MustRender(nil) })
+}
+
+func TestSubjectTemplate_Extract(t *testing.T) {
+	tmpl := natsutil.MustParseSubjectTemplate("orders.{region}.{id}.events", natsutil.SubjectProfileStrict)
+
+	for _, subject := range []string{
+		"orders.eu.42",
+		"orders.eu.42.events.more",
+		"invoices.eu.42.events",
+		"orders.eu.42.created",
+		"orders..42.events",
+		"",
+	} {
+		_, err := tmpl.Extract(subject)
+		assert.ErrorIs(t, err, natsutil.ErrTemplateNoMatch, subject)
+	}
+
+	literal := natsutil.MustParseSubjectTemplate("orders.events", natsutil.SubjectProfileStrict)
+	assert.Empty(t, literal.Placeholders())
+	params, err := literal.Extract("orders.events")
+	assert.Nil(t, err)
+	assert.Empty(t, params)
+}
+
+func TestSubjectTemplate_ParseErrors(t *testing.T) {
+	for template, reason := range map[string]error{
+		"orders.{region}.{region}": natsutil.ErrTemplateDuplicate,
+		"orders.{}":                natsutil.ErrTemplatePlaceholder,
+		"orders.{1st}":             natsutil.ErrTemplatePlaceholder,
+		"orders.id-{id}":           natsutil.ErrTemplatePlaceholder,
+		"orders.{id":               natsutil.ErrTemplatePlaceholder,
+		"orders.{a.b}":             natsutil.ErrTemplatePlaceholder,
+		"orders.*":                 natsutil.ErrSubjectTokenWildcard,
+		"orders.>":                 natsutil.ErrSubjectTokenWildcard,
+		"orders..{id}":             natsutil.ErrSubjectTokenEmpty,
+	} {
+		_, err := natsutil.ParseSubjectTemplate(template, natsutil.SubjectProfileStrict)
+		assert.ErrorIs(t, err, reason, template)
+	}
+
+	assert.Panics(t, func() { natsutil.MustParseSubjectTemplate("orders.{}", natsutil.SubjectProfileStrict) })
+}
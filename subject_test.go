@@ -0,0 +1,143 @@
+package natsutil_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubject(t *testing.T) {
+	var empty natsutil.Subject
+	assert.True(t, empty.IsEmpty())
+	assert.Equal(t, 0, empty.Len())
+	assert.Equal(t, "", empty.String())
+	assert.Equal(t, empty, empty.Parent())
+
+	base, err := natsutil.NewSubject("orders", "eu")
+	assert.Nil(t, err)
+	assert.Equal(t, "orders.eu", base.String())
+	assert.Equal(t, 2, base.Len())
+	assert.Equal(t, []string{"orders", "eu"}, base.Tokens())
+	assert.Equal(t, "eu", base.Token(1))
+	assert.Equal(t, "eu", base.Last())
+	assert.Equal(t, natsutil.SubjectProfileStrict, base.Profile())
+
+	created := base.MustAppend("42", "created")
+	child, err := base.Child("43")
+	assert.Nil(t, err)
+
+	// the base is unchanged
+	assert.Equal(t, "orders.eu", base.String())
+	assert.Equal(t, "orders.eu.42.created", created.String())
+	assert.Equal(t, "orders.eu.43", child.String())
+
+	assert.Equal(t, base, child.Parent())
+	assert.Equal(t, "orders", base.Parent().String())
+	assert.True(t, base.Parent().Parent().IsEmpty())
+
+	assert.True(t, created.HasPrefix(base))
+	assert.True(t, created.HasPrefix(created))
+	assert.True(t, created.HasPrefix(empty))
+	assert.False(t, natsutil.MustNewSubject("orders", "europe").HasPrefix(base))
+	assert.False(t, base.HasPrefix(created))
+
+	filter := base.MustStar().MustChevron()
+	assert.Equal(t, "orders.eu.*.>", filter.String())
+	assert.True(t, filter.IsWildcard())
+	assert.True(t, created.IsLiteral())
+	assert.True(t, created.Match(filter))
+	assert.False(t, child.Match(filter))
+
+	// nothing can follow a chevron, including more wildcards
+	terminated := base.MustChevron()
+	_, err = terminated.Append("more")
+	assert.ErrorIs(t, err, natsutil.ErrSubjectAfterChevron)
+	same, err := terminated.Star()
+	assert.ErrorIs(t, err, natsutil.ErrSubjectAfterChevron)
+	assert.Equal(t, terminated, same)
+	_, err = terminated.Chevron()
+	assert.ErrorIs(t, err, natsutil.ErrSubjectAfterChevron)
+	assert.Panics(t, func() { terminated.MustStar() })
+
+	// nothing is appended if any token is invalid
+	same, err = base.Append("valid", "in valid")
+	assert.ErrorIs(t, err, natsutil.ErrSubjectTokenWhitespace)
+	assert.Equal(t, base, same)
+
+	_, err = natsutil.NewSubject("*")
+	assert.ErrorIs(t, err, natsutil.ErrSubjectTokenWildcard)
+	assert.Panics(t, func() { natsutil.MustNewSubject("a.b") })
+}
+
+func TestSubject_Comparison(t *testing.T) {
+	a := natsutil.MustNewSubject("orders", "eu")
+	b := natsutil.MustNewSubject("orders").MustAppend("eu")
+	c := natsutil.MustNewSubject("orders", "us")
+
+	assert.True(t, a == b)
+	assert.True(t, a.Equal(b))
+	assert.False(t, a == c)
+	assert.Equal(t, 0, a.Compare(b))
+	assert.Equal(t, -1, a.Compare(c))
+	assert.Equal(t, 1, c.Compare(a))
+	assert.Equal(t, a.Hash(), b.Hash())
+	assert.NotEqual(t, a.Hash(), c.Hash())
+
+	counts := map[natsutil.Subject]int{}
+	counts[a]++
+	counts[b]++
+	counts[c]++
+	assert.Equal(t, 2, counts[a])
+	assert.Equal(t, 1, counts[c])
+
+	// profiles are part of the value
+	permissive := natsutil.MustParseSubject("orders.eu", natsutil.SubjectProfilePermissive).Subject()
+	assert.False(t, a == permissive)
+	assert.True(t, a.Equal(permissive))
+}
+
+func TestSubject_Builder(t *testing.T) {
+	sb := natsutil.NewSubjectBuilder(natsutil.SubjectProfilePermissive)
+	sb.MustPush("orders", "café")
+
+	subject := sb.Subject()
+	assert.Equal(t, "orders.café", subject.String())
+	assert.Equal(t, natsutil.SubjectProfilePermissive, subject.Profile())
+
+	// the subject is a copy, modifying the builder does not affect it
+	sb.MustPush("42")
+	assert.Equal(t, "orders.café", subject.String())
+
+	// and the builder of a subject is a copy too
+	copied := subject.Builder()
+	copied.MustPop(1)
+	assert.Equal(t, "orders.café", subject.String())
+	assert.Equal(t, "orders", copied.String())
+	assert.Equal(t, natsutil.SubjectProfilePermissive, copied.Profile())
+
+	_, err := subject.Append("{id}")
+	assert.Nil(t, err)
+}
+
+func TestSubject_Concurrent(t *testing.T) {
+	base := natsutil.MustNewSubject("orders", "eu")
+
+	var wg sync.WaitGroup
+	results := make([]natsutil.Subject, 100)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = base.MustAppend("a").Parent().MustAppend("b")
+		}(i)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		assert.Equal(t, "orders.eu.b", result.String())
+	}
+	assert.Equal(t, "orders.eu", base.String())
+}
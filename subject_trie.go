@@ -0,0 +1,194 @@
+package natsutil
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+const ErrTriePatternEmpty = errors.ConstError("pattern must contain at least one token")
+
+// HandlerID identifies a handler inserted into a SubjectTrie so that it can be removed.
+type HandlerID uint64
+
+// SubjectTrie maps subject patterns to handlers, allowing the handlers whose patterns match a subject to be found
+// without comparing the subject against every pattern. Patterns are stored one token per level with '*' and '>'
+// wildcards held apart from literal tokens, so a match visits at most three children per token of the subject.
+//
+// A SubjectTrie is safe for concurrent use, Match can be called concurrently with other calls to Match.
+type SubjectTrie[H any] struct {
+	mu       sync.RWMutex
+	root     *trieNode[H]
+	patterns map[HandlerID][]string
+	nextID   HandlerID
+}
+
+type trieEntry[H any] struct {
+	id      HandlerID
+	handler H
+}
+
+type trieNode[H any] struct {
+	literals map[string]*trieNode[H]
+	star     *trieNode[H]
+	chevron  *trieNode[H]
+	entries  []trieEntry[H]
+}
+
+func (n *trieNode[H]) isEmpty() bool {
+	return len(n.literals) == 0 && n.star == nil && n.chevron == nil && len(n.entries) == 0
+}
+
+// NewSubjectTrie creates an empty SubjectTrie.
+func NewSubjectTrie[H any]() *SubjectTrie[H] {
+	return &SubjectTrie[H]{
+		root:     &trieNode[H]{},
+		patterns: make(map[HandlerID][]string),
+	}
+}
+
+// Len returns the number of handlers in the trie.
+func (t *SubjectTrie[H]) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.patterns)
+}
+
+// Insert adds a handler for the pattern, returning an id which can be used to remove it. Any number of handlers can
+// be inserted for the same pattern. Returns ErrTriePatternEmpty if the pattern has no tokens, and
+// ErrSubjectAfterChevron if any token follows a '>', since such a pattern could never match.
+func (t *SubjectTrie[H]) Insert(pattern *SubjectBuilder, handler H) (HandlerID, error) {
+	tokens := pattern.Tokens()
+	if len(tokens) == 0 {
+		return 0, ErrTriePatternEmpty
+	}
+	for i, token := range tokens[:len(tokens)-1] {
+		if token == SubjectChevron {
+			return 0, errors.Annotatef(ErrSubjectAfterChevron, "token %d of %q", i+1, pattern.String())
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	node := t.root
+	for _, token := range tokens {
+		node = node.child(token, true)
+	}
+
+	t.nextID++
+	id := t.nextID
+	node.entries = append(node.entries, trieEntry[H]{id: id, handler: handler})
+	t.patterns[id] = tokens
+	return id, nil
+}
+
+// Remove removes the handler with the given id, reporting whether it was present.
+func (t *SubjectTrie[H]) Remove(id HandlerID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tokens, ok := t.patterns[id]
+	if !ok {
+		return false
+	}
+	delete(t.patterns, id)
+
+	path := make([]*trieNode[H], 0, len(tokens)+1)
+	path = append(path, t.root)
+	for _, token := range tokens {
+		path = append(path, path[len(path)-1].child(token, false))
+	}
+
+	node := path[len(path)-1]
+	for i, entry := range node.entries {
+		if entry.id == id {
+			node.entries = append(node.entries[:i], node.entries[i+1:]...)
+			break
+		}
+	}
+
+	// prune the nodes which no longer lead to any handlers
+	for i := len(tokens) - 1; i >= 0 && path[i+1].isEmpty(); i-- {
+		path[i].removeChild(tokens[i])
+	}
+
+	return true
+}
+
+// Match returns the handlers whose patterns match the subject, in the order they were inserted. The subject is
+// expected to be literal, any wildcards within it are compared as ordinary tokens.
+func (t *SubjectTrie[H]) Match(subject string) []H {
+	tokens := TokenizeSubject(subject)
+
+	t.mu.RLock()
+	var entries []trieEntry[H]
+	t.root.match(tokens, &entries)
+	t.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].id < entries[j].id
+	})
+
+	handlers := make([]H, len(entries))
+	for i, entry := range entries {
+		handlers[i] = entry.handler
+	}
+	return handlers
+}
+
+// child returns the child for the token, creating it if create is set.
+func (n *trieNode[H]) child(token string, create bool) *trieNode[H] {
+	switch token {
+	case SubjectStar:
+		if n.star == nil && create {
+			n.star = &trieNode[H]{}
+		}
+		return n.star
+	case SubjectChevron:
+		if n.chevron == nil && create {
+			n.chevron = &trieNode[H]{}
+		}
+		return n.chevron
+	default:
+		child, ok := n.literals[token]
+		if !ok && create {
+			if n.literals == nil {
+				n.literals = make(map[string]*trieNode[H])
+			}
+			child = &trieNode[H]{}
+			n.literals[token] = child
+		}
+		return child
+	}
+}
+
+func (n *trieNode[H]) removeChild(token string) {
+	switch token {
+	case SubjectStar:
+		n.star = nil
+	case SubjectChevron:
+		n.chevron = nil
+	default:
+		delete(n.literals, token)
+	}
+}
+
+// match appends the entries of the patterns below n which match the remaining tokens.
+func (n *trieNode[H]) match(tokens []string, entries *[]trieEntry[H]) {
+	if len(tokens) == 0 {
+		*entries = append(*entries, n.entries...)
+		return
+	}
+	if n.chevron != nil {
+		// a chevron consumes all the remaining tokens, of which there is at least one
+		*entries = append(*entries, n.chevron.entries...)
+	}
+	if n.star != nil {
+		n.star.match(tokens[1:], entries)
+	}
+	if child, ok := n.literals[tokens[0]]; ok {
+		child.match(tokens[1:], entries)
+	}
+}
@@ -0,0 +1,218 @@
+package natsutil_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubjectTrie(t *testing.T) {
+	trie := natsutil.NewSubjectTrie[string]()
+
+	insert := func(pattern string) natsutil.HandlerID {
+		id, err := trie.Insert(natsutil.MustParseSubject(pattern, natsutil.SubjectProfileStrict), pattern)
+		assert.Nil(t, err)
+		return id
+	}
+
+	insert("orders.eu.created")
+	star := insert("orders.*.created")
+	insert("orders.>")
+	insert(">")
+	insert("orders.eu")
+	second := insert("orders.*.created")
+
+	assert.Equal(t, 6, trie.Len())
+
+	assert.Equal(t,
+		[]string{"orders.eu.created", "orders.*.created", "orders.>", ">", "orders.*.created"},
+		trie.Match("orders.eu.created"),
+	)
+	assert.Equal(t, []string{"orders.>", ">", "orders.eu"}, trie.Match("orders.eu"))
+	assert.Equal(t, []string{">"}, trie.Match("orders"))
+	assert.Equal(t, []string{">"}, trie.Match("invoices.eu.created"))
+
+	assert.True(t, trie.Remove(star))
+	assert.False(t, trie.Remove(star))
+	assert.Equal(t,
+		[]string{"orders.eu.created", "orders.>", ">", "orders.*.created"},
+		trie.Match("orders.eu.created"),
+	)
+
+	assert.True(t, trie.Remove(second))
+	assert.Equal(t, []string{"orders.>", ">"}, trie.Match("orders.us.created"))
+	assert.Equal(t, 4, trie.Len())
+
+	_, err := trie.Insert(&natsutil.SubjectBuilder{}, "empty")
+	assert.ErrorIs(t, err, natsutil.ErrTriePatternEmpty)
+}
+
+func TestSubjectTrie_Handlers(t *testing.T) {
+	type handler func(subject string) string
+	trie := natsutil.NewSubjectTrie[handler]()
+
+	pattern := natsutil.SubjectBuilder{}
+	pattern.MustPush("orders").Star()
+
+	_, err := trie.Insert(&pattern, func(subject string) string { return "handled " + subject })
+	assert.Nil(t, err)
+
+	handlers := trie.Match("orders.created")
+	assert.Len(t, handlers, 1)
+	assert.Equal(t, "handled orders.created", handlers[0]("orders.created"))
+}
+
+// randomPatterns returns subjects of up to four tokens drawn from a small alphabet, with a wildcard in place of a
+// token with the given probability.
+func randomPatterns(rng *rand.Rand, count, alphabet int, wildcards float64) []string {
+	patterns := make([]string, count)
+	for i := range patterns {
+		sb := natsutil.SubjectBuilder{}
+		length := 1 + rng.Intn(4)
+		for j := 0; j < length; j++ {
+			switch {
+			case rng.Float64() >= wildcards:
+				sb.MustPush(fmt.Sprintf("t%d", rng.Intn(alphabet)))
+			case j == length-1 && rng.Intn(2) == 0:
+				sb.Chevron()
+			default:
+				sb.Star()
+			}
+		}
+		patterns[i] = sb.String()
+	}
+	return patterns
+}
+
+func TestSubjectTrie_MatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	patterns := randomPatterns(rng, 2000, 5, 0.2)
+	subjects := randomPatterns(rng, 500, 5, 0)
+
+	trie := natsutil.NewSubjectTrie[int]()
+	ids := make([]natsutil.HandlerID, len(patterns))
+	for i, pattern := range patterns {
+		id, err := trie.Insert(natsutil.MustParseSubject(pattern, natsutil.SubjectProfileStrict), i)
+		assert.Nil(t, err)
+		ids[i] = id
+	}
+
+	// remove every third pattern
+	removed := make(map[int]bool)
+	for i := 0; i < len(patterns); i += 3 {
+		assert.True(t, trie.Remove(ids[i]))
+		removed[i] = true
+	}
+
+	for _, subject := range subjects {
+		var expected []int
+		for i, pattern := range patterns {
+			if !removed[i] && natsutil.MatchSubject(subject, pattern) {
+				expected = append(expected, i)
+			}
+		}
+		actual := trie.Match(subject)
+		if len(expected) == 0 {
+			assert.Empty(t, actual, subject)
+		} else {
+			assert.Equal(t, expected, actual, subject)
+		}
+	}
+
+	// removing everything leaves an empty trie
+	for i, id := range ids {
+		assert.Equal(t, !removed[i], trie.Remove(id))
+	}
+	assert.Equal(t, 0, trie.Len())
+	assert.Empty(t, trie.Match("t1.t2"))
+}
+
+func TestSubjectTrie_Concurrent(t *testing.T) {
+	trie := natsutil.NewSubjectTrie[int]()
+	patterns := randomPatterns(rand.New(rand.NewSource(2)), 1000, 10, 0.2)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(patterns); i += 4 {
+				id, err := trie.Insert(natsutil.MustParseSubject(patterns[i], natsutil.SubjectProfileStrict), i)
+				assert.Nil(t, err)
+				if i%2 == 0 {
+					trie.Remove(id)
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				_ = trie.Match("t1.t2.t3")
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, len(patterns)/2, trie.Len())
+}
+
+func benchmarkPatterns(b *testing.B) ([]string, []string) {
+	b.Helper()
+	rng := rand.New(rand.NewSource(3))
+	return randomPatterns(rng, 50_000, 100, 0.05), randomPatterns(rng, 1000, 100, 0)
+}
+
+func BenchmarkSubjectTrie_Insert(b *testing.B) {
+	patterns, _ := benchmarkPatterns(b)
+	parsed := make([]*natsutil.SubjectBuilder, len(patterns))
+	for i, pattern := range patterns {
+		parsed[i] = natsutil.MustParseSubject(pattern, natsutil.SubjectProfileStrict)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie := natsutil.NewSubjectTrie[int]()
+		for j, pattern := range parsed {
+			_, _ = trie.Insert(pattern, j)
+		}
+	}
+}
+
+func BenchmarkSubjectTrie_Match(b *testing.B) {
+	patterns, subjects := benchmarkPatterns(b)
+	trie := natsutil.NewSubjectTrie[int]()
+	for i, pattern := range patterns {
+		_, _ = trie.Insert(natsutil.MustParseSubject(pattern, natsutil.SubjectProfileStrict), i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_ = trie.Match(subjects[i%len(subjects)])
+			i++
+		}
+	})
+}
+
+// BenchmarkSubjectTrie_LinearScan is the baseline the trie replaces.
+func BenchmarkSubjectTrie_LinearScan(b *testing.B) {
+	patterns, subjects := benchmarkPatterns(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		subject := subjects[i%len(subjects)]
+		var matches []int
+		for j, pattern := range patterns {
+			if natsutil.MatchSubject(subject, pattern) {
+				matches = append(matches, j)
+			}
+		}
+		_ = matches
+	}
+}
@@ -0,0 +1,103 @@
+package natsutil
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/juju/errors"
+)
+
+const (
+	ErrSubjectInvalidCharacters = errors.ConstError("subject token contains a character not permitted by the profile")
+	ErrSubjectTokenEmpty        = errors.ConstError("subject token is empty")
+	ErrSubjectTokenWhitespace   = errors.ConstError("subject token contains whitespace")
+	ErrSubjectTokenSeparator    = errors.ConstError("subject token contains the '.' separator")
+	ErrSubjectTokenWildcard     = errors.ConstError("subject wildcards must be whole tokens")
+	ErrSubjectAfterChevron      = errors.ConstError("subject '>' wildcard must be the last token")
+)
+
+// SubjectProfile determines which characters are permitted within subject tokens.
+type SubjectProfile int
+
+const (
+	// SubjectProfileStrict permits only ASCII letters, digits and '_', '-', '/' and '='. These are the characters
+	// which are valid within Key-Value bucket keys, so subjects built with this profile can always be used as keys.
+	SubjectProfileStrict SubjectProfile = iota
+	// SubjectProfilePermissive follows the NATS subject rules, permitting any character other than whitespace,
+	// control characters, the '.' separator and the '*' and '>' wildcards.
+	SubjectProfilePermissive
+)
+
+func (p SubjectProfile) String() string {
+	switch p {
+	case SubjectProfileStrict:
+		return "strict"
+	case SubjectProfilePermissive:
+		return "permissive"
+	default:
+		return fmt.Sprintf("SubjectProfile(%d)", int(p))
+	}
+}
+
+// SubjectTokenError describes why a subject token is invalid.
+type SubjectTokenError struct {
+	// Token is the offending token.
+	Token string
+	// Position is the byte offset within the token of the offending character, or -1 if the token as a whole is
+	// invalid.
+	Position int
+	// Profile is the profile the token was validated against.
+	Profile SubjectProfile
+	// Reason is one of the ErrSubject sentinel errors.
+	Reason error
+}
+
+func (e *SubjectTokenError) Error() string {
+	if e.Position < 0 {
+		return fmt.Sprintf("invalid subject token %q: %v", e.Token, e.Reason)
+	}
+	r := []rune(e.Token[e.Position:])[0]
+	return fmt.Sprintf("invalid subject token %q: %v: %q at position %d (%s profile)",
+		e.Token, e.Reason, r, e.Position, e.Profile)
+}
+
+// Unwrap returns the reason, so that errors.Is can be used to check why a token is invalid.
+func (e *SubjectTokenError) Unwrap() error {
+	return e.Reason
+}
+
+// ValidateSubjectToken checks that token can be used as a literal token within a subject under the given profile,
+// returning a *SubjectTokenError if not.
+func ValidateSubjectToken(token string, profile SubjectProfile) error {
+	if token == "" {
+		return &SubjectTokenError{Token: token, Position: -1, Profile: profile, Reason: ErrSubjectTokenEmpty}
+	}
+
+	for pos, r := range token {
+		var reason error
+		switch {
+		case r == '.':
+			reason = ErrSubjectTokenSeparator
+		case r == '*' || r == '>':
+			reason = ErrSubjectTokenWildcard
+		case unicode.IsSpace(r):
+			reason = ErrSubjectTokenWhitespace
+		case !permitted(r, profile):
+			reason = ErrSubjectInvalidCharacters
+		}
+		if reason != nil {
+			return &SubjectTokenError{Token: token, Position: pos, Profile: profile, Reason: reason}
+		}
+	}
+
+	return nil
+}
+
+func permitted(r rune, profile SubjectProfile) bool {
+	if profile == SubjectProfilePermissive {
+		return r != unicode.ReplacementChar && !unicode.IsControl(r)
+	}
+	return r < unicode.MaxASCII &&
+		(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-/=", r))
+}
@@ -0,0 +1,231 @@
+package natsutil
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+// InstrumentationName is the name used when acquiring a tracer and meter from the configured providers.
+const InstrumentationName = "github.com/41north/natsutil.go"
+
+// Attribute keys attached to the spans and metrics recorded by this package.
+const (
+	AttrBucket    = attribute.Key("nats.kv.bucket")
+	AttrKey       = attribute.Key("nats.kv.key")
+	AttrOperation = attribute.Key("nats.kv.operation")
+	AttrRevision  = attribute.Key("nats.kv.revision")
+	AttrStatus    = attribute.Key("nats.kv.status")
+)
+
+// Metric names recorded by this package.
+const (
+	MetricOperations       = "natsutil.kv.operations"
+	MetricOperationLatency = "natsutil.kv.operation.duration"
+	MetricEncodeLatency    = "natsutil.kv.encode.duration"
+	MetricDecodeLatency    = "natsutil.kv.decode.duration"
+	MetricPayloadSize      = "natsutil.kv.payload.size"
+	MetricWatcherLag       = "natsutil.kv.watcher.lag"
+	MetricWatcherBuffered  = "natsutil.kv.watcher.buffered"
+)
+
+const (
+	statusOk    = "ok"
+	statusError = "error"
+)
+
+// telemetry holds the tracer and metric instruments used to instrument typed key value operations.
+// When no providers have been configured the no-op implementations are used.
+type telemetry struct {
+	tracer trace.Tracer
+
+	operations       metric.Int64Counter
+	operationLatency metric.Float64Histogram
+	encodeLatency    metric.Float64Histogram
+	decodeLatency    metric.Float64Histogram
+	payloadSize      metric.Int64Histogram
+	watcherLag       metric.Float64Histogram
+	watcherBuffered  metric.Int64Gauge
+}
+
+func newTelemetry(opts *options) *telemetry {
+	tracerProvider := opts.tracerProvider
+	if tracerProvider == nil {
+		tracerProvider = tracenoop.NewTracerProvider()
+	}
+
+	meterProvider := opts.meterProvider
+	if meterProvider == nil {
+		meterProvider = metricnoop.NewMeterProvider()
+	}
+
+	meter := meterProvider.Meter(InstrumentationName)
+	t := &telemetry{tracer: tracerProvider.Tracer(InstrumentationName)}
+
+	// instrument creation errors are reported to the global otel error handler, a usable instrument is
+	// still returned in that case, so we carry on regardless
+	var err error
+	if t.operations, err = meter.Int64Counter(
+		MetricOperations,
+		metric.WithDescription("Number of key value operations performed."),
+		metric.WithUnit("{operation}"),
+	); err != nil {
+		otel.Handle(err)
+	}
+	if t.operationLatency, err = meter.Float64Histogram(
+		MetricOperationLatency,
+		metric.WithDescription("Duration of key value operations."),
+		metric.WithUnit("s"),
+	); err != nil {
+		otel.Handle(err)
+	}
+	if t.encodeLatency, err = meter.Float64Histogram(
+		MetricEncodeLatency,
+		metric.WithDescription("Time spent encoding values before they are written."),
+		metric.WithUnit("s"),
+	); err != nil {
+		otel.Handle(err)
+	}
+	if t.decodeLatency, err = meter.Float64Histogram(
+		MetricDecodeLatency,
+		metric.WithDescription("Time spent decoding values after they have been read."),
+		metric.WithUnit("s"),
+	); err != nil {
+		otel.Handle(err)
+	}
+	if t.payloadSize, err = meter.Int64Histogram(
+		MetricPayloadSize,
+		metric.WithDescription("Size of encoded values."),
+		metric.WithUnit("By"),
+	); err != nil {
+		otel.Handle(err)
+	}
+	if t.watcherLag, err = meter.Float64Histogram(
+		MetricWatcherLag,
+		metric.WithDescription("Time between an entry being written and it being delivered by a watcher."),
+		metric.WithUnit("s"),
+	); err != nil {
+		otel.Handle(err)
+	}
+	if t.watcherBuffered, err = meter.Int64Gauge(
+		MetricWatcherBuffered,
+		metric.WithDescription("Number of decoded entries waiting to be consumed from a watcher."),
+		metric.WithUnit("{entry}"),
+	); err != nil {
+		otel.Handle(err)
+	}
+
+	return t
+}
+
+// operation tracks the span and timing of a single key value operation.
+type operation struct {
+	telemetry *telemetry
+	name      string
+	bucket    string
+	start     time.Time
+	span      trace.Span
+	// logger is optional, when set failures are logged along with the key.
+	logger *slog.Logger
+	key    string
+}
+
+// startOperation begins a span for the named operation and starts timing it.
+func (t *telemetry) startOperation(name string, bucket string, key string) *operation {
+	attrs := []attribute.KeyValue{AttrBucket.String(bucket), AttrOperation.String(name)}
+	if key != "" {
+		attrs = append(attrs, AttrKey.String(key))
+	}
+
+	_, span := t.tracer.Start(
+		context.Background(),
+		"KeyValue."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+
+	return &operation{telemetry: t, name: name, bucket: bucket, start: time.Now(), span: span}
+}
+
+// setRevision records the revision which was read or written by the operation.
+func (o *operation) setRevision(revision uint64) {
+	o.span.SetAttributes(AttrRevision.Int64(int64(revision)))
+}
+
+// end completes the operation, recording its outcome and latency.
+func (o *operation) end(err error) {
+	status := statusOk
+	if err != nil {
+		status = statusError
+		o.span.RecordError(err)
+		o.span.SetStatus(codes.Error, err.Error())
+		if o.logger != nil {
+			o.logger.Debug("operation failed",
+				slog.String(LogKeyOperation, o.name),
+				slog.String(LogKeyKey, o.key),
+				slog.Any("error", err),
+			)
+		}
+	}
+	o.span.End()
+
+	attrs := metric.WithAttributes(
+		AttrBucket.String(o.bucket),
+		AttrOperation.String(o.name),
+		AttrStatus.String(status),
+	)
+	o.telemetry.operations.Add(context.Background(), 1, attrs)
+	o.telemetry.operationLatency.Record(context.Background(), time.Since(o.start).Seconds(), attrs)
+}
+
+// recordEncode records the time taken to encode a value and the resulting payload size.
+func (o *operation) recordEncode(elapsed time.Duration, size int, err error) {
+	o.telemetry.recordCodec(o.telemetry.encodeLatency, o.bucket, o.name, elapsed, size, err)
+}
+
+// recordDecode records the time taken to decode a value and the size of the payload it was decoded from.
+func (t *telemetry) recordDecode(bucket string, elapsed time.Duration, size int, err error) {
+	t.recordCodec(t.decodeLatency, bucket, "Decode", elapsed, size, err)
+}
+
+func (t *telemetry) recordCodec(
+	latency metric.Float64Histogram,
+	bucket string,
+	name string,
+	elapsed time.Duration,
+	size int,
+	err error,
+) {
+	status := statusOk
+	if err != nil {
+		status = statusError
+	}
+	ctx := context.Background()
+	latency.Record(ctx, elapsed.Seconds(), metric.WithAttributes(
+		AttrBucket.String(bucket),
+		AttrStatus.String(status),
+	))
+	if err == nil {
+		t.payloadSize.Record(ctx, int64(size), metric.WithAttributes(
+			AttrBucket.String(bucket),
+			AttrOperation.String(name),
+		))
+	}
+}
+
+// recordDelivery records how long an entry took to reach a watcher and how many entries are buffered.
+func (t *telemetry) recordDelivery(entry nats.KeyValueEntry, buffered int) {
+	ctx := context.Background()
+	attrs := metric.WithAttributes(AttrBucket.String(entry.Bucket()))
+	t.watcherLag.Record(ctx, time.Since(entry.Created()).Seconds(), attrs)
+	t.watcherBuffered.Record(ctx, int64(buffered), attrs)
+}
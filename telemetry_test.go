@@ -0,0 +1,160 @@
+package natsutil_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func collectMetrics(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Metrics {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	assert.Nil(t, reader.Collect(context.Background(), &rm))
+
+	result := make(map[string]metricdata.Metrics)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			result[m.Name] = m
+		}
+	}
+	return result
+}
+
+func TestKv_Telemetry(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+
+	kv := natsutil.NewKeyValue[testPayload](
+		createTestBucket(t, js),
+		&encoder,
+		natsutil.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))),
+		natsutil.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+	)
+
+	w, err := kv.Watch("foo")
+	assert.Nil(t, err)
+	ch := w.UpdatesUnmarshalled()
+
+	_, err = kv.Put("foo", testPayload{1})
+	assert.Nil(t, err)
+
+	entry, err := kv.Get("foo")
+	assert.Nil(t, err)
+	_, err = entry.UnmarshalValue()
+	assert.Nil(t, err)
+
+	_, err = kv.Get("bar")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+
+	// initial nil entry followed by the put
+	<-ch
+	<-ch
+	assert.Nil(t, w.Stop())
+
+	// verify spans
+	ended := spans.Ended()
+	assert.Equal(t, 4, len(ended))
+
+	names := make([]string, len(ended))
+	for idx, span := range ended {
+		names[idx] = span.Name()
+	}
+	assert.Equal(t, []string{"KeyValue.Watch", "KeyValue.Put", "KeyValue.Get", "KeyValue.Get"}, names)
+
+	put := ended[1]
+	assert.Contains(t, put.Attributes(), natsutil.AttrBucket.String("TestBucket"))
+	assert.Contains(t, put.Attributes(), natsutil.AttrKey.String("foo"))
+	assert.Contains(t, put.Attributes(), natsutil.AttrRevision.Int64(1))
+	assert.Equal(t, codes.Unset, put.Status().Code)
+
+	failedGet := ended[3]
+	assert.Equal(t, codes.Error, failedGet.Status().Code)
+	assert.Equal(t, 1, len(failedGet.Events()))
+
+	// verify metrics
+	metrics := collectMetrics(t, reader)
+
+	operations := metrics[natsutil.MetricOperations].Data.(metricdata.Sum[int64])
+	counts := make(map[attribute.Distinct]int64)
+	for _, dp := range operations.DataPoints {
+		counts[dp.Attributes.Equivalent()] = dp.Value
+	}
+	okGet := attribute.NewSet(
+		natsutil.AttrBucket.String("TestBucket"),
+		natsutil.AttrOperation.String("Get"),
+		natsutil.AttrStatus.String("ok"),
+	)
+	errGet := attribute.NewSet(
+		natsutil.AttrBucket.String("TestBucket"),
+		natsutil.AttrOperation.String("Get"),
+		natsutil.AttrStatus.String("error"),
+	)
+	assert.Equal(t, int64(1), counts[okGet.Equivalent()])
+	assert.Equal(t, int64(1), counts[errGet.Equivalent()])
+
+	for _, name := range []string{
+		natsutil.MetricOperationLatency,
+		natsutil.MetricEncodeLatency,
+		natsutil.MetricDecodeLatency,
+		natsutil.MetricWatcherLag,
+	} {
+		histogram, ok := metrics[name].Data.(metricdata.Histogram[float64])
+		assert.True(t, ok, name)
+		assert.NotEmpty(t, histogram.DataPoints, name)
+	}
+
+	// one encode on put and a decode on get, the watcher entry is never decoded
+	payloadSize := metrics[natsutil.MetricPayloadSize].Data.(metricdata.Histogram[int64])
+	var payloads uint64
+	for _, dp := range payloadSize.DataPoints {
+		payloads += dp.Count
+	}
+	assert.Equal(t, uint64(2), payloads)
+
+	buffered, ok := metrics[natsutil.MetricWatcherBuffered].Data.(metricdata.Gauge[int64])
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(buffered.DataPoints))
+}
+
+func TestKv_TelemetryDisabled(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	bucket := createTestBucket(t, js)
+
+	// a watcher created directly without any options should still function
+	kw, err := bucket.Watch("foo")
+	assert.Nil(t, err)
+	w := natsutil.NewKeyWatcher[testPayload](kw, &encoder)
+	ch := w.UpdatesUnmarshalled()
+
+	kv := natsutil.NewKeyValue[testPayload](bucket, &encoder)
+	_, err = kv.Put("foo", testPayload{1})
+	assert.Nil(t, err)
+
+	assert.Nil(t, <-ch)
+	entry := <-ch
+	v, err := entry.UnmarshalValue()
+	assert.Nil(t, err)
+	assert.Equal(t, testPayload{1}, v)
+
+	assert.Nil(t, w.Stop())
+}
@@ -0,0 +1,186 @@
+package natsutil
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+const ErrTokenEncoding = errors.ConstError("token is not validly encoded")
+
+// emptyToken is what the empty string is encoded as, since tokens cannot be empty. None of the encodings produce it
+// for any other value.
+const emptyToken = "="
+
+// TokenEncoding reversibly maps arbitrary strings to tokens which are valid under SubjectProfileStrict, and so can be
+// used within both subjects and Key-Value keys.
+type TokenEncoding interface {
+	// EncodeToken returns the token representing s.
+	EncodeToken(s string) string
+	// DecodeToken returns the string the token represents, or ErrTokenEncoding if it was not produced by EncodeToken.
+	DecodeToken(token string) (string, error)
+}
+
+var (
+	// TokenEncodingEscape leaves ASCII letters, digits, '_', '-' and '/' as they are and replaces every other byte
+	// with '=' followed by its value in hex, so that encoded tokens remain readable. For example 'alice@example.com'
+	// is encoded as 'alice=40example=2Ecom'.
+	TokenEncodingEscape TokenEncoding = escapeEncoding{}
+	// TokenEncodingBase64 encodes with unpadded base64url, producing the shortest tokens.
+	TokenEncodingBase64 TokenEncoding = binaryEncoding{base64.RawURLEncoding}
+	// TokenEncodingBase32Hex encodes with unpadded base32hex, producing tokens which sort in the same order as the
+	// non-empty strings they encode.
+	TokenEncodingBase32Hex TokenEncoding = binaryEncoding{base32.HexEncoding.WithPadding(base32.NoPadding)}
+)
+
+// decode decodes a token and checks that encoding the result reproduces it, so that each string has exactly one
+// valid token.
+func decode(encoding TokenEncoding, token string, fn func(token string) (string, error)) (string, error) {
+	if token == emptyToken {
+		return "", nil
+	}
+	s, err := fn(token)
+	if err != nil || s == "" || encoding.EncodeToken(s) != token {
+		return "", errors.Annotatef(ErrTokenEncoding, "%q", token)
+	}
+	return s, nil
+}
+
+type escapeEncoding struct{}
+
+func unescaped(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || strings.IndexByte("_-/", b) >= 0
+}
+
+func (e escapeEncoding) EncodeToken(s string) string {
+	if s == "" {
+		return emptyToken
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; unescaped(b) {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "=%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func (e escapeEncoding) DecodeToken(token string) (string, error) {
+	return decode(e, token, func(token string) (string, error) {
+		var sb strings.Builder
+		for i := 0; i < len(token); i++ {
+			if token[i] != '=' {
+				sb.WriteByte(token[i])
+				continue
+			}
+			if i+3 > len(token) {
+				return "", ErrTokenEncoding
+			}
+			b, err := strconv.ParseUint(token[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteByte(byte(b))
+			i += 2
+		}
+		return sb.String(), nil
+	})
+}
+
+// binaryCodec is implemented by base64.Encoding and base32.Encoding.
+type binaryCodec interface {
+	EncodeToString(src []byte) string
+	DecodeString(s string) ([]byte, error)
+}
+
+type binaryEncoding struct {
+	codec binaryCodec
+}
+
+func (e binaryEncoding) EncodeToken(s string) string {
+	if s == "" {
+		return emptyToken
+	}
+	return e.codec.EncodeToString([]byte(s))
+}
+
+func (e binaryEncoding) DecodeToken(token string) (string, error) {
+	return decode(e, token, func(token string) (string, error) {
+		b, err := e.codec.DecodeString(token)
+		return string(b), err
+	})
+}
+
+// PushEncoded encodes each value with the encoding and appends the resulting tokens to the subject under
+// construction.
+func (b *SubjectBuilder) PushEncoded(encoding TokenEncoding, values ...string) error {
+	tokens := make([]string, len(values))
+	for i, value := range values {
+		tokens[i] = encoding.EncodeToken(value)
+	}
+	return b.Push(tokens...)
+}
+
+// AppendEncoded returns a new subject with each value encoded with the encoding and appended.
+func (s Subject) AppendEncoded(encoding TokenEncoding, values ...string) (Subject, error) {
+	tokens := make([]string, len(values))
+	for i, value := range values {
+		tokens[i] = encoding.EncodeToken(value)
+	}
+	return s.Append(tokens...)
+}
+
+// EncodeKey encodes each '.' separated token of a key with the encoding, the result is a valid key even if the key
+// contains empty tokens or characters which keys cannot. A nil encoding returns the key unchanged.
+//
+// Only the content of each token is encoded: every '.' in the key remains a separator, so the key keeps its hierarchy
+// and can be matched by wildcards. DecodeKey returns the original key, but a '.' within a value such as an email
+// address also separates tokens. To store such a value as a single token, encode it with EncodeToken or
+// SubjectBuilder.PushEncoded, which escape the '.', and decode that token with DecodeToken.
+func EncodeKey(encoding TokenEncoding, key string) string {
+	if encoding == nil {
+		return key
+	}
+	tokens := TokenizeSubject(key)
+	for i, token := range tokens {
+		tokens[i] = encoding.EncodeToken(token)
+	}
+	return strings.Join(tokens, SubjectSeparator)
+}
+
+// DecodeKey reverses EncodeKey, decoding each token and joining them with '.'. A nil encoding returns the key
+// unchanged.
+func DecodeKey(encoding TokenEncoding, key string) (string, error) {
+	if encoding == nil {
+		return key, nil
+	}
+	tokens := TokenizeSubject(key)
+	for i, token := range tokens {
+		decoded, err := encoding.DecodeToken(token)
+		if err != nil {
+			return "", errors.Annotatef(err, "invalid key %q", key)
+		}
+		tokens[i] = decoded
+	}
+	return strings.Join(tokens, SubjectSeparator), nil
+}
+
+// encodePattern is a variant of EncodeKey which leaves '*' and '>' wildcards as they are, so that keys can be watched.
+func encodePattern(encoding TokenEncoding, pattern string) string {
+	if encoding == nil {
+		return pattern
+	}
+	tokens := TokenizeSubject(pattern)
+	for i, token := range tokens {
+		if token != SubjectStar && token != SubjectChevron {
+			tokens[i] = encoding.EncodeToken(token)
+		}
+	}
+	return strings.Join(tokens, SubjectSeparator)
+}
@@ -0,0 +1,247 @@
+package natsutil_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/41north/natsutil.go"
+	"github.com/nats-io/nats.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var tokenEncodings = map[string]natsutil.TokenEncoding{
+	"escape":    natsutil.TokenEncodingEscape,
+	"base64":    natsutil.TokenEncodingBase64,
+	"base32hex": natsutil.TokenEncodingBase32Hex,
+}
+
+var unsafeStrings = []string{
+	"", "alice", "alice@example.com", "a b", "a.b", "*", ">", "=", "==", "%41",
+	"café", "日本", "\x00\xff", "us-east-1/a_b",
+}
+
+func TestTokenEncoding_RoundTrip(t *testing.T) {
+	for name, encoding := range tokenEncodings {
+		for _, s := range unsafeStrings {
+			token := encoding.EncodeToken(s)
+			assert.Nil(t, natsutil.ValidateSubjectToken(token, natsutil.SubjectProfileStrict), "%s %q", name, s)
+
+			decoded, err := encoding.DecodeToken(token)
+			assert.Nil(t, err, "%s %q", name, s)
+			assert.Equal(t, s, decoded, name)
+		}
+	}
+}
+
+func TestTokenEncoding_Escape(t *testing.T) {
+	assert.Equal(t, "alice=40example=2Ecom", natsutil.TokenEncodingEscape.EncodeToken("alice@example.com"))
+	assert.Equal(t, "us-east-1/a_b", natsutil.TokenEncodingEscape.EncodeToken("us-east-1/a_b"))
+	assert.Equal(t, "caf=C3=A9", natsutil.TokenEncodingEscape.EncodeToken("café"))
+	assert.Equal(t, "=3D", natsutil.TokenEncodingEscape.EncodeToken("="))
+	assert.Equal(t, "=", natsutil.TokenEncodingEscape.EncodeToken(""))
+}
+
+func TestTokenEncoding_InvalidTokens(t *testing.T) {
+	invalid := map[string][]string{
+		// truncated, lower case or unnecessary escapes have another canonical form
+		"escape": {"=4", "=4G", "alice=2ecom", "=41", "a b"},
+		// trailing bits and characters outside the alphabet
+		"base64":    {"YW", "YQ=", "a+b"},
+		"base32hex": {"C5", "W", "c4"},
+	}
+
+	for name, tokens := range invalid {
+		for _, token := range tokens {
+			_, err := tokenEncodings[name].DecodeToken(token)
+			assert.ErrorIs(t, err, natsutil.ErrTokenEncoding, "%s %q", name, token)
+		}
+	}
+}
+
+func TestTokenEncoding_Base32HexOrder(t *testing.T) {
+	values := []string{"b", "a", "ab", "a\x00", "ba", "aa", "z", "A", "0"}
+	tokens := make([]string, len(values))
+	for i, value := range values {
+		tokens[i] = natsutil.TokenEncodingBase32Hex.EncodeToken(value)
+	}
+	sort.Strings(values)
+	sort.Strings(tokens)
+	for i, token := range tokens {
+		decoded, err := natsutil.TokenEncodingBase32Hex.DecodeToken(token)
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], decoded)
+	}
+}
+
+func FuzzTokenEncoding(f *testing.F) {
+	for _, s := range unsafeStrings {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		for name, encoding := range tokenEncodings {
+			token := encoding.EncodeToken(s)
+			assert.Nil(t, natsutil.ValidateSubjectToken(token, natsutil.SubjectProfileStrict), "%s %q", name, s)
+			decoded, err := encoding.DecodeToken(token)
+			assert.Nil(t, err)
+			assert.Equal(t, s, decoded, name)
+
+			// any token which decodes must be the encoding of what it decodes to
+			if decoded, err := encoding.DecodeToken(s); err == nil {
+				assert.Equal(t, s, encoding.EncodeToken(decoded), name)
+			}
+		}
+	})
+}
+
+func TestEncodeKey(t *testing.T) {
+	enc := natsutil.TokenEncodingEscape
+
+	assert.Equal(t, "users.alice=40example.com", natsutil.EncodeKey(enc, "users.alice@example.com"))
+	assert.Equal(t, "a.=.b", natsutil.EncodeKey(enc, "a..b"))
+	assert.Equal(t, "=", natsutil.EncodeKey(enc, ""))
+	assert.Equal(t, "=2A.=3E", natsutil.EncodeKey(enc, "*.>"))
+	assert.Equal(t, "a b", natsutil.EncodeKey(nil, "a b"))
+
+	for _, key := range []string{"users.alice@example.com", "a..b", "", ".", "*.>"} {
+		decoded, err := natsutil.DecodeKey(enc, natsutil.EncodeKey(enc, key))
+		assert.Nil(t, err)
+		assert.Equal(t, key, decoded)
+	}
+
+	_, err := natsutil.DecodeKey(enc, "users.=4")
+	assert.ErrorIs(t, err, natsutil.ErrTokenEncoding)
+
+	key, err := natsutil.DecodeKey(nil, "users.=4")
+	assert.Nil(t, err)
+	assert.Equal(t, "users.=4", key)
+}
+
+func TestSubjectBuilder_PushEncoded(t *testing.T) {
+	sb := natsutil.SubjectBuilder{}
+	sb.MustPush("users")
+	assert.Nil(t, sb.PushEncoded(natsutil.TokenEncodingEscape, "alice@example.com", ""))
+	assert.Equal(t, "users.alice=40example=2Ecom.=", sb.String())
+
+	email, err := natsutil.TokenEncodingEscape.DecodeToken(sb.Token(1))
+	assert.Nil(t, err)
+	assert.Equal(t, "alice@example.com", email)
+
+	subject, err := natsutil.MustNewSubject("users").AppendEncoded(natsutil.TokenEncodingBase64, "bob smith")
+	assert.Nil(t, err)
+	assert.Equal(t, "users.Ym9iIHNtaXRo", subject.String())
+}
+
+func TestKv_KeyEncoding(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	bucket := createTestBucket(t, js)
+	kv := natsutil.NewKeyValue[int](bucket, &encoder, natsutil.WithKeyEncoding(natsutil.TokenEncodingEscape))
+	assert.Equal(t, natsutil.TokenEncodingEscape, kv.KeyEncoding())
+
+	keys := []string{"users.alice@example.com", "users.bob smith", "users.", "*"}
+	for i, key := range keys {
+		_, err := kv.Put(key, i)
+		assert.Nil(t, err, key)
+	}
+
+	// the bucket holds the encoded keys
+	raw, err := bucket.Keys()
+	assert.Nil(t, err)
+	sort.Strings(raw)
+	assert.Equal(t, []string{"=2A", "users.=", "users.alice=40example.com", "users.bob=20smith"}, raw)
+
+	for i, key := range keys {
+		entry, err := kv.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, key, entry.Key())
+		value, err := entry.UnmarshalValue()
+		assert.Nil(t, err)
+		assert.Equal(t, i, value)
+	}
+
+	revision, err := kv.Update("users.bob smith", 10, 2)
+	assert.Nil(t, err)
+	history, err := kv.History("users.bob smith")
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, "users.bob smith", history[1].Key())
+	entry, err := kv.GetRevision("users.bob smith", revision)
+	assert.Nil(t, err)
+	assert.Equal(t, "users.bob smith", entry.Key())
+
+	// wildcards in watch patterns are left as they are, the '.' in the email address separates tokens as usual
+	watcher, err := kv.Watch("users.*")
+	assert.Nil(t, err)
+	var watched []string
+	for entry := range watcher.UpdatesUnmarshalled() {
+		if entry == nil {
+			break
+		}
+		watched = append(watched, entry.Key())
+	}
+	assert.Nil(t, watcher.Stop())
+	sort.Strings(watched)
+	assert.Equal(t, []string{"users.", "users.bob smith"}, watched)
+
+	// batches return the original keys, including with direct gets
+	for _, opts := range [][]natsutil.BatchOption{nil, {natsutil.WithDirectGet(js)}} {
+		entries, err := kv.GetMany([]string{"users.alice@example.com", "*"}, opts...)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 2)
+		assert.Equal(t, "*", entries["*"].Key())
+	}
+
+	assert.Nil(t, kv.Delete("users.alice@example.com"))
+	_, err = kv.Get("users.alice@example.com")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+	assert.Nil(t, kv.Purge("*"))
+	_, err = kv.Get("*")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+
+	// keys not written with the encoding are reported as they are
+	_, err = bucket.Put("users.=4", []byte("1"))
+	assert.Nil(t, err)
+	entry, err = kv.Get("users.=4")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+	assert.Nil(t, entry)
+
+	watcher, err = kv.Watch("users.>")
+	assert.Nil(t, err)
+	watched = nil
+	for entry := range watcher.UpdatesUnmarshalled() {
+		if entry == nil {
+			break
+		}
+		watched = append(watched, entry.Key())
+	}
+	assert.Nil(t, watcher.Stop())
+	assert.Contains(t, watched, "users.=4")
+	assert.Contains(t, watched, "users.bob smith")
+}
+
+func TestEncodeKey_Dots(t *testing.T) {
+	for name, encoding := range tokenEncodings {
+		// a '.' in a key separates tokens, the key round trips but the value is split in two
+		key := "users.alice@example.com"
+		encoded := natsutil.EncodeKey(encoding, key)
+		assert.Len(t, natsutil.TokenizeSubject(encoded), 3, name)
+		decoded, err := natsutil.DecodeKey(encoding, encoded)
+		assert.Nil(t, err, name)
+		assert.Equal(t, key, decoded, name)
+
+		// encoding the value as a token escapes the '.', keeping it whole
+		sb := natsutil.SubjectBuilder{}
+		sb.MustPush("users")
+		assert.Nil(t, sb.PushEncoded(encoding, "alice@example.com", "a.b.c"), name)
+		assert.Equal(t, 3, sb.Len(), name)
+
+		for i, value := range []string{"alice@example.com", "a.b.c"} {
+			decoded, err := encoding.DecodeToken(sb.Token(i + 1))
+			assert.Nil(t, err, name)
+			assert.Equal(t, value, decoded, name)
+		}
+	}
+}
@@ -0,0 +1,571 @@
+package natsutil
+
+import (
+	"bytes"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+const (
+	ErrTransactionConflict = errors.ConstError("transaction conflicts with a concurrent write")
+	ErrTransactionClosed   = errors.ConstError("transaction has already been committed or rolled back")
+)
+
+const (
+	// DefaultJournalPrefix is the subject prefix under which journals are stored if no prefix has been configured.
+	DefaultJournalPrefix = "txn"
+	// DefaultTransactionTimeout is how long a journal can remain pending before Recover considers its transaction
+	// abandoned, if no timeout has been configured.
+	DefaultTransactionTimeout = 30 * time.Second
+)
+
+// JournalState is the progress of the transaction a journal records.
+type JournalState string
+
+const (
+	// JournalPending indicates the writes are being applied.
+	JournalPending JournalState = "pending"
+	// JournalAborted indicates any writes which were applied are being rolled back.
+	JournalAborted JournalState = "aborted"
+)
+
+// Journal records the writes a transaction intends to make, so that they can be completed or rolled back if the
+// committing process fails part way through.
+type Journal struct {
+	ID      string         `json:"id"`
+	State   JournalState   `json:"state"`
+	Created time.Time      `json:"created"`
+	Writes  []JournalWrite `json:"writes"`
+}
+
+// JournalWrite is a single write within a Journal.
+type JournalWrite struct {
+	Key string `json:"key"`
+	// Expected is the revision of the key when it was read, zero if it did not exist.
+	Expected uint64 `json:"expected,omitempty"`
+	// Value is the encoded value to write, unused when deleting.
+	Value []byte `json:"value,omitempty"`
+	// Delete is set when the key is to be deleted.
+	Delete bool `json:"delete,omitempty"`
+	// Previous is the encoded value when the key was read, used to roll the write back.
+	Previous []byte `json:"previous,omitempty"`
+}
+
+// TransactionOption configures Transactions.
+type TransactionOption func(c *transactionConfig)
+
+type transactionConfig struct {
+	prefix     []string
+	timeout    time.Duration
+	maxRetries int
+	logger     *slog.Logger
+}
+
+// WithTransactionPrefix sets the subject prefix under which journals are stored, defaults to DefaultJournalPrefix.
+func WithTransactionPrefix(prefix *SubjectBuilder) TransactionOption {
+	return func(c *transactionConfig) {
+		c.prefix = append([]string(nil), prefix.elements...)
+	}
+}
+
+// WithTransactionTimeout sets how long a journal can remain pending before Recover considers its transaction
+// abandoned, defaults to DefaultTransactionTimeout. It must comfortably exceed the time taken to commit.
+func WithTransactionTimeout(timeout time.Duration) TransactionOption {
+	return func(c *transactionConfig) {
+		c.timeout = timeout
+	}
+}
+
+// WithTransactionMaxRetries sets how many times Run attempts a transaction which conflicts with concurrent writes,
+// defaults to DefaultMaxRetries.
+func WithTransactionMaxRetries(maxRetries int) TransactionOption {
+	return func(c *transactionConfig) {
+		c.maxRetries = maxRetries
+	}
+}
+
+// WithTransactionLogger sets the logger which receives commits, rollbacks and recoveries.
+func WithTransactionLogger(logger *slog.Logger) TransactionOption {
+	return func(c *transactionConfig) {
+		c.logger = logger
+	}
+}
+
+// Transactions emulates atomic commits of writes to several keys.
+//
+// A transaction buffers its writes until it is committed. Committing first stores a journal recording every write,
+// the revision each key had when it was read and its previous value. Each write is then applied with a revision
+// guarded Update or Delete, in key order. If any write fails, for example because another writer modified the key,
+// the journal is marked aborted and the writes already applied are rolled back. Once finished the journal is deleted.
+// A process which fails part way through leaves its journal behind, Recover completes such transactions if their
+// remaining writes can still be applied and otherwise rolls them back.
+//
+// The isolation provided is limited:
+//   - Write-write conflicts are always detected, a transaction never overwrites a change it did not read.
+//   - Keys which are only read are validated before any write is applied, but not atomically with the writes.
+//   - Readers which bypass the transaction can observe it partially applied, and a rolled back transaction's
+//     writes may be briefly visible. There is no isolation from such readers.
+//   - Rolling back can fail if another writer modifies a key after it was written by the transaction. The journal is
+//     then kept so that the conflict can be resolved manually.
+type Transactions[T any] struct {
+	kv       KeyValue[T]
+	journals KeyValue[Journal]
+	config   transactionConfig
+}
+
+// NewTransactions creates Transactions for keys in kv, storing journals in the journals bucket, which can be the
+// same bucket.
+func NewTransactions[T any](kv KeyValue[T], journals KeyValue[Journal], opts ...TransactionOption) *Transactions[T] {
+	config := transactionConfig{
+		prefix:     []string{DefaultJournalPrefix},
+		timeout:    DefaultTransactionTimeout,
+		maxRetries: DefaultMaxRetries,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.logger == nil {
+		config.logger = DiscardLogger()
+	}
+	config.logger = config.logger.With(slog.String(LogKeyBucket, kv.Bucket()))
+	return &Transactions[T]{kv: kv, journals: journals, config: config}
+}
+
+func (t *Transactions[T]) journalKey(id string) string {
+	sb := SubjectBuilder{elements: append(append([]string(nil), t.config.prefix...), id)}
+	return sb.String()
+}
+
+// Begin starts a new transaction.
+func (t *Transactions[T]) Begin() *Transaction[T] {
+	return &Transaction[T]{
+		transactions: t,
+		reads:        make(map[string]transactionRead),
+		writes:       make(map[string]*transactionWrite[T]),
+	}
+}
+
+// Run calls fn with a new transaction and commits it, starting again from a new transaction if the commit conflicts
+// with a concurrent write. An error returned by fn rolls the transaction back.
+func (t *Transactions[T]) Run(fn func(tx *Transaction[T]) error) error {
+	for attempt := 1; attempt <= t.config.maxRetries; attempt++ {
+		tx := t.Begin()
+		if err := fn(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err := tx.Commit()
+		if !errors.Is(err, ErrTransactionConflict) {
+			return err
+		}
+
+		t.config.logger.Debug("transaction conflicted, retrying", slog.Int(LogKeyAttempt, attempt))
+	}
+	return ErrTooManyRetries
+}
+
+// transactionRead is the state of a key when a transaction first read it.
+type transactionRead struct {
+	revision uint64
+	value    []byte
+}
+
+// transactionWrite is a buffered write.
+type transactionWrite[T any] struct {
+	value  T
+	delete bool
+}
+
+// Transaction buffers reads and writes until it is committed.
+type Transaction[T any] struct {
+	transactions *Transactions[T]
+	reads        map[string]transactionRead
+	writes       map[string]*transactionWrite[T]
+	closed       bool
+}
+
+// read returns the state of the key as first read by the transaction.
+func (tx *Transaction[T]) read(key string) (transactionRead, error) {
+	if read, ok := tx.reads[key]; ok {
+		return read, nil
+	}
+
+	var read transactionRead
+	entry, err := tx.transactions.kv.Get(key)
+	if err == nil {
+		read = transactionRead{revision: entry.Revision(), value: entry.Value()}
+	} else if !errors.Is(err, nats.ErrKeyNotFound) {
+		return read, err
+	}
+
+	tx.reads[key] = read
+	return read, nil
+}
+
+// Get returns the value of the key as seen by the transaction, including its own buffered writes, and whether the
+// key exists. The revision read is checked when the transaction is committed.
+func (tx *Transaction[T]) Get(key string) (value T, exists bool, err error) {
+	if tx.closed {
+		return value, false, ErrTransactionClosed
+	}
+
+	if write, ok := tx.writes[key]; ok {
+		if write.delete {
+			return value, false, nil
+		}
+		return write.value, true, nil
+	}
+
+	read, err := tx.read(key)
+	if err != nil || read.revision == 0 {
+		return value, false, err
+	}
+
+	if err = tx.transactions.kv.Encoder().Decode("", read.value, &value); err != nil {
+		return value, true, errors.Annotatef(err, "failed to decode value for key %q", key)
+	}
+	return value, true, nil
+}
+
+// Put buffers a write of the value to the key.
+func (tx *Transaction[T]) Put(key string, value T) error {
+	return tx.write(key, &transactionWrite[T]{value: value})
+}
+
+// Delete buffers a delete of the key.
+func (tx *Transaction[T]) Delete(key string) error {
+	return tx.write(key, &transactionWrite[T]{delete: true})
+}
+
+func (tx *Transaction[T]) write(key string, write *transactionWrite[T]) error {
+	if tx.closed {
+		return ErrTransactionClosed
+	}
+	// the key is read so that the write can be guarded by its revision, unless it already has been
+	if _, err := tx.read(key); err != nil {
+		return err
+	}
+	tx.writes[key] = write
+	return nil
+}
+
+// Rollback discards the transaction, nothing has been written until it is committed.
+func (tx *Transaction[T]) Rollback() {
+	tx.closed = true
+}
+
+// Commit applies the buffered writes, returning ErrTransactionConflict if any key has been modified since the
+// transaction read it. If the commit fails the writes already applied are rolled back.
+func (tx *Transaction[T]) Commit() error {
+	if tx.closed {
+		return ErrTransactionClosed
+	}
+	tx.closed = true
+
+	t := tx.transactions
+
+	// validate the keys which were only read
+	for key, read := range tx.reads {
+		if _, ok := tx.writes[key]; ok {
+			continue
+		}
+		current, err := tx.currentRevision(key)
+		if err != nil {
+			return err
+		}
+		if current != read.revision {
+			return errors.Annotatef(ErrTransactionConflict, "key %q was modified after it was read", key)
+		}
+	}
+
+	journal := Journal{ID: nuid.Next(), State: JournalPending, Created: time.Now()}
+	for key, write := range tx.writes {
+		read := tx.reads[key]
+		if write.delete && read.revision == 0 {
+			// deleting a key which does not exist changes nothing
+			continue
+		}
+
+		jw := JournalWrite{Key: key, Expected: read.revision, Delete: write.delete, Previous: read.value}
+		if !write.delete {
+			value, err := t.kv.Encoder().Encode("", write.value)
+			if err != nil {
+				return errors.Annotatef(err, "failed to encode value for key %q", key)
+			}
+			jw.Value = value
+		}
+		journal.Writes = append(journal.Writes, jw)
+	}
+
+	if len(journal.Writes) == 0 {
+		return nil
+	}
+	sort.Slice(journal.Writes, func(i, j int) bool { return journal.Writes[i].Key < journal.Writes[j].Key })
+
+	logger := t.config.logger.With(slog.String("journal", journal.ID))
+
+	journalKey := t.journalKey(journal.ID)
+	journalRevision, err := t.journals.Create(journalKey, journal)
+	if err != nil {
+		return errors.Annotate(err, "failed to store journal")
+	}
+
+	applied := make([]uint64, 0, len(journal.Writes))
+	for _, write := range journal.Writes {
+		revision, err := t.apply(write)
+		if err == nil {
+			applied = append(applied, revision)
+			continue
+		}
+
+		if IsWrongRevision(err) {
+			err = errors.Annotatef(ErrTransactionConflict, "key %q was modified after it was read", write.Key)
+		} else {
+			err = errors.Annotatef(err, "failed to write key %q", write.Key)
+		}
+
+		logger.Debug("rolling back transaction", slog.Any("error", err))
+
+		journal.State = JournalAborted
+		if _, jErr := t.journals.Update(journalKey, journal, journalRevision); jErr != nil {
+			return errors.Annotatef(err, "failed to mark journal aborted, it will be rolled back by Recover: %v", jErr)
+		}
+
+		for idx, revision := range applied {
+			if rbErr := t.rollback(journal.Writes[idx], revision); rbErr != nil {
+				logger.Error("failed to roll back transaction", slog.Any("error", rbErr))
+				return errors.Annotatef(err, "failed to roll back, journal %q has been kept: %v", journalKey, rbErr)
+			}
+		}
+
+		t.deleteJournal(journalKey, logger)
+		return err
+	}
+
+	t.deleteJournal(journalKey, logger)
+	logger.Debug("transaction committed", slog.Int("writes", len(journal.Writes)))
+	return nil
+}
+
+// currentRevision returns the latest revision of the key, zero if it does not exist.
+func (tx *Transaction[T]) currentRevision(key string) (uint64, error) {
+	entry, err := tx.transactions.kv.Get(key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return entry.Revision(), nil
+}
+
+// deleteJournal removes a finished journal, a failure leaves it to be cleaned up by Recover.
+func (t *Transactions[T]) deleteJournal(key string, logger *slog.Logger) {
+	if err := t.journals.Purge(key); err != nil {
+		logger.Warn("failed to delete journal", slog.Any("error", err))
+	}
+}
+
+// apply makes a journalled write, guarded by the revision the key had when it was read.
+func (t *Transactions[T]) apply(write JournalWrite) (uint64, error) {
+	delegate, key := t.kv.Delegate(), EncodeKey(t.kv.KeyEncoding(), write.Key)
+	switch {
+	case write.Delete:
+		if err := delegate.Delete(key, nats.LastRevision(write.Expected)); err != nil {
+			return 0, err
+		}
+		// the delete marker has no revision of its own to return, so we find it in the history
+		return t.appliedRevision(write)
+	case write.Expected == 0:
+		return delegate.Create(key, write.Value)
+	default:
+		return delegate.Update(key, write.Value, write.Expected)
+	}
+}
+
+// rollback restores the value a key had before a journalled write was applied at revision.
+func (t *Transactions[T]) rollback(write JournalWrite, revision uint64) error {
+	delegate, key := t.kv.Delegate(), EncodeKey(t.kv.KeyEncoding(), write.Key)
+	if write.Expected == 0 {
+		return delegate.Delete(key, nats.LastRevision(revision))
+	}
+	_, err := delegate.Update(key, write.Previous, revision)
+	return err
+}
+
+// latest returns the most recent entry for the key, including delete markers.
+func (t *Transactions[T]) latest(key string) (nats.KeyValueEntry, error) {
+	entries, err := t.kv.Delegate().History(EncodeKey(t.kv.KeyEncoding(), key))
+	if err != nil {
+		return nil, err
+	}
+	return entries[len(entries)-1], nil
+}
+
+// matches reports whether the entry is the result of applying the write.
+func (w JournalWrite) matches(entry nats.KeyValueEntry) bool {
+	if w.Delete {
+		return entry.Operation() == nats.KeyValueDelete
+	}
+	return entry.Operation() == nats.KeyValuePut && bytes.Equal(entry.Value(), w.Value)
+}
+
+// appliedRevision returns the revision a journalled write was applied at, or zero if it has not been applied.
+//
+// As every write is guarded by the revision which was read, a write which was applied is the first to follow that
+// revision. Where the key did not exist it is assumed to have been applied if the latest value matches.
+func (t *Transactions[T]) appliedRevision(write JournalWrite) (uint64, error) {
+	entries, err := t.kv.Delegate().History(EncodeKey(t.kv.KeyEncoding(), write.Key))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	if write.Expected == 0 {
+		if latest := entries[len(entries)-1]; write.matches(latest) {
+			return latest.Revision(), nil
+		}
+		return 0, nil
+	}
+
+	for _, entry := range entries {
+		if entry.Revision() > write.Expected {
+			if write.matches(entry) {
+				return entry.Revision(), nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, nil
+}
+
+// Recover completes or rolls back transactions whose journals have been pending for longer than the timeout,
+// returning how many were committed and how many rolled back. Journals which cannot be resolved because a key has
+// since been modified by another writer are kept and reported in the error.
+func (t *Transactions[T]) Recover() (committed int, aborted int, err error) {
+	sb := SubjectBuilder{elements: append([]string(nil), t.config.prefix...)}
+	if err = sb.Star(); err != nil {
+		return 0, 0, err
+	}
+
+	watcher, err := t.journals.Watch(sb.String(), nats.IgnoreDeletes())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var entries []KeyValueEntry[Journal]
+	for entry := range watcher.UpdatesUnmarshalled() {
+		if entry == nil {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	if err = watcher.Stop(); err != nil {
+		return 0, 0, err
+	}
+
+	errs := make(map[string]error)
+	for _, entry := range entries {
+		journal, err := entry.UnmarshalValue()
+		if err != nil {
+			errs[entry.Key()] = errors.Annotate(err, "failed to decode journal")
+			continue
+		}
+
+		if time.Since(entry.Created()) < t.config.timeout {
+			// the transaction may still be committing
+			continue
+		}
+
+		wasCommitted, err := t.recover(entry.Key(), entry.Revision(), journal)
+		switch {
+		case err != nil:
+			errs[entry.Key()] = err
+		case wasCommitted:
+			committed++
+		default:
+			aborted++
+		}
+	}
+
+	if len(errs) > 0 {
+		return committed, aborted, &BatchError{Errors: errs}
+	}
+	return committed, aborted, nil
+}
+
+// recover resolves a single abandoned journal, reporting whether its transaction was committed.
+func (t *Transactions[T]) recover(key string, revision uint64, journal Journal) (bool, error) {
+	logger := t.config.logger.With(slog.String("journal", journal.ID))
+
+	applied := make([]uint64, len(journal.Writes))
+	for idx, write := range journal.Writes {
+		rev, err := t.appliedRevision(write)
+		if err != nil {
+			return false, err
+		}
+		applied[idx] = rev
+	}
+
+	if journal.State == JournalPending {
+		// attempt to roll forward by applying the remaining writes
+		var err error
+		for idx, write := range journal.Writes {
+			if applied[idx] != 0 {
+				continue
+			}
+			if applied[idx], err = t.apply(write); err != nil {
+				break
+			}
+		}
+
+		if err == nil {
+			t.deleteJournal(key, logger)
+			logger.Info("recovered transaction by committing it")
+			return true, nil
+		}
+
+		logger.Debug("transaction cannot be committed, rolling back", slog.Any("error", err))
+		journal.State = JournalAborted
+		if _, err = t.journals.Update(key, journal, revision); err != nil {
+			return false, errors.Annotate(err, "failed to mark journal aborted")
+		}
+	}
+
+	for idx, write := range journal.Writes {
+		if applied[idx] == 0 {
+			continue
+		}
+
+		latest, err := t.latest(write.Key)
+		if err != nil {
+			return false, err
+		}
+		if latest.Revision() != applied[idx] {
+			// the write may have been rolled back before the journal could be deleted
+			restored := latest.Operation() == nats.KeyValuePut && bytes.Equal(latest.Value(), write.Previous)
+			if write.Expected == 0 {
+				restored = latest.Operation() != nats.KeyValuePut
+			}
+			if restored {
+				continue
+			}
+			return false, errors.Annotatef(ErrTransactionConflict,
+				"key %q was modified after the transaction wrote it, so it cannot be rolled back", write.Key)
+		}
+
+		if err = t.rollback(write, applied[idx]); err != nil {
+			return false, errors.Annotatef(err, "failed to roll back key %q", write.Key)
+		}
+	}
+
+	t.deleteJournal(key, logger)
+	logger.Info("recovered transaction by rolling it back")
+	return false, nil
+}
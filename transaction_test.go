@@ -0,0 +1,308 @@
+package natsutil_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/41north/natsutil.go"
+	"github.com/nats-io/nats.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTransactions(
+	t *testing.T,
+	js nats.JetStreamContext,
+	opts ...natsutil.TransactionOption,
+) (natsutil.KeyValue[int], natsutil.KeyValue[natsutil.Journal], *natsutil.Transactions[int]) {
+	t.Helper()
+	bucket := createTestBucket(t, js)
+	kv := natsutil.NewKeyValue[int](bucket, &encoder)
+	journals := natsutil.NewKeyValue[natsutil.Journal](bucket, &encoder)
+	return kv, journals, natsutil.NewTransactions[int](kv, journals, opts...)
+}
+
+func getInt(t *testing.T, kv natsutil.KeyValue[int], key string) int {
+	t.Helper()
+	entry, err := kv.Get(key)
+	assert.Nil(t, err)
+	value, err := entry.UnmarshalValue()
+	assert.Nil(t, err)
+	return value
+}
+
+func assertNoJournals(t *testing.T, journals natsutil.KeyValue[natsutil.Journal]) {
+	t.Helper()
+	keys, err := journals.Delegate().Keys()
+	if err == nil {
+		for _, key := range keys {
+			assert.NotRegexp(t, `^txn\.`, key)
+		}
+	}
+}
+
+func TestTransactions_Commit(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv, journals, txs := newTestTransactions(t, js)
+
+	_, err := kv.Put("accounts.a", 100)
+	assert.Nil(t, err)
+	_, err = kv.Put("accounts.b", 100)
+	assert.Nil(t, err)
+	_, err = kv.Put("accounts.closed", 0)
+	assert.Nil(t, err)
+
+	tx := txs.Begin()
+
+	a, exists, err := tx.Get("accounts.a")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, 100, a)
+
+	_, exists, err = tx.Get("accounts.c")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	assert.Nil(t, tx.Put("accounts.a", a-30))
+	assert.Nil(t, tx.Put("accounts.c", 30))
+	assert.Nil(t, tx.Delete("accounts.closed"))
+	assert.Nil(t, tx.Delete("accounts.missing"))
+
+	// the transaction reads its own writes
+	a, _, err = tx.Get("accounts.a")
+	assert.Nil(t, err)
+	assert.Equal(t, 70, a)
+
+	// nothing is written until the transaction is committed
+	assert.Equal(t, 100, getInt(t, kv, "accounts.a"))
+
+	assert.Nil(t, tx.Commit())
+	assert.ErrorIs(t, tx.Commit(), natsutil.ErrTransactionClosed)
+	assert.ErrorIs(t, tx.Put("accounts.a", 0), natsutil.ErrTransactionClosed)
+
+	assert.Equal(t, 70, getInt(t, kv, "accounts.a"))
+	assert.Equal(t, 30, getInt(t, kv, "accounts.c"))
+	_, err = kv.Get("accounts.closed")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+	assertNoJournals(t, journals)
+
+	// a rolled back transaction writes nothing
+	tx = txs.Begin()
+	assert.Nil(t, tx.Put("accounts.a", 0))
+	tx.Rollback()
+	assert.ErrorIs(t, tx.Commit(), natsutil.ErrTransactionClosed)
+	assert.Equal(t, 70, getInt(t, kv, "accounts.a"))
+}
+
+func TestTransactions_Conflict(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv, journals, txs := newTestTransactions(t, js)
+
+	_, err := kv.Put("accounts.a", 100)
+	assert.Nil(t, err)
+	_, err = kv.Put("accounts.b", 100)
+	assert.Nil(t, err)
+
+	tx := txs.Begin()
+	assert.Nil(t, tx.Put("accounts.a", 50))
+	assert.Nil(t, tx.Put("accounts.b", 150))
+
+	// b is modified after the transaction read it, so a is written and then rolled back
+	_, err = kv.Put("accounts.b", 0)
+	assert.Nil(t, err)
+
+	assert.ErrorIs(t, tx.Commit(), natsutil.ErrTransactionConflict)
+	assert.Equal(t, 100, getInt(t, kv, "accounts.a"))
+	assert.Equal(t, 0, getInt(t, kv, "accounts.b"))
+	assertNoJournals(t, journals)
+
+	// keys which are only read are validated too
+	tx = txs.Begin()
+	_, _, err = tx.Get("accounts.b")
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Put("accounts.a", 0))
+
+	_, err = kv.Put("accounts.b", 1)
+	assert.Nil(t, err)
+
+	assert.ErrorIs(t, tx.Commit(), natsutil.ErrTransactionConflict)
+	assert.Equal(t, 100, getInt(t, kv, "accounts.a"))
+}
+
+func TestTransactions_Run(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv, journals, txs := newTestTransactions(t, js)
+
+	_, err := kv.Put("accounts.a", 100)
+	assert.Nil(t, err)
+	_, err = kv.Put("accounts.b", 100)
+	assert.Nil(t, err)
+
+	transfer := func(from, to string, amount int) error {
+		return txs.Run(func(tx *natsutil.Transaction[int]) error {
+			source, _, err := tx.Get(from)
+			if err != nil {
+				return err
+			}
+			target, _, err := tx.Get(to)
+			if err != nil {
+				return err
+			}
+			if err = tx.Put(from, source-amount); err != nil {
+				return err
+			}
+			return tx.Put(to, target+amount)
+		})
+	}
+
+	// concurrent transfers conflict and are retried, the total is preserved
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				assert.Nil(t, transfer("accounts.a", "accounts.b", 10))
+			} else {
+				assert.Nil(t, transfer("accounts.b", "accounts.a", 5))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 75, getInt(t, kv, "accounts.a"))
+	assert.Equal(t, 125, getInt(t, kv, "accounts.b"))
+	assertNoJournals(t, journals)
+}
+
+func TestTransactions_Recover(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	kv, journals, txs := newTestTransactions(t, js, natsutil.WithTransactionTimeout(0))
+
+	encode := func(value int) []byte {
+		bytes, err := encoder.Encode("", value)
+		assert.Nil(t, err)
+		return bytes
+	}
+
+	a, err := kv.Put("accounts.a", 100)
+	assert.Nil(t, err)
+	b, err := kv.Put("accounts.b", 100)
+	assert.Nil(t, err)
+
+	// a transaction which crashed after writing a, b can still be written so it is committed
+	_, err = kv.Put("accounts.a", 70)
+	assert.Nil(t, err)
+	_, err = journals.Put("txn.forward", natsutil.Journal{
+		ID:    "forward",
+		State: natsutil.JournalPending,
+		Writes: []natsutil.JournalWrite{
+			{Key: "accounts.a", Expected: a, Value: encode(70), Previous: encode(100)},
+			{Key: "accounts.b", Expected: b, Value: encode(130), Previous: encode(100)},
+			{Key: "accounts.c", Value: encode(1)},
+		},
+	})
+	assert.Nil(t, err)
+
+	committed, aborted, err := txs.Recover()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, committed)
+	assert.Equal(t, 0, aborted)
+
+	assert.Equal(t, 70, getInt(t, kv, "accounts.a"))
+	assert.Equal(t, 130, getInt(t, kv, "accounts.b"))
+	assert.Equal(t, 1, getInt(t, kv, "accounts.c"))
+	assertNoJournals(t, journals)
+
+	// a transaction which crashed after writing a, b has since been modified so it is rolled back
+	a, err = kv.Put("accounts.a", 0)
+	assert.Nil(t, err)
+	b, err = kv.Put("accounts.b", 0)
+	assert.Nil(t, err)
+
+	_, err = kv.Put("accounts.a", 10)
+	assert.Nil(t, err)
+	_, err = kv.Put("accounts.b", 5)
+	assert.Nil(t, err)
+
+	_, err = journals.Put("txn.backward", natsutil.Journal{
+		ID:    "backward",
+		State: natsutil.JournalPending,
+		Writes: []natsutil.JournalWrite{
+			{Key: "accounts.a", Expected: a, Value: encode(10), Previous: encode(0)},
+			{Key: "accounts.b", Expected: b, Value: encode(20), Previous: encode(0)},
+		},
+	})
+	assert.Nil(t, err)
+
+	committed, aborted, err = txs.Recover()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, committed)
+	assert.Equal(t, 1, aborted)
+
+	assert.Equal(t, 0, getInt(t, kv, "accounts.a"))
+	assert.Equal(t, 5, getInt(t, kv, "accounts.b"))
+	assertNoJournals(t, journals)
+
+	// journals which have not timed out are left alone
+	recent := natsutil.NewTransactions[int](kv, journals, natsutil.WithTransactionTimeout(time.Minute))
+	_, err = journals.Put("txn.recent", natsutil.Journal{ID: "recent", State: natsutil.JournalPending})
+	assert.Nil(t, err)
+
+	committed, aborted, err = recent.Recover()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, committed+aborted)
+
+	_, err = journals.Get("txn.recent")
+	assert.Nil(t, err)
+}
+
+func TestTransactions_KeyEncoding(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	_, js := jsClient(t, s)
+	bucket := createTestBucket(t, js)
+	kv := natsutil.NewKeyValue[int](bucket, &encoder, natsutil.WithKeyEncoding(natsutil.TokenEncodingEscape))
+	journals := natsutil.NewKeyValue[natsutil.Journal](bucket, &encoder)
+	txs := natsutil.NewTransactions[int](kv, journals)
+
+	_, err := kv.Put("accounts.alice@example.com", 100)
+	assert.Nil(t, err)
+	_, err = kv.Put("accounts.bob smith", 0)
+	assert.Nil(t, err)
+
+	// writes made through the bucket use the encoded keys
+	assert.Nil(t, txs.Run(func(tx *natsutil.Transaction[int]) error {
+		a, _, err := tx.Get("accounts.alice@example.com")
+		if err != nil {
+			return err
+		}
+		if err := tx.Put("accounts.alice@example.com", a-30); err != nil {
+			return err
+		}
+		if err := tx.Put("accounts.carol jones", 30); err != nil {
+			return err
+		}
+		return tx.Delete("accounts.bob smith")
+	}))
+
+	assert.Equal(t, 70, getInt(t, kv, "accounts.alice@example.com"))
+	assert.Equal(t, 30, getInt(t, kv, "accounts.carol jones"))
+	_, err = kv.Get("accounts.bob smith")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+	assertNoJournals(t, journals)
+}